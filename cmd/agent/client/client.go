@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -13,6 +15,7 @@ import (
 	"os"
 	"runtime"
 	rpprof "runtime/pprof"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,11 +23,14 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 
 	"github.com/kvvPro/metric-collector/cmd/agent/config"
+	"github.com/kvvPro/metric-collector/internal/auth"
 	"github.com/kvvPro/metric-collector/internal/encrypt"
 	"github.com/kvvPro/metric-collector/internal/hash"
 	"github.com/kvvPro/metric-collector/internal/metrics"
 	ip "github.com/kvvPro/metric-collector/internal/net"
 	"github.com/kvvPro/metric-collector/internal/retry"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
 
 	"go.uber.org/zap"
 )
@@ -70,8 +76,16 @@ type Client struct {
 	publicKey string
 	// Path to file where mem stats will be saved
 	MemProfile string
-	// Exchange mode
+	// Exchange mode - http, grpc or grpc-stream
 	ExchangeMode string
+	// id of tenant of metrics, empty for default tenant
+	tenant string
+	// token of agent, empty if server doesn't authenticate clients
+	token string
+	// TLS settings of connections to server, nil if TLS is disabled
+	tlsConfig *tls.Config
+	// long-lived stream to server in grpc-stream mode
+	stream pushStream
 	// wait group for sync
 	wg *sync.WaitGroup
 	// func to cancel context
@@ -80,6 +94,10 @@ type Client struct {
 
 // NewClient creates instance of client
 func NewClient(settings *config.ClientFlags) (*Client, error) {
+	tlsConfig, err := tlsconfig.Client(settings.TLSCA, settings.TLSCert, settings.TLSKey)
+	if err != nil {
+		return nil, err
+	}
 	return &Client{
 		Metrics:        Metrics{},
 		pollInterval:   settings.PollInterval,
@@ -93,6 +111,9 @@ func NewClient(settings *config.ClientFlags) (*Client, error) {
 		needToEncrypt:  settings.CryptoKey != "",
 		MemProfile:     settings.MemProfile,
 		ExchangeMode:   settings.ExchangeMode,
+		tenant:         settings.Tenant,
+		token:          settings.Token,
+		tlsConfig:      tlsConfig,
 	}, nil
 }
 
@@ -180,6 +201,8 @@ func (cli *Client) PushMetricsJSON(ctx context.Context) {
 					return cli.updateBatchMetricsJSON(m)
 				} else if cli.ExchangeMode == "grpc" {
 					return cli.updateMetrics(ctx, m)
+				} else if cli.ExchangeMode == "grpc-stream" {
+					return cli.updateMetricsStream(ctx, m)
 				} else {
 					return fmt.Errorf("uknown exchange type - %v", cli.ExchangeMode)
 				}
@@ -187,6 +210,7 @@ func (cli *Client) PushMetricsJSON(ctx context.Context) {
 			retry.Attempts(3),
 			retry.InitDelay(1000*time.Millisecond),
 			retry.Step(2000*time.Millisecond),
+			retry.DelayType(retryDelay),
 			retry.Context(ctx),
 		)
 		if err != nil {
@@ -195,9 +219,42 @@ func (cli *Client) PushMetricsJSON(ctx context.Context) {
 	}
 }
 
+// RateLimitedError is returned if server rejected request because of rate limit
+type RateLimitedError struct {
+	// delay requested by server, 0 if it isn't set
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("request is rate limited by server, retry after %v", e.RetryAfter)
+}
+
+// parseRetryAfter returns delay of Retry-After in seconds, 0 if it's invalid
+func parseRetryAfter(v string) time.Duration {
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// retryDelay waits as long as server asked, otherwise delay increases by step
+func retryDelay(n uint, err error, config *retry.Config) time.Duration {
+	var limited *RateLimitedError
+	if errors.As(err, &limited) && limited.RetryAfter > 0 {
+		return limited.RetryAfter
+	}
+	return retry.StepDelay(n, err, config)
+}
+
 func (cli *Client) updateBatchMetricsJSON(allMetrics []metrics.Metric) error {
 	client := &http.Client{}
-	url := "http://" + cli.Address + "/updates/"
+	scheme := "http://"
+	if cli.tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: cli.tlsConfig}
+		scheme = "https://"
+	}
+	url := scheme + cli.Address + "/updates/"
 
 	bodyBuffer := new(bytes.Buffer)
 	gzb := gzip.NewWriter(bodyBuffer)
@@ -209,13 +266,13 @@ func (cli *Client) updateBatchMetricsJSON(allMetrics []metrics.Metric) error {
 	}
 
 	if cli.needToEncrypt {
-		encryptedBody, err := encrypt.Encrypt(cli.publicKey, bodyBuffer.String())
+		encryptedBody, err := encrypt.EncryptEnvelope(cli.publicKey, bodyBuffer.Bytes())
 		if err != nil {
 			Sugar.Infoln("Error encode request body: ", err.Error())
 			return err
 		}
 		bodyBuffer = new(bytes.Buffer)
-		_, err = bodyBuffer.WriteString(encryptedBody)
+		_, err = bodyBuffer.Write(encryptedBody)
 		if err != nil {
 			Sugar.Infoln("Error to write request body: ", err.Error())
 			return err
@@ -232,12 +289,20 @@ func (cli *Client) updateBatchMetricsJSON(allMetrics []metrics.Metric) error {
 
 	request.Header.Set("Connection", "Keep-Alive")
 	request.Header.Set("Content-Encoding", "gzip")
+	if cli.needToEncrypt {
+		request.Header.Set(encrypt.VersionHeader, encrypt.VersionEnvelope)
+	}
 	if cli.needToHash {
 		hash := hash.GetHashSHA256(bodyBuffer.String(), cli.hashKey)
 		request.Header.Set("HashSHA256", base64.URLEncoding.EncodeToString(hash))
 	}
-	localIP := ip.GetOutboundIP(cli.Address)
-	request.Header.Set("X-Real-IP", localIP.String())
+	localIP, err := ip.OutboundIP(cli.Address)
+	if err != nil {
+		return err
+	}
+	for k, v := range cli.requestHeaders(localIP.String()) {
+		request.Header.Set(k, v)
+	}
 
 	response, err := client.Do(request)
 	if err != nil {
@@ -262,6 +327,10 @@ func (cli *Client) updateBatchMetricsJSON(allMetrics []metrics.Metric) error {
 
 	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitedError{RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"))}
+	}
+
 	return nil
 }
 
@@ -305,4 +374,22 @@ func (cli *Client) Stop() {
 
 	cli.cancelFunc()
 	cli.wg.Wait()
+
+	cli.closeStream()
+}
+
+// requestHeaders returns headers of requests to server which are sent
+// as HTTP headers or gRPC metadata
+func (cli *Client) requestHeaders(localIP string) map[string]string {
+	headers := map[string]string{"X-Real-IP": localIP}
+	if cli.token != "" {
+		headers[auth.Header] = auth.Bearer(cli.token)
+	}
+	if cli.tenant != "" {
+		headers[tenant.Header] = cli.tenant
+		if cli.needToHash {
+			headers[tenant.SignHeader] = tenant.Sign(cli.tenant, cli.hashKey)
+		}
+	}
+	return headers
 }
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/kvvPro/metric-collector/internal/encrypt"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/tools/certs"
+)
+
+func TestClient_updateBatchMetricsJSON_Encrypted(t *testing.T) {
+	Sugar = *zap.NewNop().Sugar()
+	dir := t.TempDir()
+	keys := &certs.Settings{PathToCert: filepath.Join(dir, "key.pub"), PathToPrivateKey: filepath.Join(dir, "key")}
+	require.NoError(t, certs.MakeRSACert(keys))
+
+	var version string
+	var received []metrics.Metric
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.Header.Get(encrypt.VersionHeader)
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		plain, err := encrypt.DecryptVersion(keys.PathToPrivateKey, version, data)
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(strings.NewReader(string(plain)))
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(gz).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cli := &Client{
+		Address:       strings.TrimPrefix(ts.URL, "http://"),
+		publicKey:     keys.PathToCert,
+		needToEncrypt: true,
+	}
+
+	// batch of runtime metrics is larger than RSA block
+	batch := make([]metrics.Metric, 0, 200)
+	for i := 0; i < 200; i++ {
+		value := float64(i) * 1.37
+		batch = append(batch, metrics.Metric{ID: fmt.Sprintf("Gauge%d", i), MType: metrics.MetricTypeGauge, Value: &value})
+	}
+	require.NoError(t, cli.updateBatchMetricsJSON(batch))
+	assert.Equal(t, encrypt.VersionEnvelope, version)
+	assert.Equal(t, batch, received)
+}
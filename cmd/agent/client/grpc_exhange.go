@@ -2,11 +2,17 @@ package client
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	// импортируем пакет со сгенерированными protobuf-файлами
 	"github.com/kvvPro/metric-collector/internal/metrics"
@@ -16,7 +22,7 @@ import (
 
 func (cli *Client) updateMetrics(ctx context.Context, allMetrics []metrics.Metric) error {
 	// устанавливаем соединение с сервером
-	conn, err := grpc.Dial(cli.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(cli.Address, grpc.WithTransportCredentials(cli.transportCredentials()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -37,11 +43,20 @@ func (cli *Client) updateMetrics(ctx context.Context, allMetrics []metrics.Metri
 		})
 	}
 
-	localIP := ip.GetOutboundIP(cli.Address)
-	md := metadata.New(map[string]string{"X-Real-IP": localIP.String()})
+	localIP, err := ip.OutboundIP(cli.Address)
+	if err != nil {
+		return err
+	}
+	md := metadata.New(cli.requestHeaders(localIP.String()))
 	ctxClient := metadata.NewOutgoingContext(ctx, md)
 
-	response, err := c.PushMetrics(ctxClient, &req)
+	var header metadata.MD
+	response, err := c.PushMetrics(ctxClient, &req, grpc.Header(&header))
+	if status.Code(err) == codes.ResourceExhausted {
+		if v := header.Get("Retry-After"); len(v) > 0 {
+			return &RateLimitedError{RetryAfter: parseRetryAfter(v[0])}
+		}
+	}
 	if response == nil || err != nil {
 		// smth is wrong
 		return err
@@ -49,3 +64,227 @@ func (cli *Client) updateMetrics(ctx context.Context, allMetrics []metrics.Metri
 
 	return nil
 }
+
+// pushStream is a long-lived stream to server shared by all push workers
+type pushStream struct {
+	mx     sync.Mutex
+	conn   *grpc.ClientConn
+	stream pb.MetricServer_PushMetricsStreamClient
+	// closed when reading of acknowledgements is finished
+	done chan struct{}
+	// acknowledgements of batches sent to stream
+	acks *streamAcks
+}
+
+// streamAcks delivers acknowledgements of server to batches waiting for them.
+// Batches are numbered from 1 in order of sending, server acknowledges count of
+// applied batches, so all batches with number up to the count are applied
+type streamAcks struct {
+	mx sync.Mutex
+	// count of sent batches
+	sent    int64
+	waiters map[int64]chan error
+	// error which finished stream, nil while stream is alive
+	err error
+}
+
+func newStreamAcks() *streamAcks {
+	return &streamAcks{waiters: make(map[int64]chan error)}
+}
+
+// next returns channel which receives result of the next sent batch
+func (a *streamAcks) next() <-chan error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	a.sent++
+	ch := make(chan error, 1)
+	if a.err != nil {
+		ch <- a.err
+		return ch
+	}
+	a.waiters[a.sent] = ch
+	return ch
+}
+
+// ack releases batches applied by server
+func (a *streamAcks) ack(batches int64) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	for n, ch := range a.waiters {
+		if n <= batches {
+			ch <- nil
+			delete(a.waiters, n)
+		}
+	}
+}
+
+// fail passes err to all batches which weren't acknowledged
+func (a *streamAcks) fail(err error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+	for n, ch := range a.waiters {
+		ch <- a.err
+		delete(a.waiters, n)
+	}
+}
+
+// failed returns true if stream is finished
+func (a *streamAcks) failed() bool {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.err != nil
+}
+
+const (
+	// timeout to wait the final ack from server on close
+	streamCloseTimeout = 5 * time.Second
+	// timeout to wait ack of batch, server acks batches at least every second
+	streamAckTimeout = 30 * time.Second
+)
+
+var errStreamAckTimeout = errors.New("server didn't acknowledge batch")
+
+// updateMetricsStream sends metrics to server through the long-lived stream and waits
+// until server acknowledges them, so rejected batches are retried by caller.
+// Stream is opened on the first call and reopened after any error.
+func (cli *Client) updateMetricsStream(ctx context.Context, allMetrics []metrics.Metric) error {
+	req := pb.PushMetricsRequest{
+		Metrics: make([]*pb.Metric, 0, len(allMetrics)),
+	}
+	for _, el := range allMetrics {
+		req.Metrics = append(req.Metrics, &pb.Metric{
+			ID:    el.ID,
+			MType: el.MType,
+			Delta: el.Delta,
+			Value: el.Value,
+		})
+	}
+
+	acked, err := cli.sendToStream(&req)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-acked:
+		return err
+	case <-time.After(streamAckTimeout):
+		return errStreamAckTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendToStream sends batch and returns channel which receives result of batch.
+// Workers wait acks without lock, so several batches can be in flight
+func (cli *Client) sendToStream(req *pb.PushMetricsRequest) (<-chan error, error) {
+	cli.stream.mx.Lock()
+	defer cli.stream.mx.Unlock()
+
+	if cli.stream.stream != nil && cli.stream.acks.failed() {
+		// server finished stream, e.g. after rejected batch
+		cli.resetStream()
+	}
+	if cli.stream.stream == nil {
+		if err := cli.openStream(); err != nil {
+			return nil, err
+		}
+	}
+
+	acked := cli.stream.acks.next()
+	if err := cli.stream.stream.Send(req); err != nil {
+		// stream is broken, it will be reopened on retry
+		cli.stream.acks.fail(err)
+		cli.resetStream()
+		return nil, err
+	}
+	return acked, nil
+}
+
+// openStream dials server and opens PushMetricsStream,
+// acknowledgements from server are read in a separate goroutine.
+// Stream doesn't depend on context of push workers, so it can be closed
+// gracefully after workers are stopped.
+func (cli *Client) openStream() error {
+	conn, err := grpc.Dial(cli.Address, grpc.WithTransportCredentials(cli.transportCredentials()))
+	if err != nil {
+		return err
+	}
+
+	localIP, err := ip.OutboundIP(cli.Address)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	md := metadata.New(cli.requestHeaders(localIP.String()))
+	ctxClient := metadata.NewOutgoingContext(context.Background(), md)
+
+	stream, err := pb.NewMetricServerClient(conn).PushMetricsStream(ctxClient)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	acks := newStreamAcks()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				Sugar.Infoln("Push stream closed: ", err.Error())
+				acks.fail(err)
+				return
+			}
+			Sugar.Infoln("Server applied metrics: ", ack.Applied, " batches: ", ack.Batches)
+			acks.ack(ack.Batches)
+			if ack.RetryAfterMs > 0 {
+				// the next batch is rejected by rate limit and server finishes stream
+				acks.fail(&RateLimitedError{RetryAfter: time.Duration(ack.RetryAfterMs) * time.Millisecond})
+			}
+		}
+	}()
+
+	cli.stream.conn = conn
+	cli.stream.stream = stream
+	cli.stream.done = done
+	cli.stream.acks = acks
+	return nil
+}
+
+func (cli *Client) resetStream() {
+	if cli.stream.conn != nil {
+		cli.stream.conn.Close()
+	}
+	cli.stream.conn = nil
+	cli.stream.stream = nil
+	cli.stream.done = nil
+	cli.stream.acks = nil
+}
+
+// closeStream closes send direction of the stream, so server sends the final ack
+func (cli *Client) closeStream() {
+	cli.stream.mx.Lock()
+	defer cli.stream.mx.Unlock()
+
+	if cli.stream.stream != nil {
+		if err := cli.stream.stream.CloseSend(); err == nil {
+			select {
+			case <-cli.stream.done:
+			case <-time.After(streamCloseTimeout):
+			}
+		}
+	}
+	cli.resetStream()
+}
+
+// transportCredentials returns credentials of connections to server, TLS is used if it's configured
+func (cli *Client) transportCredentials() credentials.TransportCredentials {
+	if cli.tlsConfig != nil {
+		return credentials.NewTLS(cli.tlsConfig)
+	}
+	return insecure.NewCredentials()
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// streamServer acknowledges each batch of stream, batches with metric "Rejected"
+// are rejected after ack of previous batches as server does, batches with metric
+// "Limited" are rejected by rate limit
+type streamServer struct {
+	pb.UnimplementedMetricServerServer
+	applied atomic.Int64
+	streams atomic.Int64
+}
+
+func (s *streamServer) PushMetricsStream(stream pb.MetricServer_PushMetricsStreamServer) error {
+	s.streams.Add(1)
+	var ack pb.PushMetricsAck
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if in.Metrics[0].ID == "Rejected" {
+			return status.Error(codes.InvalidArgument, "rejected")
+		}
+		if in.Metrics[0].ID == "Limited" {
+			stream.Send(&pb.PushMetricsAck{Applied: ack.Applied, Batches: ack.Batches, RetryAfterMs: 1500})
+			return status.Error(codes.ResourceExhausted, "too many requests")
+		}
+		s.applied.Add(int64(len(in.Metrics)))
+		ack.Applied += int64(len(in.Metrics))
+		ack.Batches++
+		if err := stream.Send(&ack); err != nil {
+			return err
+		}
+	}
+}
+
+func TestClient_updateMetricsStream_Rejected(t *testing.T) {
+	Sugar = *zap.NewNop().Sugar()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fake := &streamServer{}
+	s := grpc.NewServer()
+	pb.RegisterMetricServerServer(s, fake)
+	go s.Serve(listen)
+	defer s.Stop()
+
+	cli := &Client{Address: listen.Addr().String()}
+	defer cli.closeStream()
+	value := 1.5
+	batch := func(id string) []metrics.Metric {
+		return []metrics.Metric{{ID: id, MType: metrics.MetricTypeGauge, Value: &value}}
+	}
+
+	ctx := context.Background()
+	require.NoError(t, cli.updateMetricsStream(ctx, batch("Alloc")))
+
+	// rejection is returned to the sender of batch, so it can be retried
+	err = cli.updateMetricsStream(ctx, batch("Rejected"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// stream is reopened after rejection
+	require.NoError(t, cli.updateMetricsStream(ctx, batch("HeapAlloc")))
+	assert.Equal(t, int64(2), fake.applied.Load())
+	assert.Equal(t, int64(2), fake.streams.Load())
+
+	// delay of rate limit from ack is used by retry of batch
+	err = cli.updateMetricsStream(ctx, batch("Limited"))
+	var limited *RateLimitedError
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, 1500*time.Millisecond, limited.RetryAfter)
+	require.NoError(t, cli.updateMetricsStream(ctx, batch("Alloc")))
+	assert.Equal(t, int64(3), fake.streams.Load())
+}
+
+func TestClient_UnresolvedServer(t *testing.T) {
+	Sugar = *zap.NewNop().Sugar()
+	cli := &Client{Address: "server.invalid:3200"}
+	defer cli.closeStream()
+	value := 1.5
+	batch := []metrics.Metric{{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value}}
+
+	// error is returned to sender of batch instead of exit of agent
+	assert.Error(t, cli.updateMetrics(context.Background(), batch))
+	assert.Error(t, cli.updateMetricsStream(context.Background(), batch))
+	assert.Error(t, cli.updateBatchMetricsJSON(batch))
+}
+
+func TestStreamAcks(t *testing.T) {
+	acks := newStreamAcks()
+	first, second, third := acks.next(), acks.next(), acks.next()
+
+	acks.ack(2)
+	assert.NoError(t, <-first)
+	assert.NoError(t, <-second)
+
+	failure := errors.New("stream closed")
+	acks.fail(failure)
+	assert.ErrorIs(t, <-third, failure)
+	assert.True(t, acks.failed())
+	assert.ErrorIs(t, <-acks.next(), failure)
+}
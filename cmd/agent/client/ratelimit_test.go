@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/retry"
+)
+
+func TestClient_updateBatchMetricsJSON_RateLimited(t *testing.T) {
+	Sugar = *zap.NewNop().Sugar()
+	var limited atomic.Bool
+	limited.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limited.Load() {
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cli := &Client{Address: strings.TrimPrefix(ts.URL, "http://")}
+	poll := int64(1)
+	m := []metrics.Metric{{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &poll}}
+
+	err := cli.updateBatchMetricsJSON(m)
+	var rateErr *RateLimitedError
+	require.ErrorAs(t, err, &rateErr)
+	assert.Equal(t, 2*time.Second, rateErr.RetryAfter)
+
+	limited.Store(false)
+	assert.NoError(t, cli.updateBatchMetricsJSON(m))
+}
+
+type recordingTimer struct {
+	delays []time.Duration
+}
+
+func (r *recordingTimer) After(d time.Duration) <-chan time.Time {
+	r.delays = append(r.delays, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func TestRetryDelay(t *testing.T) {
+	timer := &recordingTimer{}
+	errs := []error{&RateLimitedError{RetryAfter: 5 * time.Second}, assert.AnError, nil}
+	var n int
+	err := retry.Do(func() error {
+		err := errs[n]
+		n++
+		return err
+	},
+		retry.Attempts(3),
+		retry.InitDelay(time.Second),
+		retry.Step(2*time.Second),
+		retry.DelayType(retryDelay),
+		retry.WithTimer(timer),
+	)
+	require.NoError(t, err)
+	// delay of server is used instead of step delay
+	assert.Equal(t, []time.Duration{5 * time.Second, 3 * time.Second}, timer.delays)
+}
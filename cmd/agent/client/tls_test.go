@@ -0,0 +1,49 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/kvvPro/metric-collector/cmd/agent/config"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig/tlstest"
+)
+
+func TestClient_updateBatchMetricsJSON_TLS(t *testing.T) {
+	Sugar = *zap.NewNop().Sugar()
+	files := tlstest.Generate(t, "agent-1")
+
+	var peer string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = tlsconfig.PeerName(r.TLS)
+		w.WriteHeader(http.StatusOK)
+	}))
+	cfg, err := tlsconfig.Server(files.ServerCert, files.ServerKey, files.CA)
+	require.NoError(t, err)
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	cli, err := NewClient(&config.ClientFlags{
+		Address: strings.TrimPrefix(ts.URL, "https://"),
+		TLSCA:   files.CA,
+		TLSCert: files.ClientCert,
+		TLSKey:  files.ClientKey,
+	})
+	require.NoError(t, err)
+
+	value := 1.5
+	err = cli.updateBatchMetricsJSON([]metrics.Metric{{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value}})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", peer)
+
+	_, err = NewClient(&config.ClientFlags{TLSCert: files.ClientCert})
+	assert.Error(t, err)
+}
@@ -19,6 +19,11 @@ type ClientFlags struct {
 	MemProfile     string `env:"MEM_PROFILE" json:"mem_profile"`
 	CryptoKey      string `env:"CRYPTO_KEY" json:"crypto_key"`
 	ExchangeMode   string `env:"EXCHANGE_MODE" json:"exchange_mode"`
+	Tenant         string `env:"TENANT" json:"tenant"`
+	Token          string `env:"TOKEN" json:"token"`
+	TLSCA          string `env:"TLS_CA" json:"tls_ca"`
+	TLSCert        string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey         string `env:"TLS_KEY" json:"tls_key"`
 	Config         string `env:"CONFIG" json:"config"`
 }
 
@@ -36,7 +41,12 @@ func Initialize(agentFlags *ClientFlags) error {
 		"Max count of parallel outbound requests to server")
 	pflag.StringVarP(&agentFlags.MemProfile, "mem", "m", "base.pprof", "Path to file where mem stats will be saved")
 	pflag.StringVarP(&agentFlags.CryptoKey, "crypto-key", "e", "/workspaces/metric-collector/cmd/keys/key.pub", "Path to public key RSA to encrypt messages")
-	pflag.StringVarP(&agentFlags.ExchangeMode, "exchange-mode", "x", "http", "Exchange mode - http or grpc")
+	pflag.StringVarP(&agentFlags.ExchangeMode, "exchange-mode", "x", "http", "Exchange mode - http, grpc or grpc-stream")
+	pflag.StringVar(&agentFlags.Tenant, "tenant", "", "Tenant of metrics, key is used to sign tenant id. Default tenant of server if empty")
+	pflag.StringVar(&agentFlags.Token, "token", "", "Token of agent with push scope if server authenticates clients")
+	pflag.StringVar(&agentFlags.TLSCA, "tls-ca", "", "Path to PEM CA of server certificate, system CAs are used if empty. TLS is enabled if CA or certificate is set")
+	pflag.StringVar(&agentFlags.TLSCert, "tls-cert", "", "Path to PEM client certificate of agent, its common name identifies agent on server")
+	pflag.StringVar(&agentFlags.TLSKey, "tls-key", "", "Path to PEM private key of client certificate")
 
 	//pflag.StringVarP(&agentFlags.Config, "config", "c", "/workspaces/metric-collector/cmd/agent/config/config.json", "Path to agent config file")
 
@@ -51,6 +61,11 @@ func Initialize(agentFlags *ClientFlags) error {
 	fmt.Printf("\nMEM_PROFILE=%v", agentFlags.MemProfile)
 	fmt.Printf("\nCRYPTO_KEY=%v", agentFlags.CryptoKey)
 	fmt.Printf("\nEXCHANGE_MODE=%v", agentFlags.ExchangeMode)
+	fmt.Printf("\nTENANT=%v", agentFlags.Tenant)
+	fmt.Printf("\nTOKEN=%v", agentFlags.Token != "")
+	fmt.Printf("\nTLS_CA=%v", agentFlags.TLSCA)
+	fmt.Printf("\nTLS_CERT=%v", agentFlags.TLSCert)
+	fmt.Printf("\nTLS_KEY=%v", agentFlags.TLSKey)
 	fmt.Printf("\nCONFIG=%v", agentFlags.Config)
 	fmt.Println()
 
@@ -68,6 +83,11 @@ func Initialize(agentFlags *ClientFlags) error {
 	fmt.Printf("\nMEM_PROFILE=%v", agentFlags.MemProfile)
 	fmt.Printf("\nCRYPTO_KEY=%v", agentFlags.CryptoKey)
 	fmt.Printf("\nEXCHANGE_MODE=%v", agentFlags.ExchangeMode)
+	fmt.Printf("\nTENANT=%v", agentFlags.Tenant)
+	fmt.Printf("\nTOKEN=%v", agentFlags.Token != "")
+	fmt.Printf("\nTLS_CA=%v", agentFlags.TLSCA)
+	fmt.Printf("\nTLS_CERT=%v", agentFlags.TLSCert)
+	fmt.Printf("\nTLS_KEY=%v", agentFlags.TLSKey)
 	fmt.Printf("\nCONFIG=%v", agentFlags.Config)
 
 	return nil
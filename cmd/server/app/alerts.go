@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// newAlertEngines creates engine of rules for each tenant, so rules match names of metrics
+// as tenant sends them. The only engine has empty key if multi-tenancy is disabled
+func (srv *Server) newAlertEngines(rules []alert.Rule) map[string]*alert.Engine {
+	engines := make(map[string]*alert.Engine)
+	for _, id := range srv.tenantIDs() {
+		id := id
+		engine := alert.NewEngine(rules, querySource{srv: srv, tenant: id})
+		engine.OnChange(func(a alert.Alert) {
+			a.Tenant = id
+			srv.notifyAlert(a)
+		})
+		engines[id] = engine
+	}
+	return engines
+}
+
+// startAlerting runs evaluation of alerting rules until ctx is done
+func (srv *Server) startAlerting(ctx context.Context) {
+	if srv.alerts == nil {
+		return
+	}
+	interval := time.Duration(srv.AlertInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	for id, engine := range srv.alerts {
+		id, engine := id, engine
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			engine.Run(ctx, interval, func(err error) {
+				Sugar.Infoln("Evaluation of alerting rules failed: ", id, " ", err.Error())
+			})
+			Sugar.Infoln("остановка вычисления правил алертинга ", id)
+		}()
+	}
+}
+
+// AlertsHandle godoc
+// @Tags getvalue
+// @Summary List of alerts
+// @Description Alerts of alerting rules, pending and firing by default.
+// @Description Resolved alerts are kept for 15 minutes.
+// @ID alerts
+// @Produce json
+// @Param state query string false "State of alerts: pending, firing or resolved, can be repeated"
+// @Success 200 {array} alert.Alert
+// @Failure 400 {string} string "Invalid state"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/alerts [get]
+func (srv *Server) AlertsHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	states := []alert.State{alert.StatePending, alert.StateFiring}
+	if values, ok := r.URL.Query()["state"]; ok {
+		states = states[:0]
+		for _, v := range values {
+			state := alert.State(v)
+			switch state {
+			case alert.StatePending, alert.StateFiring, alert.StateResolved:
+				states = append(states, state)
+			default:
+				http.Error(w, "Invalid state: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// tenant sees only alerts of its metrics
+	list := []alert.Alert{}
+	if engine, ok := srv.alerts[tenant.FromContext(r.Context())]; ok {
+		list = engine.Alerts(states...)
+		for i := range list {
+			list[i].Tenant = tenant.FromContext(r.Context())
+		}
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_AlertsHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	rules := []alert.Rule{
+		{Name: "HighHeap", Query: "HeapAlloc", Op: ">", Threshold: 1000, Severity: "warning"},
+		{Name: "HeapUsage", Query: "HeapAlloc / HeapSys", Op: ">", Threshold: 0.1, For: alert.Duration(time.Minute), Severity: "critical"},
+		{Name: "LowAlloc", Query: "Alloc", Op: "<", Threshold: 1, Severity: "info"},
+	}
+	for i := range rules {
+		require.NoError(t, rules[i].Validate())
+	}
+	srv.alerts = srv.newAlertEngines(rules)
+	require.NoError(t, srv.alerts[""].Evaluate(context.Background(), time.Now()))
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   []string
+	}{
+		{name: "active by default", target: "/api/alerts", status: http.StatusOK, want: []string{"HeapUsage:pending", "HighHeap:firing"}},
+		{name: "firing", target: "/api/alerts?state=firing", status: http.StatusOK, want: []string{"HighHeap:firing"}},
+		{name: "resolved", target: "/api/alerts?state=resolved", status: http.StatusOK, want: []string{}},
+		{name: "invalid state", target: "/api/alerts?state=silenced", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			srv.AlertsHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var list []alert.Alert
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+			got := make([]string, 0, len(list))
+			for _, a := range list {
+				got = append(got, a.Rule+":"+string(a.State))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServer_AlertsHandle_Disabled(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+
+	r := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+	w := httptest.NewRecorder()
+	srv.AlertsHandle(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, "[]", w.Body.String())
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/notify"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// window of anomalies list if it isn't set in request
+const defaultAnomaliesWindow = time.Hour
+
+// detectAnomalies checks current values of updated gauges against their baselines
+func (srv *Server) detectAnomalies(current []metrics.Metric, now time.Time) {
+	for _, el := range current {
+		if el.MType != metrics.MetricTypeGauge || el.Value == nil {
+			continue
+		}
+		// pattern selects gauges by names which tenants sent, baselines are kept by names in storage
+		name := el.ID
+		if srv.tenants != nil {
+			_, name = tenant.Split(el.ID)
+		}
+		a, ok := srv.detector.ObserveKey(el.ID, name, now, *el.Value)
+		if !ok {
+			continue
+		}
+		Sugar.Infow("Anomaly detected",
+			"series", a.Series,
+			"value", a.Value,
+			"mean", a.Mean,
+			"score", a.Score,
+		)
+		if srv.notifier != nil {
+			srv.notifier.Notify(notify.Event{
+				Type:    notify.EventAnomaly,
+				Time:    now,
+				Anomaly: &a,
+			})
+		}
+	}
+}
+
+// AnomaliesHandle godoc
+// @Tags getvalue
+// @Summary List of anomalies
+// @Description Values of gauges which deviated from their baselines by more than k std deviations.
+// @Description Current deviations are available in queries as anomaly_score(name).
+// @ID anomalies
+// @Produce json
+// @Param window query string false "Time window, 1h by default"
+// @Success 200 {array} anomaly.Anomaly
+// @Failure 400 {string} string "Invalid window"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/anomalies [get]
+func (srv *Server) AnomaliesHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := defaultAnomaliesWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			http.Error(w, "Invalid window", http.StatusBadRequest)
+			return
+		}
+	}
+
+	all, err := srv.anomalies(r.Context(), time.Now().Add(-window))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// tenant sees only anomalies of its gauges
+	id := tenant.FromContext(r.Context())
+	list := []anomaly.Anomaly{}
+	for _, a := range all {
+		series, ok := tenant.Unscope(id, a.Series)
+		if !ok {
+			continue
+		}
+		a.Series = series
+		list = append(list, a)
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
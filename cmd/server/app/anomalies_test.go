@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_AnomaliesHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	cfg := anomaly.DefaultConfig()
+	cfg.Pattern = "^HeapAlloc$"
+	detector, err := anomaly.New(cfg)
+	require.NoError(t, err)
+	srv.detector = detector
+
+	ctx := context.Background()
+	update := func(v float64) {
+		require.NoError(t, srv.AddMetricsBatch(ctx, []metrics.Metric{
+			*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &v),
+			*metrics.NewCommonMetric("Alloc", metrics.MetricTypeGauge, nil, &v),
+		}))
+	}
+	for i := 0; i < 30; i++ {
+		update(1000 + float64(i%3)*10)
+	}
+
+	response, err := srv.Query(ctx, "anomaly_score(HeapAlloc)")
+	require.NoError(t, err)
+	require.Len(t, response.Result, 1)
+	assert.NotEqual(t, "0", response.Result[0].Value)
+
+	update(1e6)
+
+	response, err = srv.Query(ctx, "anomaly_score(*)")
+	require.NoError(t, err)
+	require.Len(t, response.Result, 1)
+	assert.Equal(t, "HeapAlloc", response.Result[0].Name)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   int
+	}{
+		{name: "default window", target: "/api/anomalies", status: http.StatusOK, want: 1},
+		{name: "invalid window", target: "/api/anomalies?window=soon", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			srv.AnomaliesHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var list []anomaly.Anomaly
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+			require.Len(t, list, tt.want)
+			assert.Equal(t, "HeapAlloc", list[0].Series)
+			assert.Equal(t, 1e6, list[0].Value)
+		})
+	}
+}
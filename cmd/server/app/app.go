@@ -3,13 +3,17 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"runtime"
 	rpprof "runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/kvvPro/metric-collector/proto"
@@ -17,15 +21,30 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	"github.com/kvvPro/metric-collector/internal/cluster"
+	"github.com/kvvPro/metric-collector/internal/forward"
+	"github.com/kvvPro/metric-collector/internal/history"
 	"github.com/kvvPro/metric-collector/internal/metrics"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/notify"
+	"github.com/kvvPro/metric-collector/internal/ratelimit"
+	"github.com/kvvPro/metric-collector/internal/recording"
+	"github.com/kvvPro/metric-collector/internal/replication"
 	"github.com/kvvPro/metric-collector/internal/retry"
 	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
 	"github.com/kvvPro/metric-collector/internal/storage/postgres"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/usage"
 )
 
 type Server struct {
@@ -55,6 +74,8 @@ type Server struct {
 	PrivateKeyPath string
 	// Trusted subnet to check clients ip addresses
 	TrustedSubnet string
+	// subnets of reverse proxies which X-Real-IP identifies client, the header isn't trusted if empty
+	trustedProxies []*net.IPNet
 	// http server
 	HTTPServer *http.Server
 	// Path to file where mem stats will be saved
@@ -69,6 +90,61 @@ type Server struct {
 	pb.UnimplementedMetricServerServer
 	// GRPC server
 	grpcServer *grpc.Server
+	// state of cumulative OTLP series to convert them to deltas
+	otlpState cumulativeState
+	// notifies subscribers about applied changes of metrics
+	bus changeBus
+	// time of the last update of each metric
+	updated updateTimes
+	// usage of server by clients
+	usage usage.Tracker
+	// last values of metrics for charts, nil if history isn't kept
+	history *history.Store
+	// evaluates alerting rules, nil if rules aren't set
+	alerts map[string]*alert.Engine
+	// Interval in seconds between evaluations of alerting rules
+	AlertInterval int
+	// delivers alerts and changes of metrics to webhooks, nil if webhooks aren't set
+	notifier *notify.Notifier
+	// detects anomalies of gauges, nil if detection is disabled
+	detector *anomaly.Detector
+	// evaluates recording rules, nil if rules aren't set
+	recorders map[string]*recording.Recorder
+	// Interval in seconds between evaluations of recording rules
+	RecordingInterval int
+	// sends ingested metrics to upstream servers, nil if forwarding is disabled
+	forwarder *forward.Forwarder
+	// true after start of forwarding
+	forwarding atomic.Bool
+	// routes metrics to nodes of cluster, nil if cluster mode is disabled
+	cluster *cluster.Storage
+	// Address of this node in cluster
+	ClusterSelf string
+	// server of internal endpoints of cluster if they aren't served by HTTPServer
+	clusterServer *http.Server
+	// true while this node is leader of cluster, leader sends notifications
+	// about alerts and stores results of recording rules
+	leading atomic.Bool
+	// replicates storage of leader, nil if server isn't follower
+	follower *replication.Follower
+	// true while server is follower
+	readOnly atomic.Bool
+	// stops replication on promotion
+	stopFollowing context.CancelFunc
+	// Address of Replication service for followers
+	ReplicationAddress string
+	// server of Replication service on ReplicationAddress
+	replicationServer *grpc.Server
+	// settings of tenants, nil if multi-tenancy is disabled
+	tenants *tenant.Registry
+	// limits of series and batches of clients
+	limits cardinality.Limits
+	// limits rate of requests of clients, nil if rate isn't limited
+	limiter *ratelimit.Limiter
+	// tokens of clients, nil if clients aren't authenticated
+	tokens *auth.Store
+	// TLS settings of HTTP and gRPC servers, nil if TLS is disabled
+	tlsConfig *tls.Config
 }
 
 const (
@@ -91,30 +167,160 @@ func NewServer(settings *config.ServerFlags) (*Server, error) {
 		st = newdb
 	} else {
 		t = MemStorageType
-		newmem := memstorage.NewMemStorage()
-		st = &newmem
+		st = memstorage.NewMemStorage()
 	}
 
 	if st == nil {
 		return nil, errors.New("cannot create storage for server")
 	}
 
-	return &Server{
-		storage:         st,
-		Address:         settings.Address,
-		StoreInterval:   settings.StoreInterval,
-		FileStoragePath: settings.FileStoragePath,
-		Restore:         settings.Restore,
-		DBConnection:    settings.DBConnection,
-		StorageType:     t,
-		HashKey:         settings.HashKey,
-		CheckHash:       settings.HashKey != "",
-		PrivateKeyPath:  settings.CryptoKey,
-		UseEncryption:   settings.CryptoKey != "",
-		MemProfile:      settings.MemProfile,
-		TrustedSubnet:   settings.TrustedSubnet,
-		ExchangeMode:    settings.ExchangeMode,
-	}, nil
+	srv := &Server{
+		storage:            st,
+		Address:            settings.Address,
+		StoreInterval:      settings.StoreInterval,
+		FileStoragePath:    settings.FileStoragePath,
+		Restore:            settings.Restore,
+		DBConnection:       settings.DBConnection,
+		StorageType:        t,
+		HashKey:            settings.HashKey,
+		CheckHash:          settings.HashKey != "",
+		PrivateKeyPath:     settings.CryptoKey,
+		UseEncryption:      settings.CryptoKey != "",
+		MemProfile:         settings.MemProfile,
+		TrustedSubnet:      settings.TrustedSubnet,
+		ExchangeMode:       settings.ExchangeMode,
+		history:            history.New(settings.HistorySize),
+		AlertInterval:      settings.AlertInterval,
+		RecordingInterval:  settings.RecordingInterval,
+		ClusterSelf:        settings.ClusterSelf,
+		ReplicationAddress: settings.ReplicationAddress,
+		limits: cardinality.Limits{
+			MaxSeries:             settings.MaxSeries,
+			MaxNewSeriesPerMinute: settings.MaxNewSeriesPerMinute,
+			MaxBatchSize:          settings.MaxBatchSize,
+		},
+	}
+	if err := srv.limits.Validate(); err != nil {
+		return nil, err
+	}
+	proxies, err := ip.ParseSubnets(settings.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	srv.trustedProxies = proxies
+
+	tlsConfig, err := tlsconfig.Server(settings.TLSCert, settings.TLSKey, settings.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	srv.tlsConfig = tlsConfig
+
+	if settings.Tokens != "" {
+		tokens, err := auth.NewStore(settings.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		srv.tokens = tokens
+	}
+
+	if settings.RateLimits != "" {
+		rules, err := ratelimit.ParseRules(settings.RateLimits)
+		if err != nil {
+			return nil, err
+		}
+		srv.limiter = ratelimit.NewLimiter(rules)
+	}
+
+	if settings.ReplicaOf != "" {
+		memst, ok := st.(*memstorage.MemStorage)
+		if !ok || settings.ClusterNodes != "" {
+			return nil, errors.New("replication is supported only for in-memory storage without cluster")
+		}
+		follower, err := newFollower(settings, memst)
+		if err != nil {
+			return nil, err
+		}
+		srv.follower = follower
+		srv.readOnly.Store(true)
+	}
+
+	if settings.ClusterNodes != "" {
+		// internal endpoints of nodes bypass tenants, so they are guarded by tokens
+		if settings.Tenants != "" && srv.tokens == nil {
+			return nil, errors.New("cluster with tenants requires tokens to authenticate nodes")
+		}
+		if srv.tokens != nil && settings.NodeToken == "" {
+			return nil, errors.New("cluster with tokens requires node token with admin scope")
+		}
+		if srv.ClusterSelf == "" {
+			srv.ClusterSelf = settings.Address
+		}
+		c, err := newCluster(settings, st)
+		if err != nil {
+			return nil, err
+		}
+		srv.cluster = c
+		srv.storage = c
+	}
+
+	if srv.limits.MaxSeries > 0 || srv.limits.MaxNewSeriesPerMinute > 0 {
+		srv.storage = cardinality.NewStorage(srv.storage, srv.limits)
+	}
+
+	if settings.Tenants != "" {
+		list, err := tenant.LoadTenants(settings.Tenants)
+		if err != nil {
+			return nil, err
+		}
+		registry, err := tenant.NewRegistry(list)
+		if err != nil {
+			return nil, err
+		}
+		srv.tenants = registry
+		srv.storage = tenant.NewStorage(srv.storage, registry)
+	}
+
+	if settings.AlertRules != "" {
+		rules, err := alert.LoadRules(settings.AlertRules)
+		if err != nil {
+			return nil, err
+		}
+		srv.alerts = srv.newAlertEngines(rules)
+	}
+
+	if settings.RecordingRules != "" {
+		rules, err := recording.LoadRules(settings.RecordingRules)
+		if err != nil {
+			return nil, err
+		}
+		srv.recorders = srv.newRecorders(rules)
+	}
+
+	if settings.ForwardURLs != "" {
+		forwarder, err := newForwarder(settings)
+		if err != nil {
+			return nil, err
+		}
+		srv.forwarder = forwarder
+	}
+
+	if settings.AnomalyGauges != "" {
+		cfg := anomaly.DefaultConfig()
+		cfg.Pattern = settings.AnomalyGauges
+		cfg.Alpha = settings.AnomalyAlpha
+		cfg.K = settings.AnomalyK
+		detector, err := anomaly.New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		srv.detector = detector
+	}
+
+	if settings.WebhookURLs != "" {
+		srv.notifier = newNotifier(settings)
+	}
+
+	return srv, nil
 }
 
 func (srv *Server) StartServer(ctx context.Context, srvFlags *config.ServerFlags) {
@@ -130,6 +336,20 @@ func (srv *Server) StartServer(ctx context.Context, srvFlags *config.ServerFlags
 		"srvFlags", srvFlags,
 	)
 
+	// background tasks start before server, so no ingested metric is missed
+	asyncCtx, cancel := context.WithCancel(ctx)
+	srv.cancelSaving = cancel
+	srv.AsyncSaving(asyncCtx)
+	srv.startNotifier(asyncCtx)
+	srv.startForwarding(asyncCtx)
+	srv.startLeaderElection(asyncCtx)
+	srv.startRecording(asyncCtx)
+	srv.startAlerting(asyncCtx)
+	srv.startTokensReload(asyncCtx)
+	srv.startClusterServer()
+	srv.startReplication(asyncCtx)
+	srv.startReplicationServer()
+
 	if srv.ExchangeMode == "http" {
 		srv.startHTTPServer()
 	} else if srv.ExchangeMode == "grpc" {
@@ -137,50 +357,100 @@ func (srv *Server) StartServer(ctx context.Context, srvFlags *config.ServerFlags
 	} else {
 		Sugar.Fatalf("uknown exchange mode: %v", srv.ExchangeMode)
 	}
-
-	asyncCtx, cancel := context.WithCancel(ctx)
-	srv.cancelSaving = cancel
-	srv.AsyncSaving(asyncCtx)
 }
 
 func (srv *Server) startHTTPServer() {
-	r := chi.NewMux()
-	r.Use(srv.ValidateIP,
-		srv.DecryptMiddleware,
-		srv.CheckHashMiddleware,
-		GzipMiddleware,
-		WithLogging)
-	// r.Use(app.WithLogging)
-	r.Handle("/ping", http.HandlerFunc(srv.PingHandle))
-	r.Handle("/updates/", http.HandlerFunc(srv.UpdateBatchJSONHandle))
-	r.Handle("/update/", http.HandlerFunc(srv.UpdateJSONHandle))
-	r.Handle("/update/*", http.HandlerFunc(srv.UpdateHandle))
-	r.Handle("/value/*", http.HandlerFunc(srv.GetValueHandle))
-	r.Handle("/value/", http.HandlerFunc(srv.GetValueJSONHandle))
-	r.Handle("/", http.HandlerFunc(srv.AllMetricsHandle))
-	r.Handle("/debug/pprof", http.HandlerFunc(pprof.Index))
-	r.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
-	r.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
-	r.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
-
-	// Manually add support for paths linked to by index page at /debug/pprof/
-	r.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
-	r.Handle("/debug/pprof/heap", pprof.Handler("heap"))
-	r.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
-	r.Handle("/debug/pprof/block", pprof.Handler("block"))
-
 	srv.HTTPServer = &http.Server{
-		Addr:    srv.Address,
-		Handler: r,
+		Addr:      srv.Address,
+		Handler:   srv.router(),
+		TLSConfig: srv.tlsConfig,
 	}
 	go func() {
-		if err := srv.HTTPServer.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if srv.tlsConfig != nil {
+			// сертификат сервера уже загружен в TLSConfig
+			err = srv.HTTPServer.ListenAndServeTLS("", "")
+		} else {
+			err = srv.HTTPServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			// записываем в лог ошибку, если сервер не запустился
 			Sugar.Fatalw(err.Error(), "event", "start server")
 		}
 	}()
 }
 
+// router returns handler of all HTTP endpoints of server
+func (srv *Server) router() http.Handler {
+	r := chi.NewMux()
+	r.Use(srv.ValidateIP)
+
+	r.Group(func(r chi.Router) {
+		r.Use(srv.ClientMiddleware,
+			srv.AuthMiddleware,
+			srv.RateLimitMiddleware,
+			srv.TenantMiddleware,
+			srv.DecryptMiddleware,
+			srv.CheckHashMiddleware,
+			GzipMiddleware,
+			WithLogging)
+		// r.Use(app.WithLogging)
+
+		push := r.With(srv.RequireScope(auth.ScopePush))
+		push.Handle("/updates/", http.HandlerFunc(srv.UpdateBatchJSONHandle))
+		push.Handle("/update/", http.HandlerFunc(srv.UpdateJSONHandle))
+		push.Handle("/update/*", http.HandlerFunc(srv.UpdateHandle))
+
+		read := r.With(srv.RequireScope(auth.ScopeRead))
+		read.Handle("/ping", http.HandlerFunc(srv.PingHandle))
+		read.Handle("/value/*", http.HandlerFunc(srv.GetValueHandle))
+		read.Handle("/value/", http.HandlerFunc(srv.GetValueJSONHandle))
+		read.Handle("/", http.HandlerFunc(srv.AllMetricsHandle))
+		read.Handle("/chart", http.HandlerFunc(srv.ChartHandle))
+		read.Handle("/api/watch", http.HandlerFunc(srv.WatchHandle))
+		read.Handle("/api/metrics", http.HandlerFunc(srv.ListMetricsHandle))
+		read.Handle("/api/query", http.HandlerFunc(srv.QueryHandle))
+		read.Handle("/api/rate", http.HandlerFunc(srv.CounterRateHandle))
+		read.Handle("/api/alerts", http.HandlerFunc(srv.AlertsHandle))
+		read.Handle("/api/anomalies", http.HandlerFunc(srv.AnomaliesHandle))
+
+		admin := r.With(srv.RequireScope(auth.ScopeAdmin))
+		admin.Handle("/api/replication/promote", http.HandlerFunc(srv.PromoteHandle))
+		admin.Handle("/api/tenants", http.HandlerFunc(srv.TenantsHandle))
+		admin.Handle("/api/cardinality", http.HandlerFunc(srv.CardinalityHandle))
+		admin.Handle("/debug/pprof", http.HandlerFunc(pprof.Index))
+		admin.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+		admin.Handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+		admin.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+
+		// Manually add support for paths linked to by index page at /debug/pprof/
+		admin.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
+		admin.Handle("/debug/pprof/heap", pprof.Handler("heap"))
+		admin.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
+		admin.Handle("/debug/pprof/block", pprof.Handler("block"))
+	})
+
+	// receivers of third-party protocols: their clients neither encrypt
+	// nor sign request bodies
+	r.Group(func(r chi.Router) {
+		r.Use(srv.ClientMiddleware,
+			srv.AuthMiddleware,
+			srv.RateLimitMiddleware,
+			srv.TenantMiddleware,
+			GzipMiddleware,
+			WithLogging,
+			srv.RequireScope(auth.ScopePush))
+		r.Handle("/v1/metrics", http.HandlerFunc(srv.OTLPMetricsHandle))
+		r.Handle("/api/v1/write", http.HandlerFunc(srv.RemoteWriteHandle))
+	})
+
+	// internal endpoints of cluster are served here if node is addressed by Address
+	if srv.cluster != nil && srv.clusterServer == nil {
+		r.Group(srv.clusterRoutes)
+	}
+	return r
+}
+
 func (srv *Server) StopServer(ctx context.Context) {
 	// создаём файл журнала профилирования памяти
 	fmem, err := os.Create(srv.MemProfile)
@@ -207,6 +477,8 @@ func (srv *Server) StopServer(ctx context.Context) {
 	} else {
 		Sugar.Fatalf("uknown exchange mode: %v", srv.ExchangeMode)
 	}
+	srv.stopClusterServer(ctx)
+	srv.stopReplicationServer()
 
 	srv.StopAsyncSaving()
 }
@@ -233,19 +505,28 @@ func (srv *Server) Ping(ctx context.Context) error {
 //
 // Deprecated: use AddMetricNew
 func (srv *Server) AddMetric(ctx context.Context, metricType string, metricName string, metricValue string) error {
+	if srv.readOnly.Load() {
+		return errReadOnly
+	}
 	err := srv.storage.Update(ctx, metricType, metricName, metricValue)
 	if err != nil {
 		return err
 	}
+
+	srv.afterUpdate(ctx, []metrics.Metric{{ID: metricName, MType: metricType}})
 	return nil
 }
 
 // AddMetricNew adds new metric if it doesn't exist, or update existing metric with name metricName
 func (srv *Server) AddMetricNew(ctx context.Context, m metrics.Metric) error {
+	if srv.readOnly.Load() {
+		return errReadOnly
+	}
+
 	var err error
 
 	err = retry.Do(func() error {
-		return srv.storage.UpdateNew(context.Background(), m.MType, m.ID, m.Delta, m.Value)
+		return srv.storage.UpdateNew(detached(ctx), m.MType, m.ID, m.Delta, m.Value)
 	},
 		retry.RetryIf(func(errAttempt error) bool {
 			var pgErr *pgconn.PgError
@@ -265,6 +546,8 @@ func (srv *Server) AddMetricNew(ctx context.Context, m metrics.Metric) error {
 		return err
 	}
 
+	srv.afterUpdate(ctx, []metrics.Metric{m})
+
 	if srv.StoreInterval == 0 {
 		err = srv.SaveToFile(ctx)
 		if err != nil {
@@ -278,29 +561,34 @@ func (srv *Server) AddMetricNew(ctx context.Context, m metrics.Metric) error {
 // AddMetricsBatch adds array of new metrics.
 // Behavior is the same as in AddMetricNew
 func (srv *Server) AddMetricsBatch(ctx context.Context, m []metrics.Metric) error {
+	if err := srv.limits.CheckBatch(len(m)); err != nil {
+		return err
+	}
+	return srv.addMetricsBatch(ctx, srv.storage, m)
+}
+
+// addMetricsBatch adds metrics to storage st
+func (srv *Server) addMetricsBatch(ctx context.Context, st storage.Storage, m []metrics.Metric) error {
+	if srv.readOnly.Load() {
+		return errReadOnly
+	}
 
 	var err error
-	err = retry.Do(func() error {
-		return srv.storage.UpdateBatch(context.Background(), m)
-	},
-		retry.RetryIf(func(errAttempt error) bool {
-			var pgErr *pgconn.PgError
-			if errors.As(errAttempt, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code) {
-				return true
-			}
-			return false
-		}),
-		retry.Attempts(3),
-		retry.InitDelay(1000*time.Millisecond),
-		retry.Step(2000*time.Millisecond),
-		retry.Context(ctx),
-	)
+	if srv.cluster != nil {
+		// batch is split between nodes, so retry of whole batch would apply counters
+		// twice on nodes which succeeded. Each node retries only its own part
+		err = st.UpdateBatch(detached(ctx), m)
+	} else {
+		err = retryUpdateBatch(ctx, st, m)
+	}
 
 	if err != nil {
 		Sugar.Errorln(err)
 		return err
 	}
 
+	srv.afterUpdate(ctx, m)
+
 	if srv.StoreInterval == 0 {
 		err = srv.SaveToFile(ctx)
 		if err != nil {
@@ -311,6 +599,25 @@ func (srv *Server) AddMetricsBatch(ctx context.Context, m []metrics.Metric) erro
 	return nil
 }
 
+// retryUpdateBatch updates st again if connection to database failed
+func retryUpdateBatch(ctx context.Context, st storage.Storage, m []metrics.Metric) error {
+	return retry.Do(func() error {
+		return st.UpdateBatch(detached(ctx), m)
+	},
+		retry.RetryIf(func(errAttempt error) bool {
+			var pgErr *pgconn.PgError
+			if errors.As(errAttempt, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code) {
+				return true
+			}
+			return false
+		}),
+		retry.Attempts(3),
+		retry.InitDelay(1000*time.Millisecond),
+		retry.Step(2000*time.Millisecond),
+		retry.Context(ctx),
+	)
+}
+
 // GetMetricValue returns current value of metric
 func (srv *Server) GetMetricValue(ctx context.Context, metricType string, metricName string) (any, error) {
 	val, err := srv.storage.GetValue(ctx, metricType, metricName)
@@ -324,6 +631,11 @@ func (srv *Server) GetRequestedValues(ctx context.Context, m []metrics.Metric) (
 	if err != nil {
 		return nil, err
 	}
+	return requestedValues(slice, m), nil
+}
+
+// requestedValues picks metrics m from slice, missing metrics have zero values
+func requestedValues(slice []*metrics.Metric, m []metrics.Metric) []metrics.Metric {
 	hash := make(map[string]*metrics.Metric, 0)
 
 	for _, el := range slice {
@@ -348,7 +660,7 @@ func (srv *Server) GetRequestedValues(ctx context.Context, m []metrics.Metric) (
 		}
 	}
 
-	return result, nil
+	return result
 }
 
 // GetAllMetricsNew returns all existed metrics with current values
@@ -356,7 +668,7 @@ func (srv *Server) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, err
 	var val []*metrics.Metric
 	var err error
 	err = retry.Do(func() error {
-		val, err = srv.storage.GetAllMetricsNew(context.Background())
+		val, err = srv.storage.GetAllMetricsNew(detached(ctx))
 		return err
 	},
 		retry.RetryIf(func(errAttempt error) bool {
@@ -415,7 +727,8 @@ func (srv *Server) StopAsyncSaving() {
 
 // RestoreValues restore metrics from file
 func (srv *Server) RestoreValues(ctx context.Context) {
-	if srv.Restore && srv.StorageType == "memory" {
+	// follower receives state from leader
+	if srv.Restore && srv.StorageType == "memory" && srv.follower == nil {
 		m, err := srv.ReadFromFile()
 		if err != nil {
 			Sugar.Infoln("Read values failed: ", err.Error())
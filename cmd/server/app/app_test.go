@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"os"
 	"reflect"
 	"testing"
 
@@ -9,8 +10,15 @@ import (
 	"github.com/kvvPro/metric-collector/internal/metrics"
 	st "github.com/kvvPro/metric-collector/internal/storage"
 	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"go.uber.org/zap"
 )
 
+func TestMain(m *testing.M) {
+	// handlers write logs, so logger must be initialized
+	Sugar = *zap.NewNop().Sugar()
+	os.Exit(m.Run())
+}
+
 func TestServer_AddMetric(t *testing.T) {
 	type fields struct {
 		storage st.Storage
@@ -170,7 +178,7 @@ func TestServer_GetAllMetrics(t *testing.T) {
 func BenchmarkGetAllMetrics(b *testing.B) {
 	memst := memstorage.NewMemStorage()
 	srv := &Server{
-		storage: &memst,
+		storage: memst,
 	}
 	for i := 0; i < b.N; i++ {
 		srv.GetAllMetricsNew(context.Background())
@@ -180,7 +188,7 @@ func BenchmarkGetAllMetrics(b *testing.B) {
 func BenchmarkGetMetricValue(b *testing.B) {
 	memst := memstorage.NewMemStorage()
 	srv := &Server{
-		storage: &memst,
+		storage: memst,
 	}
 	for i := 0; i < b.N; i++ {
 		srv.GetMetricValue(context.Background(), "gauge", "mem_usage")
@@ -190,7 +198,7 @@ func BenchmarkGetMetricValue(b *testing.B) {
 func BenchmarkUpdateMetric(b *testing.B) {
 	memst := memstorage.NewMemStorage()
 	srv := &Server{
-		storage: &memst,
+		storage: memst,
 	}
 	val := 10.7
 	for i := 0; i < b.N; i++ {
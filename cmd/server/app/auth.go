@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// scopes of gRPC methods, other methods require read scope
+var methodScopes = map[string]auth.Scope{
+	"/" + pb.MetricServer_ServiceDesc.ServiceName + "/PushMetrics":       auth.ScopePush,
+	"/" + pb.MetricServer_ServiceDesc.ServiceName + "/PushMetricsStream": auth.ScopePush,
+	"/" + pb.Replication_ServiceDesc.ServiceName + "/Subscribe":          auth.ScopeAdmin,
+	"/" + pb.Replication_ServiceDesc.ServiceName + "/Promote":            auth.ScopeAdmin,
+}
+
+// AuthMiddleware authenticates client by bearer token, name of token
+// identifies client instead of its IP
+func (srv *Server) AuthMiddleware(h http.Handler) http.Handler {
+	authFunc := func(w http.ResponseWriter, r *http.Request) {
+		if srv.tokens == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		token, err := auth.BearerToken(r.Header.Get(auth.Header))
+		if err == nil {
+			var t auth.Token
+			t, err = srv.tokens.Authenticate(token)
+			if err == nil {
+				ctx := cardinality.WithClient(auth.WithToken(r.Context(), t), t.Name)
+				h.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	}
+	return http.HandlerFunc(authFunc)
+}
+
+// RequireScope rejects requests with token without scope
+func (srv *Server) RequireScope(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		scopeFunc := func(w http.ResponseWriter, r *http.Request) {
+			if srv.tokens != nil {
+				t, ok := auth.FromContext(r.Context())
+				if !ok || !t.Allows(scope) {
+					http.Error(w, "token has no scope "+string(scope), http.StatusForbidden)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(scopeFunc)
+	}
+}
+
+func (srv *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := srv.authContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (srv *Server) authStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := srv.authContext(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srvIface, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// authContext authenticates gRPC call by token from metadata and checks scope of method
+func (srv *Server) authContext(ctx context.Context, method string) (context.Context, error) {
+	if srv.tokens == nil {
+		return ctx, nil
+	}
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(auth.Header); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	token, err := auth.BearerToken(header)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	t, err := srv.tokens.Authenticate(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	scope, ok := methodScopes[method]
+	if !ok {
+		scope = auth.ScopeRead
+	}
+	if !t.Allows(scope) {
+		return nil, status.Error(codes.PermissionDenied, "token has no scope "+string(scope))
+	}
+	return cardinality.WithClient(auth.WithToken(ctx, t), t.Name), nil
+}
+
+// startTokensReload reloads tokens on SIGHUP until ctx is done
+func (srv *Server) startTokensReload(ctx context.Context) {
+	if srv.tokens == nil {
+		return
+	}
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		defer signal.Stop(reload)
+		for {
+			select {
+			case <-reload:
+				if err := srv.tokens.Reload(); err != nil {
+					Sugar.Infoln("Reload of tokens failed: ", err.Error())
+					continue
+				}
+				Sugar.Infoln("Tokens are reloaded")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// writeTokens writes file with tokens: "agent" with push scope,
+// "reader" with read scope and "admin" with admin scope
+func writeTokens(t *testing.T) string {
+	data, err := json.Marshal(auth.TokensFile{Tokens: []auth.Token{
+		{Name: "agent-1", SHA256: auth.Hash("agent"), Scopes: []auth.Scope{auth.ScopePush}},
+		{Name: "grafana", SHA256: auth.Hash("reader"), Scopes: []auth.Scope{auth.ScopeRead}},
+		{Name: "ops", SHA256: auth.Hash("admin"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+// newAuthServer creates server with tokens of writeTokens
+func newAuthServer(t *testing.T) *Server {
+	var err error
+	srv := newTestServer(t, testMetrics())
+	srv.tokens, err = auth.NewStore(writeTokens(t))
+	require.NoError(t, err)
+	return srv
+}
+
+func TestServer_Auth_HTTP(t *testing.T) {
+	srv := newAuthServer(t)
+	h := srv.router()
+
+	value := 2.5
+	update, err := json.Marshal(metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/metrics", want: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodGet, path: "/api/metrics", token: "wrong", want: http.StatusUnauthorized},
+		{name: "read", method: http.MethodGet, path: "/api/metrics", token: "reader", want: http.StatusOK},
+		{name: "read by agent", method: http.MethodGet, path: "/api/metrics", token: "agent", want: http.StatusForbidden},
+		{name: "push", method: http.MethodPost, path: "/update/", token: "agent", want: http.StatusOK},
+		{name: "push by reader", method: http.MethodPost, path: "/update/", token: "reader", want: http.StatusForbidden},
+		{name: "admin", method: http.MethodGet, path: "/api/cardinality", token: "admin", want: http.StatusOK},
+		{name: "admin by reader", method: http.MethodGet, path: "/api/cardinality", token: "reader", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			if tt.method == http.MethodPost {
+				body = update
+			}
+			r := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				r.Header.Set(auth.Header, auth.Bearer(tt.token))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+
+	// writes of agent are identified by name of token
+	report, err := srv.Cardinality(context.Background(), 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.TopClients)
+	assert.Equal(t, "agent-1", report.TopClients[0].Client)
+}
+
+func TestServer_Auth_GRPC(t *testing.T) {
+	client := newBufconnClient(t, newAuthServer(t))
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), auth.Header, auth.Bearer(token))
+	}
+	value := 1.5
+	push := &pb.PushMetricsRequest{Metrics: []*pb.Metric{{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value}}}
+
+	_, err := client.PushMetrics(context.Background(), push)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.PushMetrics(withToken("reader"), push)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.PushMetrics(withToken("agent"), push)
+	assert.NoError(t, err)
+
+	_, err = client.GetMetrics(withToken("agent"), &pb.GetMetricsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetMetrics(withToken("reader"), &pb.GetMetricsRequest{})
+	assert.NoError(t, err)
+}
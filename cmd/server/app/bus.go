@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// size of subscriber's queue, subscriber which doesn't read events
+// fast enough is unsubscribed when its queue is full
+const subscriberBuffer = 256
+
+// MetricEvent describes change of metric applied to storage
+type MetricEvent struct {
+	// metric with current value after change
+	Metric metrics.Metric `json:"metric"`
+	// time when change was applied
+	Time time.Time `json:"time"`
+}
+
+// WatchFilter selects metrics for subscriber - by names or by prefix of name.
+// Empty filter selects all metrics
+type WatchFilter struct {
+	Names  []string
+	Prefix string
+}
+
+func (f WatchFilter) match(id string, names map[string]struct{}) bool {
+	if len(names) > 0 {
+		if _, ok := names[id]; ok {
+			return true
+		}
+		// if names are set, prefix extends selection
+		return f.Prefix != "" && strings.HasPrefix(id, f.Prefix)
+	}
+	return strings.HasPrefix(id, f.Prefix)
+}
+
+type subscriber struct {
+	filter WatchFilter
+	names  map[string]struct{}
+	events chan MetricEvent
+}
+
+// changeBus delivers applied changes of metrics to subscribers.
+// Zero value is ready to use
+type changeBus struct {
+	mx          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+// subscribe registers new subscriber. Returned channel is closed
+// after cancel call or if subscriber is too slow to read events
+func (b *changeBus) subscribe(filter WatchFilter) (<-chan MetricEvent, func()) {
+	sub := &subscriber{
+		filter: filter,
+		names:  make(map[string]struct{}, len(filter.Names)),
+		events: make(chan MetricEvent, subscriberBuffer),
+	}
+	for _, name := range filter.Names {
+		sub.names[name] = struct{}{}
+	}
+
+	b.mx.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[*subscriber]struct{})
+	}
+	b.subscribers[sub] = struct{}{}
+	b.mx.Unlock()
+
+	cancel := func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+		b.remove(sub)
+	}
+	return sub.events, cancel
+}
+
+// remove must be called under write lock
+func (b *changeBus) remove(sub *subscriber) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (b *changeBus) hasSubscribers() bool {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// publish sends events to all matching subscribers without blocking
+func (b *changeBus) publish(events []MetricEvent) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for sub := range b.subscribers {
+		for _, e := range events {
+			if !sub.filter.match(e.Metric.ID, sub.names) {
+				continue
+			}
+			select {
+			case sub.events <- e:
+			default:
+				Sugar.Infoln("subscriber is too slow, unsubscribe it")
+				b.remove(sub)
+			}
+			if _, ok := b.subscribers[sub]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// afterUpdate is called after metrics m are successfully applied to storage
+func (srv *Server) afterUpdate(ctx context.Context, m []metrics.Metric) {
+	// state of server is kept by names of metrics in storage
+	client := cardinality.ClientFromContext(ctx)
+	if id := tenant.FromContext(ctx); id != "" {
+		scoped := make([]metrics.Metric, len(m))
+		for i, el := range m {
+			el.ID = tenant.Scope(id, el.ID)
+			scoped[i] = el
+		}
+		m = scoped
+		if client != "" {
+			client = tenant.Scope(id, client)
+		}
+		ctx = tenant.WithTenant(ctx, "")
+	}
+
+	// usage of clients is counted by node which received their writes
+	now := time.Now()
+	srv.usage.Observe(client, m, now)
+
+	// in cluster mode changes are handled by owners of metrics
+	m = srv.owned(m)
+	if len(m) == 0 {
+		return
+	}
+
+	srv.updated.set(m, now)
+
+	// metrics restored from backup before start aren't forwarded again
+	if srv.forwarder != nil && srv.forwarding.Load() {
+		srv.forwarder.Forward(m)
+	}
+
+	notify := srv.bus.hasSubscribers()
+	if !notify && srv.history == nil && srv.detector == nil {
+		return
+	}
+
+	current, err := srv.currentValues(ctx, m)
+	if err != nil {
+		Sugar.Infoln("Read current values failed: ", err.Error())
+		return
+	}
+
+	if srv.history != nil {
+		for _, el := range current {
+			srv.history.Add(el.MType, el.ID, now, sampleValue(el))
+		}
+	}
+
+	if srv.detector != nil {
+		srv.detectAnomalies(current, now)
+	}
+
+	if !notify {
+		return
+	}
+	events := make([]MetricEvent, 0, len(current))
+	for _, el := range current {
+		events = append(events, MetricEvent{Metric: el, Time: now})
+	}
+	srv.bus.publish(events)
+}
+
+// currentValues returns current values of updated metrics,
+// each metric is returned once even if it was updated several times.
+// Value of gauge is the last value of batch, totals of counters are read from storage
+func (srv *Server) currentValues(ctx context.Context, m []metrics.Metric) ([]metrics.Metric, error) {
+	index := make(map[metricKey]int, len(m))
+	unique := make([]metrics.Metric, 0, len(m))
+	for _, el := range m {
+		k := metricKey{el.MType, el.ID}
+		i, ok := index[k]
+		if !ok {
+			i = len(unique)
+			index[k] = i
+			unique = append(unique, metrics.Metric{ID: el.ID, MType: el.MType})
+		}
+		if el.MType == metrics.MetricTypeGauge && el.Value != nil {
+			value := *el.Value
+			unique[i].Value = &value
+		}
+	}
+
+	// all updated metrics are kept by this node
+	st := srv.localStorage()
+	for i, el := range unique {
+		if el.MType != metrics.MetricTypeCounter {
+			continue
+		}
+		val, err := st.GetValue(ctx, el.MType, el.ID)
+		if err != nil {
+			return nil, err
+		}
+		if delta, ok := val.(int64); ok {
+			unique[i].Delta = &delta
+		}
+	}
+	return unique, nil
+}
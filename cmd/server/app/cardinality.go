@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/usage"
+)
+
+// count of top series and clients in cardinality report if it isn't set in request
+const defaultCardinalityTop = 10
+
+// SeriesCount is a count of series with common prefix of name
+type SeriesCount struct {
+	Prefix string `json:"prefix"`
+	Series int    `json:"series"`
+}
+
+// LabelCount is a count of series with label
+type LabelCount struct {
+	Label  string `json:"label"`
+	Series int    `json:"series"`
+	// count of distinct values of label
+	Values int `json:"values"`
+}
+
+// CardinalityReport describes what series are kept and who writes them
+type CardinalityReport struct {
+	// count of all series
+	Series int `json:"series"`
+	// counts of series by group of name as on dashboard, the largest first
+	ByPrefix []SeriesCount `json:"by_prefix"`
+	// counts of series by label, the largest first
+	ByLabel []LabelCount `json:"by_label"`
+	usage.Report
+}
+
+// Cardinality makes report of series and their writers, top limits count of top series and clients
+func (srv *Server) Cardinality(ctx context.Context, top int) (*CardinalityReport, error) {
+	all, err := srv.GetAllMetricsNew(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make(map[string]int)
+	labels := make(map[string]map[string]int)
+	for _, el := range all {
+		prefixes[metricGroup(el.ID)]++
+		_, l, err := metrics.ParseSeriesName(el.ID)
+		if err != nil {
+			continue
+		}
+		for k, v := range l {
+			if labels[k] == nil {
+				labels[k] = make(map[string]int)
+			}
+			labels[k][v]++
+		}
+	}
+
+	report := &CardinalityReport{
+		Series:   len(all),
+		ByPrefix: make([]SeriesCount, 0, len(prefixes)),
+		ByLabel:  make([]LabelCount, 0, len(labels)),
+	}
+	for prefix, n := range prefixes {
+		report.ByPrefix = append(report.ByPrefix, SeriesCount{Prefix: prefix, Series: n})
+	}
+	sort.Slice(report.ByPrefix, func(i, j int) bool {
+		if report.ByPrefix[i].Series != report.ByPrefix[j].Series {
+			return report.ByPrefix[i].Series > report.ByPrefix[j].Series
+		}
+		return report.ByPrefix[i].Prefix < report.ByPrefix[j].Prefix
+	})
+	for label, values := range labels {
+		count := LabelCount{Label: label, Values: len(values)}
+		for _, n := range values {
+			count.Series += n
+		}
+		report.ByLabel = append(report.ByLabel, count)
+	}
+	sort.Slice(report.ByLabel, func(i, j int) bool {
+		if report.ByLabel[i].Series != report.ByLabel[j].Series {
+			return report.ByLabel[i].Series > report.ByLabel[j].Series
+		}
+		return report.ByLabel[i].Label < report.ByLabel[j].Label
+	})
+
+	// tenant sees only its series and clients
+	var rename func(string) (string, bool)
+	if id := tenant.FromContext(ctx); id != "" {
+		rename = func(name string) (string, bool) { return tenant.Unscope(id, name) }
+	}
+	report.Report = srv.usage.Report(time.Now(), top, rename)
+	return report, nil
+}
+
+// CardinalityHandle godoc
+// @Tags admin
+// @Summary Cardinality explorer
+// @Description Counts of series by prefix of name and by label, the fastest-updating series,
+// @Description the most active clients and new series per minute during the last hour
+// @ID cardinality
+// @Produce json
+// @Param top query int false "Count of top series and clients, 10 by default"
+// @Success 200 {object} CardinalityReport
+// @Failure 400 {string} string "Invalid top"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/cardinality [get]
+func (srv *Server) CardinalityHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	top := defaultCardinalityTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		top, err = strconv.Atoi(v)
+		if err != nil || top <= 0 {
+			http.Error(w, "Invalid top", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := srv.Cardinality(r.Context(), top)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
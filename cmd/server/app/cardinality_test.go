@@ -0,0 +1,60 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+func TestServer_CardinalityHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	h := srv.ClientMiddleware(http.HandlerFunc(srv.UpdateBatchJSONHandle))
+
+	value := 1.0
+	batch := []metrics.Metric{
+		{ID: metrics.SeriesName("http_requests", map[string]string{"code": "200", "path": "/"}), MType: metrics.MetricTypeGauge, Value: &value},
+		{ID: metrics.SeriesName("http_requests", map[string]string{"code": "500", "path": "/"}), MType: metrics.MetricTypeGauge, Value: &value},
+		{ID: "HeapAlloc", MType: metrics.MetricTypeGauge, Value: &value},
+	}
+	body, err := json.Marshal(batch)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = "10.0.0.7:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	srv.CardinalityHandle(w, httptest.NewRequest(http.MethodGet, "/api/cardinality?top=1", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var report CardinalityReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+	assert.Equal(t, 6, report.Series)
+	assert.Equal(t, SeriesCount{Prefix: "Heap", Series: 2}, report.ByPrefix[0])
+	assert.Equal(t, []LabelCount{{Label: "code", Series: 2, Values: 2}, {Label: "path", Series: 2, Values: 1}}, report.ByLabel)
+
+	// HeapAlloc was also written by server before
+	require.Len(t, report.TopSeries, 1)
+	assert.Equal(t, "HeapAlloc", report.TopSeries[0].ID)
+	assert.Equal(t, 4.0, report.TopSeries[0].UpdatesPerMinute)
+	require.Len(t, report.TopClients, 1)
+	assert.Equal(t, "10.0.0.7", report.TopClients[0].Client)
+	assert.Equal(t, int64(9), report.TopClients[0].Writes)
+	assert.Equal(t, int64(2), report.TopClients[0].NewSeries)
+	assert.Equal(t, 2, report.Growth[len(report.Growth)-1].NewSeries)
+
+	w = httptest.NewRecorder()
+	srv.CardinalityHandle(w, httptest.NewRequest(http.MethodGet, "/api/cardinality?top=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+// number of last samples shown on sparkline
+const sparklineSamples = 60
+
+// sizes of charts in pixels
+const (
+	sparklineWidth  = 120
+	sparklineHeight = 24
+	chartWidth      = 800
+	chartHeight     = 300
+	// space for labels of axes
+	chartPaddingLeft   = 80
+	chartPaddingBottom = 24
+	chartPaddingTop    = 10
+	chartPaddingRight  = 10
+)
+
+// time windows of detail chart offered on page
+var chartWindows = []string{"5m", "15m", "1h", "6h", "24h"}
+
+const defaultChartWindow = "15m"
+
+// chartPage is data of chart template
+type chartPage struct {
+	ID      string
+	Type    string
+	Value   string
+	Window  string
+	Windows []string
+	Chart   template.HTML
+	Samples int
+}
+
+// rect is an area of chart where line is drawn
+type rect struct {
+	left, top, width, height float64
+}
+
+// sampleValue returns value of metric saved in history
+func sampleValue(m metrics.Metric) float64 {
+	if m.MType == metrics.MetricTypeCounter {
+		if m.Delta == nil {
+			return 0
+		}
+		return float64(*m.Delta)
+	}
+	if m.Value == nil {
+		return 0
+	}
+	return *m.Value
+}
+
+// sparkline returns small chart of last values of metric, empty if history isn't kept
+func (srv *Server) sparkline(mtype, id string) template.HTML {
+	if srv.history == nil {
+		return ""
+	}
+	return renderSparkline(srv.history.Last(mtype, id, sparklineSamples))
+}
+
+// renderSparkline draws samples as polyline without axes
+func renderSparkline(samples []history.Sample) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight)
+	if len(samples) > 0 {
+		lo, hi := valueRange(samples)
+		area := rect{left: 1, top: 1, width: sparklineWidth - 2, height: sparklineHeight - 2}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="#3366cc" stroke-width="1" points="%s"/>`,
+			polyline(samples, samples[0].Time, samples[len(samples)-1].Time, lo, hi, area))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// renderChart draws samples of time window [from, to] with axes and labels
+func renderChart(samples []history.Sample, from, to time.Time) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+
+	area := rect{
+		left:   chartPaddingLeft,
+		top:    chartPaddingTop,
+		width:  chartWidth - chartPaddingLeft - chartPaddingRight,
+		height: chartHeight - chartPaddingTop - chartPaddingBottom,
+	}
+	bottom := area.top + area.height
+	right := area.left + area.width
+
+	fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="#fafafa" stroke="#ccc"/>`,
+		coord(area.left), coord(area.top), coord(area.width), coord(area.height))
+	fmt.Fprintf(&b, `<text x="%s" y="%d">%s</text>`, coord(area.left), chartHeight-6, from.Format("15:04:05"))
+	fmt.Fprintf(&b, `<text x="%s" y="%d" text-anchor="end">%s</text>`, coord(right), chartHeight-6, to.Format("15:04:05"))
+
+	if len(samples) == 0 {
+		fmt.Fprintf(&b, `<text x="%s" y="%s" text-anchor="middle" fill="#888">no data for the window</text>`,
+			coord(area.left+area.width/2), coord(area.top+area.height/2))
+		b.WriteString(`</svg>`)
+		return template.HTML(b.String())
+	}
+
+	lo, hi := valueRange(samples)
+	// grid lines with values: max, middle, min
+	for i, v := range []float64{hi, (lo + hi) / 2, lo} {
+		y := area.top + area.height*float64(i)/2
+		fmt.Fprintf(&b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="#e0e0e0"/>`,
+			coord(area.left), coord(y), coord(right), coord(y))
+		fmt.Fprintf(&b, `<text x="%d" y="%s" text-anchor="end" dominant-baseline="middle">%s</text>`,
+			chartPaddingLeft-6, coord(y), strconv.FormatFloat(v, 'g', 6, 64))
+	}
+	fmt.Fprintf(&b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="#888"/>`,
+		coord(area.left), coord(bottom), coord(right), coord(bottom))
+
+	fmt.Fprintf(&b, `<polyline fill="none" stroke="#3366cc" stroke-width="1.5" points="%s"/>`,
+		polyline(samples, from, to, lo, hi, area))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// valueRange returns minimum and maximum of values, range isn't empty even for constant values
+func valueRange(samples []history.Sample) (float64, float64) {
+	lo, hi := samples[0].Value, samples[0].Value
+	for _, el := range samples[1:] {
+		if el.Value < lo {
+			lo = el.Value
+		}
+		if el.Value > hi {
+			hi = el.Value
+		}
+	}
+	if lo == hi {
+		return lo - 1, hi + 1
+	}
+	return lo, hi
+}
+
+// polyline returns points of line in area: time range [from, to] is mapped to width,
+// value range [lo, hi] is mapped to height
+func polyline(samples []history.Sample, from, to time.Time, lo, hi float64, area rect) string {
+	span := to.Sub(from).Seconds()
+	points := make([]string, 0, len(samples))
+	for _, el := range samples {
+		x := area.left + area.width/2
+		if span > 0 {
+			x = area.left + area.width*el.Time.Sub(from).Seconds()/span
+		}
+		y := area.top + area.height*(hi-el.Value)/(hi-lo)
+		points = append(points, coord(x)+","+coord(y))
+	}
+	return strings.Join(points, " ")
+}
+
+func coord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// ChartHandle godoc
+// @Tags getvalue
+// @Summary Chart of metric
+// @Description Page with chart of last values of metric for selected time window
+// @ID chart
+// @Produce html
+// @Param type query string true "Metric type"
+// @Param id query string true "Metric name"
+// @Param window query string false "Time window, e.g. 15m or 1h"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Invalid query"
+// @Failure 404 {string} string "Metric not found"
+// @Failure 405 {string} string "Invalid request type"
+// @Router /chart [get]
+func (srv *Server) ChartHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	metricType := query.Get("type")
+	metricName := query.Get("id")
+	if !isValidType(metricType) || metricName == "" {
+		http.Error(w, "Invalid query", http.StatusBadRequest)
+		return
+	}
+	window := query.Get("window")
+	if window == "" {
+		window = defaultChartWindow
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		http.Error(w, "Invalid window", http.StatusBadRequest)
+		return
+	}
+
+	val, err := srv.GetMetricValue(r.Context(), metricType, metricName)
+	if val == nil {
+		msg := "Metric not found"
+		if err != nil {
+			msg = err.Error()
+		}
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-duration)
+	samples, err := srv.samples(r.Context(), metricType, scopedID(r.Context(), metricName), from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := chartPage{
+		ID:      metricName,
+		Type:    metricType,
+		Value:   fmt.Sprintf("%v", val),
+		Window:  window,
+		Windows: chartWindows,
+		Chart:   renderChart(samples, from, to),
+		Samples: len(samples),
+	}
+	body := new(bytes.Buffer)
+	if err := templates.ExecuteTemplate(body, "chart", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHistoryServer(t *testing.T) *Server {
+	srv := &Server{
+		storage: memstorage.NewMemStorage(),
+		history: history.New(10),
+	}
+	for i := 0; i < 3; i++ {
+		value := float64(i)
+		delta := int64(1)
+		require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+			*metrics.NewCommonMetric("Alloc", metrics.MetricTypeGauge, nil, &value),
+			*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &delta, nil),
+		}))
+	}
+	return srv
+}
+
+func TestServer_afterUpdate_History(t *testing.T) {
+	srv := newHistoryServer(t)
+
+	values := func(samples []history.Sample) []float64 {
+		res := make([]float64, 0, len(samples))
+		for _, el := range samples {
+			res = append(res, el.Value)
+		}
+		return res
+	}
+	assert.Equal(t, []float64{0, 1, 2}, values(srv.history.Last(metrics.MetricTypeGauge, "Alloc", 10)))
+	// counter keeps totals
+	assert.Equal(t, []float64{1, 2, 3}, values(srv.history.Last(metrics.MetricTypeCounter, "PollCount", 10)))
+}
+
+// scanStorage counts reads of all metrics
+type scanStorage struct {
+	storage.Storage
+	scans int
+}
+
+func (s *scanStorage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
+	s.scans++
+	return s.Storage.GetAllMetricsNew(ctx)
+}
+
+func TestServer_afterUpdate_NoScan(t *testing.T) {
+	st := &scanStorage{Storage: memstorage.NewMemStorage()}
+	// synchronous saving to file reads all metrics by design
+	srv := &Server{storage: st, history: history.New(10), StoreInterval: 300}
+
+	value := 1.5
+	delta := int64(2)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+			*metrics.NewCommonMetric("Alloc", metrics.MetricTypeGauge, nil, &value),
+			*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &delta, nil),
+		}))
+	}
+	assert.Equal(t, 0, st.scans)
+	require.Len(t, srv.history.Last(metrics.MetricTypeCounter, "PollCount", 10), 2)
+	assert.Equal(t, float64(4), srv.history.Last(metrics.MetricTypeCounter, "PollCount", 10)[1].Value)
+}
+
+func TestNewServer_HistoryDisabled(t *testing.T) {
+	srv, err := NewServer(&config.ServerFlags{HistorySize: 0})
+	require.NoError(t, err)
+	assert.Nil(t, srv.history)
+}
+
+func TestServer_ChartHandle(t *testing.T) {
+	srv := newHistoryServer(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{name: "default window", target: "/chart?type=gauge&id=Alloc", status: http.StatusOK},
+		{name: "selected window", target: "/chart?type=counter&id=PollCount&window=1h", status: http.StatusOK},
+		{name: "invalid window", target: "/chart?type=gauge&id=Alloc&window=week", status: http.StatusBadRequest},
+		{name: "invalid type", target: "/chart?type=histogram&id=Alloc", status: http.StatusBadRequest},
+		{name: "unknown metric", target: "/chart?type=gauge&id=Unknown", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			srv.ChartHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.status, res.StatusCode)
+			if tt.status == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "<polyline")
+				assert.Contains(t, w.Body.String(), "samples: 3")
+			}
+		})
+	}
+}
+
+func TestServer_AllMetricsHandle_Sparklines(t *testing.T) {
+	srv := newHistoryServer(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	srv.AllMetricsHandle(w, r)
+
+	body := w.Body.String()
+	assert.Equal(t, 2, strings.Count(body, "<polyline"))
+	assert.Contains(t, body, `href="/chart?type=gauge&id=Alloc"`)
+}
+
+func Test_renderSparkline(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	empty := string(renderSparkline(nil))
+	assert.NotContains(t, empty, "<polyline")
+
+	line := string(renderSparkline([]history.Sample{
+		{Time: start, Value: 0},
+		{Time: start.Add(time.Second), Value: 10},
+	}))
+	// the lowest value is at the bottom, the highest at the top
+	assert.Contains(t, line, `points="1.0,23.0 119.0,1.0"`)
+
+	flat := string(renderSparkline([]history.Sample{{Time: start, Value: 5}}))
+	assert.Contains(t, flat, `points="60.0,12.0"`)
+}
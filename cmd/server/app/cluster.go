@@ -0,0 +1,550 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/cluster"
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+)
+
+// newCluster creates storage of cluster node, local keeps metrics owned by this node
+func newCluster(settings *config.ServerFlags, local storage.Storage) (*cluster.Storage, error) {
+	self := settings.ClusterSelf
+	if self == "" {
+		self = settings.Address
+	}
+	tlsConfig, err := peerTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	peers := make(map[string]cluster.Peer)
+	for _, node := range strings.Split(settings.ClusterNodes, ",") {
+		if node = strings.TrimSpace(node); node == "" {
+			continue
+		}
+		nodes = append(nodes, node)
+		if node != self {
+			peers[node] = cluster.NewHTTPPeer(node, settings.HashKey, settings.NodeToken, tlsConfig)
+		}
+	}
+	return cluster.NewStorage(self, retryStorage{local}, cluster.NewRing(nodes, cluster.DefaultReplicas), peers)
+}
+
+// retryStorage retries writes of batches to local storage of node,
+// so failed part of batch of cluster is retried without parts of other nodes
+type retryStorage struct {
+	storage.Storage
+}
+
+func (s retryStorage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	return retryUpdateBatch(ctx, s.Storage, m)
+}
+
+// peerTLSConfig returns TLS settings of connections to other servers,
+// nodes of cluster serve TLS with the same settings as this server,
+// so they are verified by system CAs if peer CA isn't set
+func peerTLSConfig(settings *config.ServerFlags) (*tls.Config, error) {
+	cfg, err := tlsconfig.Client(settings.PeerTLSCA, settings.PeerTLSCert, settings.PeerTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil && settings.TLSCert != "" {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return cfg, nil
+}
+
+// localStorage returns storage of metrics kept by this node,
+// in cluster mode it keeps only metrics owned by node
+func (srv *Server) localStorage() storage.Storage {
+	if srv.cluster != nil {
+		return srv.cluster.Local()
+	}
+	return srv.storage
+}
+
+// localMetrics returns all metrics kept by this node with names as they are stored
+func (srv *Server) localMetrics(ctx context.Context) ([]*metrics.Metric, error) {
+	ctx = tenant.WithTenant(ctx, "")
+	if srv.cluster != nil {
+		return srv.cluster.Local().GetAllMetricsNew(ctx)
+	}
+	return srv.GetAllMetricsNew(ctx)
+}
+
+// owned returns metrics which are owned by this node, changes of other
+// metrics are handled by their owners
+func (srv *Server) owned(m []metrics.Metric) []metrics.Metric {
+	if srv.cluster == nil {
+		return m
+	}
+	res := make([]metrics.Metric, 0, len(m))
+	for _, el := range m {
+		if srv.cluster.Owns(el.ID) {
+			res = append(res, el)
+		}
+	}
+	return res
+}
+
+// samples returns samples of history of metric with name id as it is stored,
+// in cluster mode history of metric is kept by its owner
+func (srv *Server) samples(ctx context.Context, mtype, id string, from time.Time) ([]history.Sample, error) {
+	if srv.cluster != nil && !srv.cluster.Owns(id) {
+		return srv.cluster.Samples(ctx, cluster.SamplesRequest{MType: mtype, ID: id, From: from})
+	}
+	if srv.history == nil {
+		return nil, nil
+	}
+	return srv.history.Samples(mtype, id, from), nil
+}
+
+// anomalyScore returns anomaly score of gauge with name id as it is stored,
+// in cluster mode baseline of gauge is kept by its owner
+func (srv *Server) anomalyScore(ctx context.Context, id string) (float64, bool, error) {
+	if srv.detector == nil {
+		return 0, false, nil
+	}
+	if srv.cluster != nil && !srv.cluster.Owns(id) {
+		res, err := srv.cluster.AnomalyScore(ctx, cluster.ScoreRequest{ID: id})
+		return res.Score, res.Found, err
+	}
+	score, ok := srv.detector.Score(id)
+	return score, ok, nil
+}
+
+// anomalies returns anomalies detected since from by all nodes, from the oldest to the newest
+func (srv *Server) anomalies(ctx context.Context, from time.Time) ([]anomaly.Anomaly, error) {
+	if srv.detector == nil {
+		return []anomaly.Anomaly{}, nil
+	}
+	list := srv.detector.Anomalies(from)
+	if srv.cluster == nil {
+		return list, nil
+	}
+	peers, err := srv.cluster.PeerAnomalies(ctx, cluster.AnomaliesRequest{From: from})
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, peers...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list, nil
+}
+
+// subscribe registers subscriber of changes of metrics, in cluster mode
+// changes applied by other nodes are received from them
+func (srv *Server) subscribe(filter WatchFilter) (<-chan MetricEvent, func()) {
+	local, cancelLocal := srv.bus.subscribe(filter)
+	if srv.cluster == nil {
+		return local, cancelLocal
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := func() {
+		cancel()
+		cancelLocal()
+	}
+	events := make(chan MetricEvent, subscriberBuffer)
+	send := func(e MetricEvent) {
+		select {
+		case events <- e:
+		default:
+			// the same as bus does with slow subscriber
+			stop()
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case e, ok := <-local:
+				if !ok {
+					stop()
+					return
+				}
+				send(e)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		request := cluster.WatchRequest{Names: filter.Names, Prefix: filter.Prefix}
+		srv.cluster.WatchPeers(ctx, request, func(e cluster.Event) {
+			if ctx.Err() == nil {
+				send(MetricEvent{Metric: e.Metric, Time: e.Time})
+			}
+		}, func(err error) {
+			Sugar.Infoln("Watch of changes failed: ", err.Error())
+		})
+	}()
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events, stop
+}
+
+// timeout of check of nodes preceding this node in cluster
+const leaderCheckTimeout = 5 * time.Second
+
+// interval of checks of leadership
+const leaderCheckInterval = 10 * time.Second
+
+// isLeader returns true if server isn't in cluster or it is leader of cluster
+func (srv *Server) isLeader() bool {
+	return srv.cluster == nil || srv.leading.Load()
+}
+
+// startLeaderElection checks leadership of node until ctx is done. The first check
+// is done before return, so tasks of leader can start right after it
+func (srv *Server) startLeaderElection(ctx context.Context) {
+	if srv.cluster == nil {
+		return
+	}
+	check := func() {
+		timeout, cancel := context.WithTimeout(ctx, leaderCheckTimeout)
+		defer cancel()
+		leading := srv.cluster.Leader(timeout)
+		if srv.leading.Swap(leading) != leading {
+			Sugar.Infoln("leadership of node in cluster changed: ", leading)
+		}
+	}
+	check()
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		ticker := time.NewTicker(leaderCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// clusterRoutes adds internal endpoints used by other nodes of cluster,
+// they work with metrics of all tenants, so only admins can call them
+func (srv *Server) clusterRoutes(r chi.Router) {
+	r.Use(srv.AuthMiddleware,
+		srv.RequireScope(auth.ScopeAdmin),
+		srv.CheckHashMiddleware,
+		GzipMiddleware,
+		WithLogging)
+	r.Handle(cluster.PingPath, http.HandlerFunc(srv.ClusterPingHandle))
+	r.Handle(cluster.UpdatesPath, http.HandlerFunc(srv.ClusterUpdatesHandle))
+	r.Handle(cluster.MetricsPath, http.HandlerFunc(srv.ClusterMetricsHandle))
+	r.Handle(cluster.SamplesPath, http.HandlerFunc(srv.ClusterSamplesHandle))
+	r.Handle(cluster.ScorePath, http.HandlerFunc(srv.ClusterScoreHandle))
+	r.Handle(cluster.AnomaliesPath, http.HandlerFunc(srv.ClusterAnomaliesHandle))
+	r.Handle(cluster.WatchPath, http.HandlerFunc(srv.ClusterWatchHandle))
+}
+
+// startClusterServer starts separate server for internal endpoints if they
+// can't be served by the main http server
+func (srv *Server) startClusterServer() {
+	if srv.cluster == nil || srv.ExchangeMode == "http" && srv.ClusterSelf == srv.Address {
+		return
+	}
+
+	r := chi.NewMux()
+	r.Use(srv.ValidateIP)
+	r.Group(srv.clusterRoutes)
+
+	srv.clusterServer = &http.Server{
+		Addr:      srv.ClusterSelf,
+		Handler:   r,
+		TLSConfig: srv.tlsConfig,
+	}
+	go func() {
+		var err error
+		if srv.tlsConfig != nil {
+			err = srv.clusterServer.ListenAndServeTLS("", "")
+		} else {
+			err = srv.clusterServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			Sugar.Fatalw(err.Error(), "event", "start cluster server")
+		}
+	}()
+}
+
+func (srv *Server) stopClusterServer(ctx context.Context) {
+	if srv.clusterServer == nil {
+		return
+	}
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := srv.clusterServer.Shutdown(timeout); err != nil {
+		Sugar.Errorf("Ошибка при попытке мягко завершить сервер кластера: %v", err)
+		srv.clusterServer.Close()
+	}
+}
+
+// ClusterPingHandle godoc
+// @Tags cluster
+// @Summary Checking storage of node
+// @Description Checking local storage of node, other nodes aren't checked
+// @ID clusterPing
+// @Produce plain
+// @Success 200 {string} string "OK"
+// @Failure 500 {string} string "Internal error"
+// @Router /cluster/ping [get]
+func (srv *Server) ClusterPingHandle(w http.ResponseWriter, r *http.Request) {
+	if err := srv.localStorage().Ping(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ClusterUpdatesHandle godoc
+// @Tags cluster
+// @Summary Update metrics owned by node
+// @Description Metrics routed by other node of cluster are applied to local storage
+// @ID clusterUpdates
+// @Accept  json
+// @Param metrics body []metrics.Metric true "metrics"
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /cluster/updates [post]
+func (srv *Server) ClusterUpdatesHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var m []metrics.Metric
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.addMetricsBatch(r.Context(), srv.localStorage(), m); err != nil {
+		http.Error(w, err.Error(), updateStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ClusterMetricsHandle godoc
+// @Tags cluster
+// @Summary List of metrics owned by node
+// @Description Metrics of local storage selected by filter
+// @ID clusterMetrics
+// @Accept  json
+// @Produce json
+// @Param filter body storage.Filter true "filter"
+// @Success 200 {array} metrics.Metric
+// @Failure 400 {string} string "Invalid filter"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /cluster/metrics [post]
+func (srv *Server) ClusterMetricsHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var f storage.Filter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := f.Validate(); err != nil {
+		http.Error(w, errors.Join(errInvalidFilter, err).Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := srv.localStorage().ListMetrics(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// decodeClusterRequest decodes JSON body of POST request to internal endpoint into v
+func decodeClusterRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeClusterResponse writes v as JSON
+func writeClusterResponse(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// ClusterSamplesHandle godoc
+// @Tags cluster
+// @Summary History of metric owned by node
+// @Description Samples of history of metric kept by node since time
+// @ID clusterSamples
+// @Accept  json
+// @Produce json
+// @Param request body cluster.SamplesRequest true "metric"
+// @Success 200 {array} history.Sample
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Router /cluster/samples [post]
+func (srv *Server) ClusterSamplesHandle(w http.ResponseWriter, r *http.Request) {
+	var request cluster.SamplesRequest
+	if !decodeClusterRequest(w, r, &request) {
+		return
+	}
+	samples := []history.Sample{}
+	if srv.history != nil {
+		samples = append(samples, srv.history.Samples(request.MType, request.ID, request.From)...)
+	}
+	writeClusterResponse(w, samples)
+}
+
+// ClusterScoreHandle godoc
+// @Tags cluster
+// @Summary Anomaly score of gauge owned by node
+// @Description Deviation of the last value of gauge from its baseline kept by node
+// @ID clusterScore
+// @Accept  json
+// @Produce json
+// @Param request body cluster.ScoreRequest true "gauge"
+// @Success 200 {object} cluster.ScoreResponse
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Router /cluster/score [post]
+func (srv *Server) ClusterScoreHandle(w http.ResponseWriter, r *http.Request) {
+	var request cluster.ScoreRequest
+	if !decodeClusterRequest(w, r, &request) {
+		return
+	}
+	var response cluster.ScoreResponse
+	if srv.detector != nil {
+		response.Score, response.Found = srv.detector.Score(request.ID)
+	}
+	writeClusterResponse(w, response)
+}
+
+// ClusterAnomaliesHandle godoc
+// @Tags cluster
+// @Summary Anomalies detected by node
+// @Description Anomalies of gauges owned by node detected since time
+// @ID clusterAnomalies
+// @Accept  json
+// @Produce json
+// @Param request body cluster.AnomaliesRequest true "time"
+// @Success 200 {array} anomaly.Anomaly
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Router /cluster/anomalies [post]
+func (srv *Server) ClusterAnomaliesHandle(w http.ResponseWriter, r *http.Request) {
+	var request cluster.AnomaliesRequest
+	if !decodeClusterRequest(w, r, &request) {
+		return
+	}
+	list := []anomaly.Anomaly{}
+	if srv.detector != nil {
+		list = srv.detector.Anomalies(request.From)
+	}
+	writeClusterResponse(w, list)
+}
+
+// ClusterWatchHandle godoc
+// @Tags cluster
+// @Summary Watch changes of metrics owned by node
+// @Description Stream of changes applied by node, each change is a JSON object on separate line
+// @ID clusterWatch
+// @Accept  json
+// @Produce json
+// @Param request body cluster.WatchRequest true "filter"
+// @Success 200 {string} string "Stream of changes"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Streaming is not supported"
+// @Router /cluster/watch [post]
+func (srv *Server) ClusterWatchHandle(w http.ResponseWriter, r *http.Request) {
+	var request cluster.WatchRequest
+	if !decodeClusterRequest(w, r, &request) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// only changes of this node, other nodes are watched by caller
+	events, cancel := srv.bus.subscribe(WatchFilter{Names: request.Names, Prefix: request.Prefix})
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// caller restarts watch
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "%s\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/cluster"
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+)
+
+// newTestCluster starts count nodes serving internal endpoints of cluster,
+// configure changes settings of nodes if it isn't nil
+func newTestCluster(t *testing.T, count int, configure func(*config.ServerFlags)) []*Server {
+	listeners := make([]*httptest.Server, count)
+	addrs := make([]string, count)
+	for i := range listeners {
+		listeners[i] = httptest.NewUnstartedServer(nil)
+		addrs[i] = listeners[i].Listener.Addr().String()
+	}
+
+	nodes := make([]*Server, count)
+	for i, l := range listeners {
+		settings := &config.ServerFlags{
+			Address:      addrs[i],
+			ClusterNodes: strings.Join(addrs, ","),
+		}
+		if configure != nil {
+			configure(settings)
+		}
+		c, err := newCluster(settings, memstorage.NewMemStorage())
+		require.NoError(t, err)
+		nodes[i] = &Server{storage: c, cluster: c, Address: addrs[i], ClusterSelf: addrs[i]}
+		if settings.Tokens != "" {
+			nodes[i].tokens, err = auth.NewStore(settings.Tokens)
+			require.NoError(t, err)
+		}
+		nodes[i].tlsConfig, err = tlsconfig.Server(settings.TLSCert, settings.TLSKey, settings.TLSClientCA)
+		require.NoError(t, err)
+
+		r := chi.NewMux()
+		r.Group(nodes[i].clusterRoutes)
+		l.Config.Handler = r
+		if nodes[i].tlsConfig != nil {
+			l.TLS = nodes[i].tlsConfig
+			l.StartTLS()
+		} else {
+			l.Start()
+		}
+		t.Cleanup(l.Close)
+	}
+	return nodes
+}
+
+func TestServer_Cluster(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 3, nil)
+
+	var batch []metrics.Metric
+	for i := 0; i < 30; i++ {
+		delta := int64(i)
+		batch = append(batch, *metrics.NewCommonMetric(fmt.Sprintf("requests_%d", i), metrics.MetricTypeCounter, &delta, nil))
+	}
+	// any node accepts writes
+	require.NoError(t, nodes[0].AddMetricsBatch(ctx, batch[:15]))
+	require.NoError(t, nodes[1].AddMetricsBatch(ctx, batch[15:]))
+	value := 2.5
+	require.NoError(t, nodes[2].AddMetricNew(ctx, *metrics.NewCommonMetric("load", metrics.MetricTypeGauge, nil, &value)))
+
+	// metrics are kept and tracked only by owners
+	total := 0
+	for _, node := range nodes {
+		local, err := node.localMetrics(ctx)
+		require.NoError(t, err)
+		assert.NotEmpty(t, local, node.Address)
+		for _, el := range local {
+			assert.True(t, node.cluster.Owns(el.ID), el.ID)
+			assert.False(t, node.updated.get(el.MType, el.ID).IsZero(), el.ID)
+		}
+		total += len(local)
+	}
+	assert.Equal(t, 31, total)
+
+	// reads are merged from all nodes
+	for _, node := range nodes {
+		all, err := node.GetAllMetricsNew(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 31)
+
+		got, err := node.GetMetricValue(ctx, metrics.MetricTypeCounter, "requests_7")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), got)
+		got, err = node.GetMetricValue(ctx, metrics.MetricTypeGauge, "load")
+		require.NoError(t, err)
+		assert.Equal(t, 2.5, got)
+	}
+
+	page, err := nodes[1].SelectMetrics(ctx, storage.Filter{Prefix: "requests_1"}, 5)
+	require.NoError(t, err)
+	var names []string
+	for _, el := range page.Metrics {
+		names = append(names, el.ID)
+	}
+	assert.Equal(t, []string{"requests_1", "requests_10", "requests_11", "requests_12", "requests_13"}, names)
+	assert.NotEmpty(t, page.NextCursor)
+
+	assert.NoError(t, nodes[0].Ping(ctx))
+}
+
+func TestServer_ClusterHandlers(t *testing.T) {
+	nodes := newTestCluster(t, 1, nil)
+	r := chi.NewMux()
+	r.Group(nodes[0].clusterRoutes)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "/cluster/ping", "", http.StatusOK},
+		{http.MethodPost, "/cluster/updates", `[{"id":"PollCount","type":"counter","delta":1}]`, http.StatusOK},
+		{http.MethodGet, "/cluster/updates", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/cluster/updates", `{`, http.StatusBadRequest},
+		{http.MethodPost, "/cluster/metrics", `{"MType":"counter"}`, http.StatusOK},
+		{http.MethodPost, "/cluster/metrics", `{"MType":"unknown"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		assert.Equal(t, tt.want, w.Code, tt.method+" "+tt.path+" "+tt.body)
+	}
+}
+
+func TestServer_Cluster_Auth(t *testing.T) {
+	ctx := context.Background()
+	tokens := writeTokens(t)
+	nodes := newTestCluster(t, 2, func(settings *config.ServerFlags) {
+		settings.Tokens = tokens
+		settings.NodeToken = "admin"
+	})
+
+	// nodes send node token to each other
+	var batch []metrics.Metric
+	for i := 0; i < 10; i++ {
+		delta := int64(i)
+		batch = append(batch, *metrics.NewCommonMetric(fmt.Sprintf("requests_%d", i), metrics.MetricTypeCounter, &delta, nil))
+	}
+	require.NoError(t, nodes[0].AddMetricsBatch(ctx, batch))
+	all, err := nodes[1].GetAllMetricsNew(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 10)
+
+	r := chi.NewMux()
+	r.Group(nodes[0].clusterRoutes)
+	tests := []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{name: "no token", path: "/cluster/metrics", want: http.StatusUnauthorized},
+		{name: "no token for updates", path: "/cluster/updates", want: http.StatusUnauthorized},
+		{name: "reader", path: "/cluster/metrics", token: "reader", want: http.StatusForbidden},
+		{name: "agent", path: "/cluster/updates", token: "agent", want: http.StatusForbidden},
+		{name: "admin", path: "/cluster/metrics", token: "admin", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{}`
+			if tt.path == "/cluster/updates" {
+				body = `[{"id":"PollCount","type":"counter","delta":1}]`
+			}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			if tt.token != "" {
+				req.Header.Set(auth.Header, auth.Bearer(tt.token))
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestNewServer_ClusterRequiresTokens(t *testing.T) {
+	tenants := filepath.Join(t.TempDir(), "tenants.json")
+	require.NoError(t, os.WriteFile(tenants, []byte(`{"tenants":[{"id":"team-a"}]}`), 0600))
+
+	_, err := NewServer(&config.ServerFlags{Address: "localhost:8080", ClusterNodes: "localhost:8080", Tenants: tenants})
+	assert.Error(t, err)
+
+	_, err = NewServer(&config.ServerFlags{Address: "localhost:8080", ClusterNodes: "localhost:8080", Tokens: writeTokens(t)})
+	assert.Error(t, err)
+}
+
+func TestServer_Cluster_PeerRejection(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 2, nil)
+	nodes[1].readOnly.Store(true)
+
+	var batch []metrics.Metric
+	for i := 0; i < 20; i++ {
+		delta := int64(1)
+		batch = append(batch, *metrics.NewCommonMetric(fmt.Sprintf("requests_%d", i), metrics.MetricTypeCounter, &delta, nil))
+	}
+	err := nodes[0].AddMetricsBatch(ctx, batch)
+	require.Error(t, err)
+	// status of peer reaches client instead of internal error
+	assert.Equal(t, http.StatusServiceUnavailable, updateStatus(err))
+	assert.Equal(t, codes.Unavailable, status.Code(updateError(err)))
+}
+
+// flakyStorage fails the first write of batch by error of connection to database
+type flakyStorage struct {
+	storage.Storage
+	writes int
+}
+
+func (s *flakyStorage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	s.writes++
+	if s.writes == 1 {
+		return &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+	}
+	return s.Storage.UpdateBatch(ctx, m)
+}
+
+// countingPeer counts writes routed to peer
+type countingPeer struct {
+	*memstorage.MemStorage
+	writes int
+}
+
+func (p *countingPeer) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	p.writes++
+	return p.MemStorage.UpdateBatch(ctx, m)
+}
+
+func TestServer_Cluster_RetryLocalPart(t *testing.T) {
+	ctx := context.Background()
+	local := &flakyStorage{Storage: memstorage.NewMemStorage()}
+	peer := &countingPeer{MemStorage: memstorage.NewMemStorage()}
+	c, err := cluster.NewStorage("a", retryStorage{local},
+		cluster.NewRing([]string{"a", "b"}, cluster.DefaultReplicas), map[string]cluster.Peer{"b": peer})
+	require.NoError(t, err)
+	srv := &Server{storage: c, cluster: c, StoreInterval: 300}
+
+	var batch []metrics.Metric
+	for i := 0; i < 20; i++ {
+		delta := int64(1)
+		batch = append(batch, *metrics.NewCommonMetric(fmt.Sprintf("requests_%d", i), metrics.MetricTypeCounter, &delta, nil))
+	}
+	require.NoError(t, srv.AddMetricsBatch(ctx, batch))
+
+	// only local part is written again, counters of peer are applied once
+	assert.Equal(t, 2, local.writes)
+	assert.Equal(t, 1, peer.writes)
+	for _, el := range batch {
+		got, err := c.GetValue(ctx, el.MType, el.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), got, el.ID)
+	}
+}
+
+// ownedBy returns name of counter owned by node
+func ownedBy(t *testing.T, node *Server) string {
+	for i := 0; i < 100; i++ {
+		if id := fmt.Sprintf("requests_%d", i); node.cluster.Owns(id) {
+			return id
+		}
+	}
+	require.FailNow(t, "no counter owned by node")
+	return ""
+}
+
+func TestServer_Cluster_State(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestCluster(t, 2, nil)
+	for _, node := range nodes {
+		node.history = history.New(10)
+	}
+	id := ownedBy(t, nodes[1])
+
+	// changes applied by other node are received by subscriber
+	events, cancel := nodes[0].subscribe(WatchFilter{Names: []string{id}})
+	defer cancel()
+	delta := int64(5)
+	write := func() {
+		require.NoError(t, nodes[0].AddMetricsBatch(ctx, []metrics.Metric{*metrics.NewCommonMetric(id, metrics.MetricTypeCounter, &delta, nil)}))
+	}
+	received := func() bool {
+		select {
+		case e := <-events:
+			assert.Equal(t, id, e.Metric.ID)
+			return true
+		default:
+			return false
+		}
+	}
+	// watch of peer starts asynchronously
+	require.Eventually(t, func() bool {
+		write()
+		return received()
+	}, 5*time.Second, 50*time.Millisecond)
+	write()
+	require.Eventually(t, received, 5*time.Second, 10*time.Millisecond)
+
+	// history is kept by owner, but it's available on any node
+	for _, node := range nodes {
+		rate, err := node.CounterRate(ctx, id, time.Minute)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, rate.Samples, 2, node.Address)
+		assert.Positive(t, rate.Increase, node.Address)
+
+		res, err := node.Query(ctx, fmt.Sprintf("increase(%s[1m])", id))
+		require.NoError(t, err)
+		require.Len(t, res.Result, 1, node.Address)
+	}
+
+	// the only node is leader
+	electionCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	leaders := 0
+	for _, node := range nodes {
+		node.wg = &sync.WaitGroup{}
+		node.startLeaderElection(electionCtx)
+		if node.isLeader() {
+			leaders++
+		}
+	}
+	assert.Equal(t, 1, leaders)
+}
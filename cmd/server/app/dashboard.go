@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+// templates of web pages
+var templates = template.Must(template.ParseFS(templatesFS, "templates/*.html"))
+
+// dashboardRow is a row of metrics table on dashboard
+type dashboardRow struct {
+	ID      string
+	Type    string
+	Value   string
+	Group   string
+	Updated time.Time
+	// chart of last values
+	Sparkline template.HTML
+}
+
+// UpdatedUnix returns time of last update in unix seconds, 0 if it's unknown
+func (r dashboardRow) UpdatedUnix() int64 {
+	if r.Updated.IsZero() {
+		return 0
+	}
+	return r.Updated.Unix()
+}
+
+// dashboardPage is data of dashboard template
+type dashboardPage struct {
+	Rows      []dashboardRow
+	Generated time.Time
+}
+
+// updateTimes keeps time of the last update of each metric.
+// Zero value is ready to use
+type updateTimes struct {
+	mx    sync.RWMutex
+	times map[metricKey]time.Time
+}
+
+// metricKey identifies metric in storage
+type metricKey struct {
+	mtype string
+	id    string
+}
+
+func (u *updateTimes) set(m []metrics.Metric, t time.Time) {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+
+	if u.times == nil {
+		u.times = make(map[metricKey]time.Time)
+	}
+	for _, el := range m {
+		u.times[metricKey{el.MType, el.ID}] = t
+	}
+}
+
+func (u *updateTimes) get(mtype, id string) time.Time {
+	u.mx.RLock()
+	defer u.mx.RUnlock()
+	return u.times[metricKey{mtype, id}]
+}
+
+// dashboardRows makes sorted by name rows of dashboard table
+func (srv *Server) dashboardRows(ctx context.Context, all []*metrics.Metric) []dashboardRow {
+	rows := make([]dashboardRow, 0, len(all))
+	for _, el := range all {
+		id := scopedID(ctx, el.ID)
+		rows = append(rows, dashboardRow{
+			ID:        el.ID,
+			Type:      el.MType,
+			Value:     formatValue(el),
+			Group:     metricGroup(el.ID),
+			Updated:   srv.updated.get(el.MType, id),
+			Sparkline: srv.sparkline(el.MType, id),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].ID != rows[j].ID {
+			return rows[i].ID < rows[j].ID
+		}
+		return rows[i].Type < rows[j].Type
+	})
+	return rows
+}
+
+// formatValue returns current value of metric as string
+func formatValue(m *metrics.Metric) string {
+	if m.MType == metrics.MetricTypeCounter {
+		if m.Delta == nil {
+			return ""
+		}
+		return strconv.FormatInt(*m.Delta, 10)
+	}
+	if m.Value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*m.Value, 'f', -1, 64)
+}
+
+// metricGroup returns prefix of metric name used to group metrics on dashboard:
+// part before the first underscore for snake_case names (http_requests_total - http),
+// first word for CamelCase names (HeapAlloc - Heap). Labels are ignored
+func metricGroup(id string) string {
+	name := id
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.IndexAny(name, "_."); i > 0 {
+		return name[:i]
+	}
+
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1]) {
+			return string(runes[:i])
+		}
+	}
+	return name
+}
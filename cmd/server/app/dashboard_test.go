@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_AllMetricsHandle_Dashboard(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	value := 1.0
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+		*metrics.NewCommonMetric("<script>alert(1)</script>", metrics.MetricTypeGauge, nil, &value),
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	srv.AllMetricsHandle(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.NotContains(t, body, "<script>alert(1)</script>")
+	assert.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.Contains(t, body, `<td class="value">1024.5</td>`)
+	assert.Contains(t, body, `data-type="counter"`)
+	assert.Contains(t, body, "Last updated")
+	assert.NotContains(t, body, "unknown</span>")
+}
+
+func TestServer_dashboardRows(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	all, err := srv.GetAllMetricsNew(context.Background())
+	require.NoError(t, err)
+
+	rows := srv.dashboardRows(context.Background(), all)
+	require.Len(t, rows, 4)
+	assert.Equal(t, "Alloc", rows[0].ID)
+	assert.Equal(t, "PollCount", rows[3].ID)
+	assert.Equal(t, "5", rows[3].Value)
+	assert.Equal(t, "Heap", rows[1].Group)
+	for _, row := range rows {
+		assert.False(t, row.Updated.IsZero(), row.ID)
+	}
+}
+
+func Test_metricGroup(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "HeapAlloc", want: "Heap"},
+		{id: "http_requests_total", want: "http"},
+		{id: "CPUutilization0", want: "CPUutilization0"},
+		{id: `latency_bucket{le="0.5"}`, want: "latency"},
+		{id: `Alloc{host="a_b"}`, want: "Alloc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			assert.Equal(t, tt.want, metricGroup(tt.id))
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/encrypt"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/tools/certs"
+)
+
+func gzipJSON(t *testing.T, v any) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	require.NoError(t, json.NewEncoder(gz).Encode(v))
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestServer_DecryptMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	keys := &certs.Settings{PathToCert: filepath.Join(dir, "key.pub"), PathToPrivateKey: filepath.Join(dir, "key")}
+	require.NoError(t, certs.MakeRSACert(keys))
+
+	srv := newTestServer(t, testMetrics())
+	srv.UseEncryption = true
+	srv.PrivateKeyPath = keys.PathToPrivateKey
+	h := srv.router()
+
+	send := func(version string, body []byte) int {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Encoding", "gzip")
+		if version != "" {
+			r.Header.Set(encrypt.VersionHeader, version)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// batch of all runtime gauges is larger than RSA block of 4096-bit key
+	rnd := rand.New(rand.NewSource(1))
+	batch := make([]metrics.Metric, 0, 50)
+	for i := 0; i < 50; i++ {
+		value := rnd.Float64() * 1e9
+		batch = append(batch, metrics.Metric{ID: fmt.Sprintf("RuntimeGauge%d", i), MType: metrics.MetricTypeGauge, Value: &value})
+	}
+	body := gzipJSON(t, batch)
+	require.Greater(t, len(body), 512)
+
+	sealed, err := encrypt.EncryptEnvelope(keys.PathToCert, body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send(encrypt.VersionEnvelope, sealed))
+	v, err := srv.GetMetricValue(context.Background(), metrics.MetricTypeGauge, "RuntimeGauge49")
+	require.NoError(t, err)
+	assert.Equal(t, *batch[49].Value, v)
+
+	// old agents send small batches encrypted by RSA without version
+	value := 42.5
+	legacy, err := encrypt.Encrypt(keys.PathToCert, string(gzipJSON(t, []metrics.Metric{{ID: "Legacy", MType: metrics.MetricTypeGauge, Value: &value}})))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send("", []byte(legacy)))
+	v, err = srv.GetMetricValue(context.Background(), metrics.MetricTypeGauge, "Legacy")
+	require.NoError(t, err)
+	assert.Equal(t, 42.5, v)
+
+	assert.Equal(t, http.StatusBadRequest, send("3", sealed))
+	assert.Equal(t, http.StatusBadRequest, send(encrypt.VersionEnvelope, []byte(legacy)))
+}
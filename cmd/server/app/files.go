@@ -12,7 +12,8 @@ import (
 // SaveToFile saves metrics to file
 func (srv *Server) SaveToFile(ctx context.Context) error {
 	// сериализуем структуру в JSON формат
-	m, err := srv.GetAllMetricsNew(ctx)
+	// in cluster mode each node saves only metrics owned by it
+	m, err := srv.localMetrics(ctx)
 	if err != nil {
 		return err
 	}
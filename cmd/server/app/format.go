@@ -0,0 +1,169 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+// formats of responses with metrics
+const (
+	formatHTML       = "html"
+	formatJSON       = "json"
+	formatText       = "text"
+	formatCSV        = "csv"
+	formatPrometheus = "prometheus"
+)
+
+// content types of formats
+var formatContentTypes = map[string]string{
+	formatHTML:       "text/html",
+	formatJSON:       "application/json",
+	formatText:       "text/plain",
+	formatCSV:        "text/csv",
+	formatPrometheus: "text/plain; version=0.0.4",
+}
+
+// negotiateFormat chooses format of response by Accept header.
+// fallback is used when client accepts any format.
+// It returns false if client accepts none of supported formats
+func negotiateFormat(accept string, fallback string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return fallback, true
+	}
+
+	best, bestQ := "", 0.0
+	for _, el := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(el))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		var format string
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			format = formatHTML
+		case "application/json":
+			format = formatJSON
+		case "text/csv":
+			format = formatCSV
+		case "text/plain":
+			format = formatText
+			if params["version"] == "0.0.4" {
+				format = formatPrometheus
+			}
+		case "text/*", "*/*":
+			format = fallback
+		default:
+			continue
+		}
+		// the first of equally preferred formats wins
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+
+	return best, best != ""
+}
+
+// writeMetrics writes metrics in format which is not HTML
+func writeMetrics(w http.ResponseWriter, format string, list []*metrics.Metric) {
+	body := new(bytes.Buffer)
+	var err error
+	switch format {
+	case formatJSON:
+		err = json.NewEncoder(body).Encode(list)
+	case formatCSV:
+		err = writeCSV(body, list)
+	case formatPrometheus:
+		writePrometheus(body, list)
+	default:
+		for _, el := range list {
+			fmt.Fprintf(body, "%s %s %s\n", el.MType, el.ID, formatValue(el))
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+func writeCSV(body *bytes.Buffer, list []*metrics.Metric) error {
+	writer := csv.NewWriter(body)
+	writer.Write([]string{"name", "type", "value"})
+	for _, el := range list {
+		writer.Write([]string{el.ID, el.MType, formatValue(el)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writePrometheus writes metrics in Prometheus text exposition format.
+// Names of metrics and labels are sanitized, series of one metric are grouped under one TYPE line
+func writePrometheus(body *bytes.Buffer, list []*metrics.Metric) {
+	type series struct {
+		id    string
+		value string
+	}
+	type family struct {
+		name  string
+		mtype string
+	}
+
+	families := make(map[family][]series)
+	for _, el := range list {
+		name, labels, err := metrics.ParseSeriesName(el.ID)
+		if err != nil {
+			// not a series name, so the whole identifier is a name
+			name, labels = el.ID, nil
+		}
+		name = metrics.SanitizeName(name)
+		sanitized := make(map[string]string, len(labels))
+		for k, v := range labels {
+			sanitized[metrics.SanitizeName(k)] = v
+		}
+		f := family{name: name, mtype: el.MType}
+		families[f] = append(families[f], series{
+			id:    metrics.SeriesName(name, sanitized),
+			value: formatValue(el),
+		})
+	}
+
+	keys := make([]family, 0, len(families))
+	for k := range families {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].mtype < keys[j].mtype
+	})
+
+	for _, f := range keys {
+		fmt.Fprintf(body, "# TYPE %s %s\n", f.name, f.mtype)
+		list := families[f]
+		sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+		for _, s := range list {
+			fmt.Fprintf(body, "%s %s\n", s.id, s.value)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_negotiateFormat(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	tests := []struct {
+		name     string
+		accept   string
+		fallback string
+		want     string
+		ok       bool
+	}{
+		{name: "no header", accept: "", fallback: formatText, want: formatText, ok: true},
+		{name: "any", accept: "*/*", fallback: formatHTML, want: formatHTML, ok: true},
+		{name: "browser", accept: browser, fallback: formatText, want: formatHTML, ok: true},
+		{name: "json", accept: "application/json", fallback: formatHTML, want: formatJSON, ok: true},
+		{name: "csv", accept: "text/csv", fallback: formatHTML, want: formatCSV, ok: true},
+		{name: "prometheus", accept: "text/plain;version=0.0.4;q=0.9,*/*;q=0.1", fallback: formatHTML, want: formatPrometheus, ok: true},
+		{name: "plain", accept: "text/plain", fallback: formatHTML, want: formatText, ok: true},
+		{name: "quality", accept: "text/csv;q=0.5, application/json", fallback: formatHTML, want: formatJSON, ok: true},
+		{name: "excluded", accept: "application/json;q=0", fallback: formatHTML, want: "", ok: false},
+		{name: "unsupported", accept: "image/png", fallback: formatHTML, want: "", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := negotiateFormat(tt.accept, tt.fallback)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestServer_AllMetricsHandle_Formats(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			accept:      "application/json",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body: `[{"id":"Alloc","type":"gauge","value":10},{"id":"HeapAlloc","type":"gauge","value":1024.5},` +
+				`{"id":"HeapSys","type":"gauge","value":4096},{"id":"PollCount","type":"counter","delta":5}]` + "\n",
+		},
+		{
+			accept:      "text/plain",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			body:        "gauge Alloc 10\ngauge HeapAlloc 1024.5\ngauge HeapSys 4096\ncounter PollCount 5\n",
+		},
+		{
+			accept:      "text/csv",
+			status:      http.StatusOK,
+			contentType: "text/csv",
+			body:        "name,type,value\nAlloc,gauge,10\nHeapAlloc,gauge,1024.5\nHeapSys,gauge,4096\nPollCount,counter,5\n",
+		},
+		{
+			accept:      "text/plain; version=0.0.4",
+			status:      http.StatusOK,
+			contentType: "text/plain; version=0.0.4",
+			body: "# TYPE Alloc gauge\nAlloc 10\n# TYPE HeapAlloc gauge\nHeapAlloc 1024.5\n" +
+				"# TYPE HeapSys gauge\nHeapSys 4096\n# TYPE PollCount counter\nPollCount 5\n",
+		},
+		{
+			accept: "image/png",
+			status: http.StatusNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			srv.AllMetricsHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.contentType, res.Header.Get("Content-Type"))
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_GetValueHandle_Formats(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "1024.5"},
+		{accept: "*/*", want: "1024.5"},
+		{accept: "application/json", want: `{"id":"HeapAlloc","type":"gauge","value":1024.5}`},
+		{accept: "text/csv", want: "name,type,value\nHeapAlloc,gauge,1024.5\n"},
+		{accept: "text/plain;version=0.0.4", want: "# TYPE HeapAlloc gauge\nHeapAlloc 1024.5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/value/gauge/HeapAlloc", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			srv.GetValueHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tt.want, w.Body.String())
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/value/counter/PollCount", nil)
+	r.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+
+	srv.GetValueHandle(w, r)
+
+	assert.Equal(t, "text/html", w.Result().Header.Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `<div class="value">5</div>`)
+}
+
+func Test_writePrometheus(t *testing.T) {
+	srv := newTestServer(t, nil)
+	requests, other := int64(3), int64(1)
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+		*metrics.NewCommonMetric(`http.requests{code="200",service.name="api"}`, metrics.MetricTypeCounter, &requests, nil),
+		*metrics.NewCommonMetric(`http.requests{code="500",service.name="api"}`, metrics.MetricTypeCounter, &other, nil),
+	}))
+	all, err := srv.GetAllMetricsNew(context.Background())
+	require.NoError(t, err)
+
+	body := new(bytes.Buffer)
+	writePrometheus(body, all)
+
+	assert.Equal(t, "# TYPE http_requests counter\n"+
+		`http_requests{code="200",service_name="api"} 3`+"\n"+
+		`http_requests{code="500",service_name="api"} 1`+"\n", body.String())
+}
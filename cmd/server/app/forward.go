@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/forward"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+)
+
+// time to send buffered metrics to upstreams on stop
+const forwardFlushTimeout = 5 * time.Second
+
+// newForwarder creates forwarder to upstream servers from settings
+func newForwarder(settings *config.ServerFlags) (*forward.Forwarder, error) {
+	cfg := forward.DefaultConfig()
+	for _, target := range strings.Split(settings.ForwardURLs, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			cfg.Upstreams = append(cfg.Upstreams, target)
+		}
+	}
+	cfg.Filter.Regex = settings.ForwardRegex
+	cfg.HashKey = settings.ForwardKey
+	cfg.CryptoKey = settings.ForwardCryptoKey
+	cfg.Token = settings.NodeToken
+	tlsConfig, err := tlsconfig.Client(settings.PeerTLSCA, settings.PeerTLSCert, settings.PeerTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLS = tlsConfig
+	cfg.BatchSize = settings.ForwardBatchSize
+	cfg.FlushInterval = time.Duration(settings.ForwardInterval) * time.Second
+	return forward.New(cfg)
+}
+
+// startForwarding sends ingested metrics to upstreams until ctx is done
+func (srv *Server) startForwarding(ctx context.Context) {
+	if srv.forwarder == nil {
+		return
+	}
+	srv.forwarding.Store(true)
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.forwarder.Run(ctx, forwardFlushTimeout, func(err error) {
+			Sugar.Infoln("Forwarding of metrics failed: ", err.Error())
+		})
+		Sugar.Infoln("остановка пересылки метрик")
+	}()
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Forwarding(t *testing.T) {
+	// upstream is another collector
+	global := newTestServer(t, nil)
+	upstream := httptest.NewServer(GzipMiddleware(http.HandlerFunc(global.UpdateBatchJSONHandle)))
+	defer upstream.Close()
+
+	srv := newTestServer(t, testMetrics())
+	forwarder, err := newForwarder(&config.ServerFlags{
+		ForwardURLs:      upstream.URL + "/",
+		ForwardRegex:     "^(PollCount|Alloc)$",
+		ForwardBatchSize: 100,
+		ForwardInterval:  1,
+	})
+	require.NoError(t, err)
+	srv.forwarder = forwarder
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.wg = &sync.WaitGroup{}
+	srv.startForwarding(ctx)
+
+	poll := int64(3)
+	alloc := 42.0
+	require.NoError(t, srv.AddMetricsBatch(ctx, []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &poll, nil),
+		*metrics.NewCommonMetric("Alloc", metrics.MetricTypeGauge, nil, &alloc),
+		*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &alloc),
+	}))
+	// the rest is sent on stop
+	cancel()
+	srv.wg.Wait()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	global.AllMetricsHandle(w, r)
+	var got []metrics.Metric
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	values := make(map[string]string, len(got))
+	for _, el := range got {
+		values[el.ID] = formatValue(&el)
+	}
+	// counter is forwarded as delta, not as total of this server
+	assert.Equal(t, map[string]string{"PollCount": "3", "Alloc": "42"}, values)
+}
+
+func TestServer_ForwardingNotStarted(t *testing.T) {
+	forwarder, err := newForwarder(&config.ServerFlags{
+		ForwardURLs:      "http://localhost:1",
+		ForwardBatchSize: 100,
+		ForwardInterval:  1,
+	})
+	require.NoError(t, err)
+	srv := newTestServer(t, nil)
+	srv.forwarder = forwarder
+
+	// e.g. restored values
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), testMetrics()))
+	assert.Equal(t, map[string]int{"http://localhost:1": 0}, forwarder.Pending())
+}
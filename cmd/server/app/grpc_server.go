@@ -2,14 +2,18 @@ package app
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"time"
 
 	"github.com/kvvPro/metric-collector/internal/metrics"
 	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/storage"
 	pb "github.com/kvvPro/metric-collector/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
@@ -20,11 +24,7 @@ func (srv *Server) startGRPCServer(ctx context.Context) {
 	if err != nil {
 		Sugar.Fatal(err)
 	}
-	// создаём gRPC-сервер без зарегистрированной службы
-	srv.grpcServer = grpc.NewServer(grpc.ChainUnaryInterceptor(srv.loggingInterceptor,
-		srv.validateIPInterceptor))
-	// регистрируем сервис
-	pb.RegisterMetricServerServer(srv.grpcServer, srv)
+	srv.grpcServer = srv.newGRPCServer()
 
 	Sugar.Infoln("Сервер gRPC начал работу")
 	// получаем запрос gRPC
@@ -35,6 +35,32 @@ func (srv *Server) startGRPCServer(ctx context.Context) {
 	}()
 }
 
+// newGRPCServer creates gRPC server with registered MetricServer and Replication services
+func (srv *Server) newGRPCServer() *grpc.Server {
+	return srv.newGRPCServerWith(func(s *grpc.Server) {
+		pb.RegisterMetricServerServer(s, srv)
+		pb.RegisterReplicationServer(s, &replicationService{srv: srv})
+	})
+}
+
+// newGRPCServerWith creates gRPC server with services registered by register
+func (srv *Server) newGRPCServerWith(register func(s *grpc.Server)) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(srv.loggingInterceptor,
+		srv.validateIPInterceptor, srv.clientInterceptor, srv.authInterceptor,
+		srv.rateLimitInterceptor, srv.tenantInterceptor),
+		grpc.ChainStreamInterceptor(srv.loggingStreamInterceptor,
+			srv.validateIPStreamInterceptor, srv.clientStreamInterceptor, srv.authStreamInterceptor,
+			srv.rateLimitStreamInterceptor, srv.tenantStreamInterceptor)}
+	if srv.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(srv.tlsConfig)))
+	}
+	// создаём gRPC-сервер без зарегистрированной службы
+	s := grpc.NewServer(opts...)
+	// регистрируем сервис
+	register(s)
+	return s
+}
+
 func (srv *Server) stopGRPCServer(ctx context.Context) {
 	stopped := make(chan struct{})
 	Sugar.Infoln("Попытка мягко завершить сервер")
@@ -53,7 +79,38 @@ func (srv *Server) stopGRPCServer(ctx context.Context) {
 	}
 }
 
+// contextServerStream replaces context of stream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
 func (srv *Server) validateIPInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := srv.checkClientIP(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (srv *Server) validateIPStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := srv.checkClientIP(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srvIface, ss)
+}
+
+// checkClientIP checks that client IP from metadata is in trusted subnet,
+// clients with verified certificate are trusted regardless of IP.
+// As ValidateIP of HTTP server, all clients are trusted if subnet isn't set
+func (srv *Server) checkClientIP(ctx context.Context) error {
+	if srv.TrustedSubnet == "" || grpcPeerName(ctx) != "" {
+		return nil
+	}
+
 	var clientIP string
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		param := md.Get("X-Real-IP")
@@ -62,16 +119,16 @@ func (srv *Server) validateIPInterceptor(ctx context.Context, req interface{}, i
 		}
 	}
 	if len(clientIP) == 0 {
-		return nil, status.Error(codes.Aborted, "not found client IP")
+		return status.Error(codes.Aborted, "not found client IP")
 	}
 	trusted, err := ip.CheckIPInSubnet(clientIP, srv.TrustedSubnet)
 	if err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+		return status.Error(codes.Aborted, err.Error())
 	}
 	if !trusted {
-		return nil, status.Error(codes.Aborted, "client IP not in trusted subnet")
+		return status.Error(codes.Aborted, "client IP not in trusted subnet")
 	}
-	return handler(ctx, req)
+	return nil
 }
 
 func (srv *Server) loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -89,29 +146,278 @@ func (srv *Server) loggingInterceptor(ctx context.Context, req interface{}, info
 	return h, err
 }
 
+func (srv *Server) loggingStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	err := handler(srvIface, ss)
+
+	duration := time.Since(start)
+
+	Sugar.Infoln(
+		"uri", info.FullMethod,
+		"duration", duration,
+		"err", err,
+	)
+	return err
+}
+
 func (srv *Server) PushMetrics(ctx context.Context, in *pb.PushMetricsRequest) (*pb.PushMetricsResponse, error) {
 	var response pb.PushMetricsResponse
 
 	if err := check(in.Metrics); err != nil {
 		return nil, err
 	} else {
-		var localMetrics = make([]metrics.Metric, 0)
-		for _, el := range in.Metrics {
-			localMetrics = append(localMetrics, metrics.Metric{
-				ID:    el.ID,
-				MType: el.MType,
-				Delta: el.Delta,
-				Value: el.Value,
-			})
-		}
-		err := srv.AddMetricsBatch(ctx, localMetrics)
+		err := srv.AddMetricsBatch(ctx, fromProtoMetrics(in.Metrics))
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, updateError(err)
+		}
+	}
+	return &response, nil
+}
+
+// settings of acknowledgements in PushMetricsStream:
+// server sends ack after each ackBatches batches or once in ackInterval
+const (
+	ackBatches  = 10
+	ackInterval = time.Second
+)
+
+// PushMetricsStream receives batches of metrics from long-lived stream
+// and applies them as PushMetrics does. Server periodically acknowledges
+// count of applied metrics, the final ack is sent when client closes the stream.
+// Each batch takes token of rate limit, rejected batch finishes the stream
+// after ack with delay before retry.
+func (srv *Server) PushMetricsStream(stream pb.MetricServer_PushMetricsStreamServer) error {
+	ctx := stream.Context()
+
+	batches := make(chan *pb.PushMetricsRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case batches <- in:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+
+	var ack pb.PushMetricsAck
+	var unacked int
+	sendAck := func() error {
+		unacked = 0
+		return stream.Send(&pb.PushMetricsAck{
+			Applied: ack.Applied,
+			Batches: ack.Batches,
+		})
+	}
+
+	for {
+		select {
+		case in := <-batches:
+			if ok, wait := srv.allowBatch(ctx); !ok {
+				// zero delay means that batch wasn't rejected
+				delay := wait.Milliseconds()
+				if delay < 1 {
+					delay = 1
+				}
+				stream.Send(&pb.PushMetricsAck{
+					Applied:      ack.Applied,
+					Batches:      ack.Batches,
+					RetryAfterMs: delay,
+				})
+				stream.SetTrailer(metadata.Pairs(RetryAfterHeader, retryAfter(wait)))
+				return status.Error(codes.ResourceExhausted, "too many requests")
+			}
+			err := check(in.Metrics)
+			if err == nil {
+				if err = srv.AddMetricsBatch(ctx, fromProtoMetrics(in.Metrics)); err != nil {
+					err = updateError(err)
+				}
+			}
+			if err != nil {
+				// applied batches are acknowledged before error,
+				// so client retries only rejected batches
+				if unacked > 0 {
+					sendAck()
+				}
+				return err
+			}
+			ack.Applied += int64(len(in.Metrics))
+			ack.Batches++
+			unacked++
+			if unacked >= ackBatches {
+				if err := sendAck(); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if unacked > 0 {
+				if err := sendAck(); err != nil {
+					return err
+				}
+			}
+		case err := <-recvErr:
+			if errors.Is(err, io.EOF) {
+				// client closed the stream
+				return sendAck()
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// GetMetric returns current value of metric with requested name and type
+func (srv *Server) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	if in.ID == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Missing name of metric")
+	}
+	if !isValidType(in.MType) {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid type")
+	}
+
+	val, err := srv.GetMetricValue(ctx, in.MType, in.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "metric %v not found", in.ID)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetMetricResponse{Metric: toProtoMetric(metricWithValue(in.MType, in.ID, val))}, nil
+}
+
+// GetMetrics returns current values of metrics with requested names.
+// Each name is looked up in storage for every type of metric
+func (srv *Server) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	response := pb.GetMetricsResponse{
+		Metrics: make([]*pb.Metric, 0, len(in.IDs)),
+	}
+	for _, id := range in.IDs {
+		found := false
+		for _, t := range []string{metrics.MetricTypeCounter, metrics.MetricTypeGauge} {
+			val, err := srv.GetMetricValue(ctx, t, id)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			found = true
+			response.Metrics = append(response.Metrics, toProtoMetric(metricWithValue(t, id, val)))
+		}
+		if !found {
+			response.Missing = append(response.Missing, id)
 		}
 	}
+
 	return &response, nil
 }
 
+// ListMetrics returns page of metrics sorted by name.
+// Metrics can be filtered by prefix of name
+func (srv *Server) ListMetrics(ctx context.Context, in *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	if in.PageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid page size")
+	}
+
+	after, err := decodePageToken(in.PageToken)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid page token")
+	}
+
+	page, err := srv.SelectMetrics(ctx, storage.Filter{Prefix: in.Prefix, After: after}, int(in.PageSize))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := pb.ListMetricsResponse{
+		Metrics:       make([]*pb.Metric, 0, len(page.Metrics)),
+		NextPageToken: page.NextCursor,
+	}
+	for _, el := range page.Metrics {
+		response.Metrics = append(response.Metrics, toProtoMetric(el))
+	}
+
+	return &response, nil
+}
+
+// GetCounterRate returns increase and rate of counter over time window
+func (srv *Server) GetCounterRate(ctx context.Context, in *pb.GetCounterRateRequest) (*pb.GetCounterRateResponse, error) {
+	if in.WindowSeconds < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid window")
+	}
+	window := defaultRateWindow
+	if in.WindowSeconds > 0 {
+		window = time.Duration(in.WindowSeconds) * time.Second
+	}
+
+	rate, err := srv.CounterRate(ctx, in.ID, window)
+	if errors.Is(err, errCounterNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetCounterRateResponse{
+		ID:       rate.ID,
+		Increase: rate.Increase,
+		Rate:     rate.Rate,
+		Samples:  int32(rate.Samples),
+	}, nil
+}
+
+// WatchMetrics streams changes of metrics selected by names or prefix
+func (srv *Server) WatchMetrics(in *pb.WatchMetricsRequest, stream pb.MetricServer_WatchMetricsServer) error {
+	filter := WatchFilter{
+		Names:  in.IDs,
+		Prefix: in.Prefix,
+	}
+	err := srv.watch(stream.Context(), filter, in.Initial, func(e MetricEvent) error {
+		return stream.Send(&pb.MetricUpdate{
+			Metric:    toProtoMetric(&e.Metric),
+			Timestamp: e.Time.UnixMilli(),
+		})
+	})
+	if errors.Is(err, errSlowSubscriber) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
+
+func fromProtoMetrics(in []*pb.Metric) []metrics.Metric {
+	var localMetrics = make([]metrics.Metric, 0, len(in))
+	for _, el := range in {
+		localMetrics = append(localMetrics, metrics.Metric{
+			ID:    el.ID,
+			MType: el.MType,
+			Delta: el.Delta,
+			Value: el.Value,
+		})
+	}
+	return localMetrics
+}
+
+func toProtoMetric(m *metrics.Metric) *pb.Metric {
+	return &pb.Metric{
+		ID:    m.ID,
+		MType: m.MType,
+		Delta: m.Delta,
+		Value: m.Value,
+	}
+}
+
 func check(inboundMetrics []*pb.Metric) error {
 	for _, m := range inboundMetrics {
 		if m.ID == "" {
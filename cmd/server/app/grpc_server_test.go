@@ -0,0 +1,270 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/ratelimit"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	pb "github.com/kvvPro/metric-collector/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newBufconnClient starts gRPC server of srv on in-memory listener
+// and returns client connected to it
+func newBufconnClient(t *testing.T, srv *Server) pb.MetricServerClient {
+	listener := bufconn.Listen(1024 * 1024)
+	s := srv.newGRPCServer()
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricServerClient(conn)
+}
+
+func newTestServer(t *testing.T, m []metrics.Metric) *Server {
+	memst := memstorage.NewMemStorage()
+	srv := &Server{
+		storage: memst,
+	}
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), m))
+	return srv
+}
+
+func testMetrics() []metrics.Metric {
+	poll := int64(5)
+	heap := 1024.5
+	sys := 4096.0
+	alloc := 10.0
+	return []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &poll, nil),
+		*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &heap),
+		*metrics.NewCommonMetric("HeapSys", metrics.MetricTypeGauge, nil, &sys),
+		*metrics.NewCommonMetric("Alloc", metrics.MetricTypeGauge, nil, &alloc),
+	}
+}
+
+func TestServer_GetMetric(t *testing.T) {
+	client := newBufconnClient(t, newTestServer(t, testMetrics()))
+
+	tests := []struct {
+		name string
+		req  *pb.GetMetricRequest
+		code codes.Code
+		want int64
+	}{
+		{
+			name: "counter",
+			req:  &pb.GetMetricRequest{ID: "PollCount", MType: metrics.MetricTypeCounter},
+			code: codes.OK,
+			want: 5,
+		},
+		{
+			name: "wrong type",
+			req:  &pb.GetMetricRequest{ID: "PollCount", MType: metrics.MetricTypeGauge},
+			code: codes.NotFound,
+		},
+		{
+			name: "invalid type",
+			req:  &pb.GetMetricRequest{ID: "PollCount", MType: "histogram"},
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.GetMetric(context.Background(), tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				require.NotNil(t, resp)
+				assert.Equal(t, tt.req.ID, resp.Metric.ID)
+				assert.Equal(t, tt.req.MType, resp.Metric.MType)
+				assert.Equal(t, tt.want, resp.Metric.GetDelta())
+			}
+		})
+	}
+}
+
+// noScanStorage fails reading of all metrics
+type noScanStorage struct {
+	storage.Storage
+}
+
+func (s noScanStorage) GetAllMetricsNew(context.Context) ([]*metrics.Metric, error) {
+	return nil, errors.New("all metrics are read")
+}
+
+func TestServer_GetMetrics(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	// requested metrics are looked up by name
+	srv.storage = noScanStorage{srv.storage}
+	client := newBufconnClient(t, srv)
+
+	resp, err := client.GetMetrics(context.Background(), &pb.GetMetricsRequest{
+		IDs: []string{"HeapAlloc", "Unknown", "PollCount"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, resp.Metrics, 2)
+	assert.Equal(t, "HeapAlloc", resp.Metrics[0].ID)
+	assert.Equal(t, 1024.5, resp.Metrics[0].GetValue())
+	assert.Equal(t, "PollCount", resp.Metrics[1].ID)
+	assert.Equal(t, []string{"Unknown"}, resp.Missing)
+}
+
+func TestServer_ListMetrics(t *testing.T) {
+	client := newBufconnClient(t, newTestServer(t, testMetrics()))
+	ctx := context.Background()
+
+	// all metrics page by page
+	names := make([]string, 0)
+	token := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10, "too many pages")
+		resp, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{PageSize: 3, PageToken: token})
+		require.NoError(t, err)
+		for _, m := range resp.Metrics {
+			names = append(names, m.ID)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		token = resp.NextPageToken
+	}
+	assert.Equal(t, []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount"}, names)
+
+	// filter by prefix
+	resp, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{Prefix: "Heap"})
+	require.NoError(t, err)
+	require.Len(t, resp.Metrics, 2)
+	assert.Equal(t, "HeapAlloc", resp.Metrics[0].ID)
+	assert.Equal(t, "HeapSys", resp.Metrics[1].ID)
+	assert.Empty(t, resp.NextPageToken)
+
+	_, err = client.ListMetrics(ctx, &pb.ListMetricsRequest{PageToken: "%%%"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_PushMetricsStream(t *testing.T) {
+	srv := newTestServer(t, nil)
+	client := newBufconnClient(t, srv)
+
+	stream, err := client.PushMetricsStream(context.Background())
+	require.NoError(t, err)
+
+	batchCount := ackBatches + 2
+	for i := 0; i < batchCount; i++ {
+		delta := int64(1)
+		value := float64(i)
+		err := stream.Send(&pb.PushMetricsRequest{Metrics: []*pb.Metric{
+			{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &delta},
+			{ID: "RandomValue", MType: metrics.MetricTypeGauge, Value: &value},
+		}})
+		require.NoError(t, err)
+	}
+
+	// periodic ack after ackBatches batches
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(ackBatches), ack.Batches)
+	assert.Equal(t, int64(2*ackBatches), ack.Applied)
+
+	// final ack after closing of stream
+	require.NoError(t, stream.CloseSend())
+	var last *pb.PushMetricsAck
+	for {
+		ack, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		last = ack
+	}
+	require.NotNil(t, last)
+	assert.Equal(t, int64(batchCount), last.Batches)
+	assert.Equal(t, int64(2*batchCount), last.Applied)
+
+	poll, err := srv.GetMetricValue(context.Background(), metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(batchCount), poll)
+}
+
+func TestServer_PushMetricsStream_InvalidMetric(t *testing.T) {
+	client := newBufconnClient(t, newTestServer(t, nil))
+
+	stream, err := client.PushMetricsStream(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&pb.PushMetricsRequest{Metrics: []*pb.Metric{
+		{ID: "PollCount", MType: "histogram"},
+	}}))
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_PushMetricsStream_RateLimited(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.limiter = ratelimit.NewLimiter([]ratelimit.Rule{{Route: pushStreamMethod, Rate: 0.5, Burst: 2}})
+	client := newBufconnClient(t, srv)
+
+	stream, err := client.PushMetricsStream(context.Background())
+	require.NoError(t, err)
+
+	// each batch takes token, not only opening of stream
+	for i := 0; i < 3; i++ {
+		delta := int64(1)
+		require.NoError(t, stream.Send(&pb.PushMetricsRequest{Metrics: []*pb.Metric{
+			{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &delta},
+		}}))
+	}
+
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), ack.Batches)
+	assert.Positive(t, ack.RetryAfterMs)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"2"}, stream.Trailer().Get(RetryAfterHeader))
+
+	poll, err := srv.GetMetricValue(context.Background(), metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), poll)
+}
+
+func TestServer_PushMetricsStream_AckBeforeError(t *testing.T) {
+	client := newBufconnClient(t, newTestServer(t, nil))
+
+	stream, err := client.PushMetricsStream(context.Background())
+	require.NoError(t, err)
+
+	value := 1.5
+	require.NoError(t, stream.Send(&pb.PushMetricsRequest{Metrics: []*pb.Metric{
+		{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value},
+	}}))
+	require.NoError(t, stream.Send(&pb.PushMetricsRequest{Metrics: []*pb.Metric{
+		{ID: "PollCount", MType: "histogram"},
+	}}))
+
+	// the first batch is applied and acknowledged before rejection of the second one
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ack.Batches)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
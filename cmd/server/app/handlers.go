@@ -10,14 +10,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kvvPro/metric-collector/internal/encrypt"
 	"github.com/kvvPro/metric-collector/internal/hash"
-	mc "github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/metrics"
 	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
 	"go.uber.org/zap"
 )
 
@@ -61,6 +63,20 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Flush sends buffered data to client, it's required for streaming responses
+func (r *loggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Flush sends buffered data to client, it's required for streaming responses
+func (r *hashResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (r *hashResponseWriter) Write(b []byte) (int, error) {
 	// записываем ответ, используя оригинальный http.ResponseWriter
 	if r.SetHash {
@@ -100,7 +116,8 @@ func WithLogging(h http.Handler) http.Handler {
 func (srv *Server) ValidateIP(h http.Handler) http.Handler {
 	validateIPFunc := func(w http.ResponseWriter, r *http.Request) {
 
-		if srv.TrustedSubnet != "" {
+		// клиенты с проверенным сертификатом не зависят от IP
+		if srv.TrustedSubnet != "" && tlsconfig.PeerName(r.TLS) == "" {
 			clientIP := r.Header.Get("X-Real-IP")
 			if clientIP == "" {
 				http.Error(w, errors.New("not found client IP").Error(), http.StatusBadRequest)
@@ -135,15 +152,15 @@ func (srv *Server) DecryptMiddleware(h http.Handler) http.Handler {
 				return
 			}
 
-			// decrypt only non-empty data
+			// decrypt only non-empty data, old agents don't send version of encryption
 			if len(data) > 0 {
-				decryptBody, err := encrypt.Decrypt(srv.PrivateKeyPath, string(data))
+				decryptBody, err := encrypt.DecryptVersion(srv.PrivateKeyPath, r.Header.Get(encrypt.VersionHeader), data)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
 				// возвращаем тело запроса
-				r.Body = io.NopCloser(bytes.NewReader([]byte(decryptBody)))
+				r.Body = io.NopCloser(bytes.NewReader(decryptBody))
 			}
 		}
 
@@ -196,6 +213,8 @@ func GzipMiddleware(h http.Handler) http.Handler {
 
 func (srv *Server) CheckHashMiddleware(h http.Handler) http.Handler {
 	checkHashFunc := func(w http.ResponseWriter, r *http.Request) {
+		// tenant with own key signs requests by it
+		key := srv.hashKey(r.Context())
 		requestHash := r.Header.Get("HashSHA256")
 		if requestHash != "" {
 			// проверяем хэш
@@ -204,7 +223,7 @@ func (srv *Server) CheckHashMiddleware(h http.Handler) http.Handler {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			originalHash := hash.GetHashSHA256(string(data), srv.HashKey)
+			originalHash := hash.GetHashSHA256(string(data), key)
 			decodeHash, err := base64.URLEncoding.DecodeString(requestHash)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -221,8 +240,8 @@ func (srv *Server) CheckHashMiddleware(h http.Handler) http.Handler {
 		// подменяем на наш writer
 		hw := hashResponseWriter{
 			ResponseWriter: w,
-			SetHash:        srv.CheckHash,
-			HashKey:        srv.HashKey,
+			SetHash:        srv.CheckHash || key != "",
+			HashKey:        key,
 		}
 
 		// передаём управление хендлеру
@@ -294,7 +313,7 @@ func (srv *Server) UpdateHandle(w http.ResponseWriter, r *http.Request) {
 	metricValue := params[4]
 	err := srv.AddMetric(r.Context(), metricType, metricName, metricValue)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), updateStatus(err))
 		return
 	}
 
@@ -326,7 +345,7 @@ func (srv *Server) UpdateJSONHandle(w http.ResponseWriter, r *http.Request) {
 	for _, m := range requestedMetrics {
 		err := srv.AddMetricNew(r.Context(), m)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), updateStatus(err))
 			return
 		}
 	}
@@ -375,7 +394,7 @@ func (srv *Server) UpdateBatchJSONHandle(w http.ResponseWriter, r *http.Request)
 
 	err := srv.AddMetricsBatch(r.Context(), requestedMetrics)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), updateStatus(err))
 		return
 	}
 
@@ -445,16 +464,18 @@ func (srv *Server) GetValueJSONHandle(w http.ResponseWriter, r *http.Request) {
 // GetValueHandle godoc
 // @Tags getvalue
 // @Summary Get value of existed metric
-// @Description Get value of existed metric
+// @Description Get value of existed metric. Format of response is chosen by Accept header:
+// @Description plain value by default, HTML, JSON, CSV or Prometheus text format
 // @ID getvalue
 // @Accept  plain
-// @Produce plain
+// @Produce plain,html,json,text/csv
 // @Param type path string true "Metric type"
 // @Param name path string true "Metric name"
 // @Success 200 {string} string "OK"
 // @Failure 400 {string} string "Invalid type"
 // @Failure 404 {string} string "Missing name of metric"
 // @Failure 405 {string} string "Invalid request type"
+// @Failure 406 {string} string "Not acceptable"
 // @Failure 500 {string} string "Internal error"
 // @Router /value/{type}/{name} [get]
 func (srv *Server) GetValueHandle(w http.ResponseWriter, r *http.Request) {
@@ -465,25 +486,74 @@ func (srv *Server) GetValueHandle(w http.ResponseWriter, r *http.Request) {
 	metricType := params[2]
 	metricName := params[3]
 
+	format, ok := negotiateFormat(r.Header.Get("Accept"), formatText)
+	if !ok {
+		http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+		return
+	}
+
 	val, err := srv.GetMetricValue(r.Context(), metricType, metricName)
 	if val == nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	io.WriteString(w, fmt.Sprintf("%v", val))
-	w.WriteHeader(http.StatusOK)
+	switch format {
+	case formatText:
+		io.WriteString(w, fmt.Sprintf("%v", val))
+		w.WriteHeader(http.StatusOK)
+	case formatJSON:
+		body, err := json.Marshal(metricWithValue(metricType, metricName, val))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", formatContentTypes[format])
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	case formatHTML:
+		body := new(bytes.Buffer)
+		m := metricWithValue(metricType, metricName, val)
+		if err := templates.ExecuteTemplate(body, "value", dashboardRow{
+			ID:      m.ID,
+			Type:    m.MType,
+			Value:   formatValue(m),
+			Updated: srv.updated.get(m.MType, scopedID(r.Context(), m.ID)),
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", formatContentTypes[format])
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+	default:
+		writeMetrics(w, format, []*metrics.Metric{metricWithValue(metricType, metricName, val)})
+	}
+}
+
+// metricWithValue makes metric from value returned by storage
+func metricWithValue(mtype, id string, val any) *metrics.Metric {
+	m := &metrics.Metric{ID: id, MType: mtype}
+	switch v := val.(type) {
+	case int64:
+		m.Delta = &v
+	case float64:
+		m.Value = &v
+	}
+	return m
 }
 
 // AllMetricsHandle godoc
 // @Tags getvalue
 // @Summary Get all metrics
-// @Description Get all metrics with current values
+// @Description Dashboard with all metrics and their current values. Format of response is chosen by Accept header:
+// @Description HTML by default, JSON, plain text, CSV or Prometheus text format
 // @ID getvalue
 // @Accept  plain
-// @Produce json
+// @Produce html,json,plain,text/csv
 // @Success 200 {string} string "OK"
 // @Failure 405 {string} string "Invalid request type"
+// @Failure 406 {string} string "Not acceptable"
 // @Failure 500 {string} string "Internal error"
 // @Router /value/{type}/{name} [get]
 func (srv *Server) AllMetricsHandle(w http.ResponseWriter, r *http.Request) {
@@ -498,40 +568,40 @@ func (srv *Server) AllMetricsHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := srv.GetAllMetricsNew(r.Context())
+	format, ok := negotiateFormat(r.Header.Get("Accept"), formatHTML)
+	if !ok {
+		http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+		return
+	}
+
+	all, err := srv.GetAllMetricsNew(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	body := `<html>
-				<head>
-				<title></title>
-				</head>
-				<body>
-					<table border="1" cellpadding="1" cellspacing="1" style="width: 500px">
-						<thead>
-							<tr>
-								<th scope="col">Metric name</th>
-								<th scope="col">Value</th>
-							</tr>
-						</thead>
-						<tbody>
-							%rows
-						</tbody>
-					</table>
-				</body>
-			</html>`
-	rows := ""
-	for _, el := range metrics {
-		if el.MType == mc.MetricTypeCounter {
-			rows += fmt.Sprintf("<tr><th>%v</th><th>%v</th></tr>", el.ID, *(el.Delta))
-		} else {
-			rows += fmt.Sprintf("<tr><th>%v</th><th>%v</th></tr>", el.ID, *(el.Value))
-		}
+
+	if format != formatHTML {
+		sort.Slice(all, func(i, j int) bool {
+			if all[i].ID != all[j].ID {
+				return all[i].ID < all[j].ID
+			}
+			return all[i].MType < all[j].MType
+		})
+		writeMetrics(w, format, all)
+		return
+	}
+
+	page := dashboardPage{
+		Rows:      srv.dashboardRows(r.Context(), all),
+		Generated: time.Now(),
+	}
+	body := new(bytes.Buffer)
+	if err := templates.ExecuteTemplate(body, "dashboard", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	body = strings.ReplaceAll(body, "%rows", rows)
 	w.Header().Set("Content-Type", "text/html")
-	io.WriteString(w, body)
 	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
 }
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+)
+
+// ClientMiddleware identifies client of request for per-client limits by common name
+// of its verified certificate, or by IP if client didn't present certificate.
+// AuthMiddleware replaces identity by name of token. IP of client is taken
+// from X-Real-IP only if request came from trusted proxy, so client can't change its identity
+func (srv *Server) ClientMiddleware(h http.Handler) http.Handler {
+	clientFunc := func(w http.ResponseWriter, r *http.Request) {
+		client := tlsconfig.PeerName(r.TLS)
+		if client == "" {
+			client = srv.clientIP(remoteHost(r.RemoteAddr), r.Header.Get("X-Real-IP"))
+		}
+		h.ServeHTTP(w, r.WithContext(cardinality.WithClient(r.Context(), client)))
+	}
+	return http.HandlerFunc(clientFunc)
+}
+
+func (srv *Server) clientInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(cardinality.WithClient(ctx, srv.grpcClient(ctx)), req)
+}
+
+func (srv *Server) clientStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := cardinality.WithClient(ss.Context(), srv.grpcClient(ss.Context()))
+	return handler(srvIface, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcClient returns common name of verified certificate of client,
+// otherwise IP of client as clientIP does
+func (srv *Server) grpcClient(ctx context.Context) string {
+	if name := grpcPeerName(ctx); name != "" {
+		return name
+	}
+	var remote, realIP string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = remoteHost(p.Addr.String())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("X-Real-IP"); len(v) > 0 {
+			realIP = v[0]
+		}
+	}
+	return srv.clientIP(remote, realIP)
+}
+
+// clientIP returns IP of client: realIP passed by trusted proxy with remote address,
+// otherwise remote address itself
+func (srv *Server) clientIP(remote, realIP string) string {
+	if realIP != "" && ip.InSubnets(remote, srv.trustedProxies) {
+		return realIP
+	}
+	return remote
+}
+
+// grpcPeerName returns common name of verified certificate of client,
+// empty string if connection isn't secured by mutual TLS
+func grpcPeerName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	return tlsconfig.PeerName(&info.State)
+}
+
+// remoteHost returns host of address host:port
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// detached returns context without deadline and cancellation
+// which keeps tenant and client of ctx
+func detached(ctx context.Context) context.Context {
+	res := tenant.WithTenant(context.Background(), tenant.FromContext(ctx))
+	return cardinality.WithClient(res, cardinality.ClientFromContext(ctx))
+}
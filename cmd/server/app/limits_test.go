@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+func TestServer_CardinalityLimits(t *testing.T) {
+	limits := cardinality.Limits{MaxSeries: 10, MaxNewSeriesPerMinute: 4, MaxBatchSize: 5}
+	srv := &Server{
+		storage: cardinality.NewStorage(memstorage.NewMemStorage(), limits),
+		limits:  limits,
+	}
+	h := srv.ClientMiddleware(http.HandlerFunc(srv.UpdateBatchJSONHandle))
+	push := func(client string, m []metrics.Metric) int {
+		body, err := json.Marshal(m)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	gauges := func(prefix string, n int) []metrics.Metric {
+		m := make([]metrics.Metric, 0, n)
+		for i := 0; i < n; i++ {
+			v := float64(i)
+			m = append(m, metrics.Metric{ID: fmt.Sprintf("%s_%d", prefix, i), MType: metrics.MetricTypeGauge, Value: &v})
+		}
+		return m
+	}
+
+	assert.Equal(t, http.StatusOK, push("10.0.0.1", gauges("a", 4)))
+	// too many new series of client
+	assert.Equal(t, http.StatusTooManyRequests, push("10.0.0.1", gauges("a", 5)))
+	// too large batch
+	assert.Equal(t, http.StatusTooManyRequests, push("10.0.0.2", gauges("b", 6)))
+	assert.Equal(t, http.StatusOK, push("10.0.0.2", gauges("b", 4)))
+	assert.Equal(t, http.StatusOK, push("10.0.0.3", gauges("c", 2)))
+	// too many series
+	assert.Equal(t, http.StatusTooManyRequests, push("10.0.0.4", gauges("d", 1)))
+
+	client := newBufconnClient(t, srv)
+	in := make([]*pb.Metric, 0)
+	for _, el := range gauges("e", 6) {
+		in = append(in, toProtoMetric(&el))
+	}
+	_, err := client.PushMetrics(context.Background(), &pb.PushMetricsRequest{Metrics: in})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = client.PushMetrics(context.Background(), &pb.PushMetricsRequest{Metrics: in[:1]})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServer_ClientMiddleware(t *testing.T) {
+	srv := newAuthServer(t)
+	proxies, err := ip.ParseSubnets("10.1.0.0/16")
+	require.NoError(t, err)
+	srv.trustedProxies = proxies
+
+	var client string
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = cardinality.ClientFromContext(r.Context())
+	})
+
+	tests := []struct {
+		name   string
+		remote string
+		realIP string
+		token  string
+		want   string
+	}{
+		{name: "direct client", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "header of client is ignored", remote: "10.0.0.1:1234", realIP: "10.0.0.9", want: "10.0.0.1"},
+		{name: "header of trusted proxy", remote: "10.1.0.1:1234", realIP: "10.0.0.9", want: "10.0.0.9"},
+		{name: "token", remote: "10.0.0.1:1234", realIP: "10.0.0.9", token: "agent", want: "agent-1"},
+		{name: "token behind proxy", remote: "10.1.0.1:1234", realIP: "10.0.0.9", token: "agent", want: "agent-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := srv.ClientMiddleware(record)
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			r.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.token != "" {
+				h = srv.ClientMiddleware(srv.AuthMiddleware(record))
+				r.Header.Set(auth.Header, auth.Bearer(tt.token))
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tt.want, client)
+		})
+	}
+
+	// metadata of gRPC call is trusted only from proxy too
+	grpcCtx := func(remote string) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Real-IP", "10.0.0.9"))
+		return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(remote), Port: 1234}})
+	}
+	assert.Equal(t, "10.0.0.1", srv.grpcClient(grpcCtx("10.0.0.1")))
+	assert.Equal(t, "10.0.0.9", srv.grpcClient(grpcCtx("10.1.0.1")))
+}
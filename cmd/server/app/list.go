@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// errInvalidFilter is returned when filter of metrics can't be applied
+var errInvalidFilter = errors.New("invalid filter")
+
+// MetricsPage is a page of metrics list
+type MetricsPage struct {
+	Metrics []*metrics.Metric `json:"metrics"`
+	// cursor of the next page, empty for the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// SelectMetrics returns up to pageSize metrics selected by filter and cursor of the next page.
+// Limit of filter is ignored
+func (srv *Server) SelectMetrics(ctx context.Context, f storage.Filter, pageSize int) (*MetricsPage, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if err := f.Validate(); err != nil {
+		return nil, errors.Join(errInvalidFilter, err)
+	}
+
+	// one more metric shows whether there is the next page
+	f.Limit = pageSize + 1
+	list, err := srv.storage.ListMetrics(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &MetricsPage{Metrics: list}
+	if len(list) > pageSize {
+		page.Metrics = list[:pageSize]
+		page.NextCursor = encodePageToken(list[pageSize-1])
+	}
+	return page, nil
+}
+
+// encodePageToken makes opaque token with key of the last metric on page
+func encodePageToken(last *metrics.Metric) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(last.MType + "/" + last.ID))
+}
+
+func decodePageToken(token string) (*storage.Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	mtype, id, found := strings.Cut(string(data), "/")
+	if !found {
+		return nil, errors.New("invalid page token")
+	}
+	return &storage.Cursor{MType: mtype, ID: id}, nil
+}
+
+// parseLabelMatcher parses matcher in format label=value or label!=value
+func parseLabelMatcher(s string) (storage.LabelMatcher, error) {
+	name, value, found := strings.Cut(s, "=")
+	if !found || name == "" {
+		return storage.LabelMatcher{}, errors.New("invalid label matcher: " + s)
+	}
+	if strings.HasSuffix(name, "!") {
+		return storage.LabelMatcher{Name: name[:len(name)-1], Value: value, Negative: true}, nil
+	}
+	return storage.LabelMatcher{Name: name, Value: value}, nil
+}
+
+// ListMetricsHandle godoc
+// @Tags getvalue
+// @Summary List metrics
+// @Description Page of metrics selected by type, prefix and regular expression of name and by labels.
+// @Description Next page is requested with cursor returned in previous page.
+// @ID list
+// @Produce json
+// @Param type query string false "Metric type"
+// @Param prefix query string false "Prefix of metric name"
+// @Param regex query string false "Regular expression for metric name"
+// @Param label query string false "Label matcher label=value or label!=value, can be repeated"
+// @Param sort query string false "Sort order: name, type, -name or -type"
+// @Param limit query int false "Page size"
+// @Param cursor query string false "Cursor of page"
+// @Success 200 {object} MetricsPage
+// @Failure 400 {string} string "Invalid query"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/metrics [get]
+func (srv *Server) ListMetricsHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	f := storage.Filter{
+		MType:  query.Get("type"),
+		Prefix: query.Get("prefix"),
+		Regex:  query.Get("regex"),
+	}
+	for _, el := range query["label"] {
+		matcher, err := parseLabelMatcher(el)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.Labels = append(f.Labels, matcher)
+	}
+	sortBy := query.Get("sort")
+	f.Desc = strings.HasPrefix(sortBy, "-")
+	f.SortBy = strings.TrimPrefix(sortBy, "-")
+
+	pageSize := 0
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		pageSize, err = strconv.Atoi(limit)
+		if err != nil || pageSize <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	after, err := decodePageToken(query.Get("cursor"))
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
+	f.After = after
+
+	page, err := srv.SelectMetrics(r.Context(), f, pageSize)
+	if errors.Is(err, errInvalidFilter) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
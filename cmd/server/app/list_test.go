@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ListMetricsHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+
+	get := func(query url.Values) (*http.Response, MetricsPage) {
+		r := httptest.NewRequest(http.MethodGet, "/api/metrics?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+
+		srv.ListMetricsHandle(w, r)
+
+		res := w.Result()
+		defer res.Body.Close()
+		var page MetricsPage
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		}
+		return res, page
+	}
+	names := func(page MetricsPage) []string {
+		res := make([]string, 0, len(page.Metrics))
+		for _, el := range page.Metrics {
+			res = append(res, el.ID)
+		}
+		return res
+	}
+
+	// all metrics page by page
+	all := make([]string, 0)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10, "too many pages")
+		res, page := get(url.Values{"limit": {"3"}, "cursor": {cursor}})
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		all = append(all, names(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"Alloc", "HeapAlloc", "HeapSys", "PollCount"}, all)
+
+	res, page := get(url.Values{"type": {metrics.MetricTypeGauge}, "regex": {"Alloc$"}, "sort": {"-name"}})
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []string{"HeapAlloc", "Alloc"}, names(page))
+	assert.Equal(t, 1024.5, *page.Metrics[0].Value)
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{name: "invalid type", query: url.Values{"type": {"histogram"}}},
+		{name: "invalid regex", query: url.Values{"regex": {"("}}},
+		{name: "invalid sort", query: url.Values{"sort": {"value"}}},
+		{name: "invalid label", query: url.Values{"label": {"host"}}},
+		{name: "invalid limit", query: url.Values{"limit": {"-1"}}},
+		{name: "invalid cursor", query: url.Values{"cursor": {"%%%"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _ := get(tt.query)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		})
+	}
+}
+
+func Test_parseLabelMatcher(t *testing.T) {
+	m, err := parseLabelMatcher("host=a=b")
+	require.NoError(t, err)
+	assert.Equal(t, "host", m.Name)
+	assert.Equal(t, "a=b", m.Value)
+	assert.False(t, m.Negative)
+
+	m, err = parseLabelMatcher("host!=")
+	require.NoError(t, err)
+	assert.Equal(t, "host", m.Name)
+	assert.Empty(t, m.Value)
+	assert.True(t, m.Negative)
+
+	_, err = parseLabelMatcher("=a")
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	otlppb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// content type of OTLP/HTTP requests in binary protobuf encoding
+const otlpContentType = "application/x-protobuf"
+
+// maximum size of body of OTLP request
+const maxOTLPBodySize = 32 << 20
+
+// cumulativeTTL is a period after which state of cumulative series without data points is forgotten,
+// the next data point of such series is treated as counted from zero
+const cumulativeTTL = 24 * time.Hour
+
+// cumulativeState converts cumulative values of OTLP sums and histograms
+// to deltas, because counters in storage are incremented by deltas.
+// Zero value is ready to use.
+type cumulativeState struct {
+	mx     sync.Mutex
+	series map[string]cumulativePoint
+	// time of the last scan of expired series
+	expired time.Time
+	// current time, time.Now if nil
+	now func() time.Time
+}
+
+type cumulativePoint struct {
+	start uint64
+	value int64
+	// time of the last data point
+	updated time.Time
+}
+
+// delta returns increment of cumulative series since previous data point.
+// New series or series with another start time (restarted producer)
+// or decreased value are treated as counted from zero.
+func (s *cumulativeState) delta(id string, start uint64, value int64) int64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.series == nil {
+		s.series = make(map[string]cumulativePoint)
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	s.expire(now)
+
+	prev, exists := s.series[id]
+	s.series[id] = cumulativePoint{start: start, value: value, updated: now}
+	if !exists || prev.start != start || value < prev.value {
+		return value
+	}
+	return value - prev.value
+}
+
+// expire forgets series without data points during cumulativeTTL,
+// series are scanned at most once a minute
+func (s *cumulativeState) expire(now time.Time) {
+	if now.Sub(s.expired) < time.Minute {
+		return
+	}
+	s.expired = now
+	for id, p := range s.series {
+		if now.Sub(p.updated) > cumulativeTTL {
+			delete(s.series, id)
+		}
+	}
+}
+
+// OTLPMetricsHandle godoc
+// @Tags update
+// @Summary OpenTelemetry metrics receiver
+// @Description Receive metrics in OTLP/HTTP format (binary protobuf)
+// @ID otlpMetrics
+// @Accept  application/x-protobuf
+// @Produce application/x-protobuf
+// @Success 200 {string} string "OK"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 413 {string} string "Request body is too large"
+// @Failure 415 {string} string "Unsupported content type"
+// @Failure 500 {string} string "Internal error"
+// @Router /v1/metrics [post]
+func (srv *Server) OTLPMetricsHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), otlpContentType) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOTLPBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), readStatus(err))
+		return
+	}
+
+	var request collectorpb.ExportMetricsServiceRequest
+	if err := proto.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, rejected := srv.convertOTLP(&request)
+	if len(m) > 0 {
+		if err := srv.AddMetricsBatch(r.Context(), m); err != nil {
+			http.Error(w, err.Error(), updateStatus(err))
+			return
+		}
+	}
+
+	var response collectorpb.ExportMetricsServiceResponse
+	if rejected > 0 {
+		response.PartialSuccess = &collectorpb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       "only gauge, sum and histogram metrics are supported",
+		}
+	}
+	body, err := proto.Marshal(&response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", otlpContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// convertOTLP maps OTLP metrics to metrics of storage:
+//   - Sum with monotonic flag - counter;
+//   - Sum without monotonic flag and Gauge - gauge;
+//   - Histogram - counters <name>_count and <name>_bucket{le="..."}
+//     and gauge <name>_sum.
+//
+// Attributes of data point and service.name of resource become labels of series.
+// Returns count of rejected data points of unsupported types.
+func (srv *Server) convertOTLP(request *collectorpb.ExportMetricsServiceRequest) ([]metrics.Metric, int64) {
+	result := make([]metrics.Metric, 0)
+	var rejected int64
+
+	for _, rm := range request.GetResourceMetrics() {
+		resourceLabels := make(map[string]string)
+		for _, attr := range rm.GetResource().GetAttributes() {
+			if attr.GetKey() == "service.name" {
+				resourceLabels["service_name"] = attributeValue(attr.GetValue())
+			}
+		}
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, om := range sm.GetMetrics() {
+				name := metrics.SanitizeName(om.GetName())
+
+				switch data := om.GetData().(type) {
+				case *otlppb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						value := numberValue(dp)
+						id := metrics.SeriesName(name, pointLabels(resourceLabels, dp.GetAttributes()))
+						result = append(result, *metrics.NewCommonMetric(id, metrics.MetricTypeGauge, nil, &value))
+					}
+				case *otlppb.Metric_Sum:
+					cumulative := data.Sum.GetAggregationTemporality() == otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+					for _, dp := range data.Sum.GetDataPoints() {
+						id := metrics.SeriesName(name, pointLabels(resourceLabels, dp.GetAttributes()))
+						if !data.Sum.GetIsMonotonic() {
+							value := numberValue(dp)
+							result = append(result, *metrics.NewCommonMetric(id, metrics.MetricTypeGauge, nil, &value))
+							continue
+						}
+						delta := int64(math.Round(numberValue(dp)))
+						if cumulative {
+							delta = srv.otlpState.delta(id, dp.GetStartTimeUnixNano(), delta)
+						}
+						result = append(result, *metrics.NewCommonMetric(id, metrics.MetricTypeCounter, &delta, nil))
+					}
+				case *otlppb.Metric_Histogram:
+					cumulative := data.Histogram.GetAggregationTemporality() == otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+					for _, dp := range data.Histogram.GetDataPoints() {
+						labels := pointLabels(resourceLabels, dp.GetAttributes())
+						result = append(result, srv.convertHistogram(name, labels, dp, cumulative)...)
+					}
+				case *otlppb.Metric_ExponentialHistogram:
+					rejected += int64(len(data.ExponentialHistogram.GetDataPoints()))
+				case *otlppb.Metric_Summary:
+					rejected += int64(len(data.Summary.GetDataPoints()))
+				}
+			}
+		}
+	}
+
+	return result, rejected
+}
+
+func (srv *Server) convertHistogram(name string, labels map[string]string, dp *otlppb.HistogramDataPoint, cumulative bool) []metrics.Metric {
+	result := make([]metrics.Metric, 0, len(dp.GetBucketCounts())+2)
+	start := dp.GetStartTimeUnixNano()
+
+	counter := func(id string, value uint64) {
+		delta := int64(value)
+		if cumulative {
+			delta = srv.otlpState.delta(id, start, delta)
+		}
+		result = append(result, *metrics.NewCommonMetric(id, metrics.MetricTypeCounter, &delta, nil))
+	}
+
+	counter(metrics.SeriesName(name+"_count", labels), dp.GetCount())
+
+	sum := dp.GetSum()
+	result = append(result, *metrics.NewCommonMetric(metrics.SeriesName(name+"_sum", labels), metrics.MetricTypeGauge, nil, &sum))
+
+	// OTLP buckets are not cumulative, but Prometheus-like buckets "less or equal" are
+	bounds := dp.GetExplicitBounds()
+	var total uint64
+	for i, count := range dp.GetBucketCounts() {
+		total += count
+		le := "+Inf"
+		if i < len(bounds) {
+			le = strconv.FormatFloat(bounds[i], 'g', -1, 64)
+		}
+		bucketLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+		bucketLabels["le"] = le
+		counter(metrics.SeriesName(name+"_bucket", bucketLabels), total)
+	}
+
+	return result
+}
+
+func pointLabels(resourceLabels map[string]string, attributes []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(resourceLabels)+len(attributes))
+	for k, v := range resourceLabels {
+		labels[k] = v
+	}
+	for _, attr := range attributes {
+		labels[metrics.SanitizeName(attr.GetKey())] = attributeValue(attr.GetValue())
+	}
+	return labels
+}
+
+func numberValue(dp *otlppb.NumberDataPoint) float64 {
+	switch v := dp.GetValue().(type) {
+	case *otlppb.NumberDataPoint_AsInt:
+		return float64(v.AsInt)
+	case *otlppb.NumberDataPoint_AsDouble:
+		return v.AsDouble
+	}
+	return 0
+}
+
+func attributeValue(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'g', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", v.GetValue())
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	otlppb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func newOTLPRequest(requests int64, heap float64) *collectorpb.ExportMetricsServiceRequest {
+	attrs := []*commonpb.KeyValue{{
+		Key:   "http.method",
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "GET"}},
+	}}
+	return &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*otlppb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+				Key:   "service.name",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "billing"}},
+			}}},
+			ScopeMetrics: []*otlppb.ScopeMetrics{{
+				Metrics: []*otlppb.Metric{
+					{
+						Name: "http.requests",
+						Data: &otlppb.Metric_Sum{Sum: &otlppb.Sum{
+							IsMonotonic:            true,
+							AggregationTemporality: otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+							DataPoints: []*otlppb.NumberDataPoint{{
+								Attributes:        attrs,
+								StartTimeUnixNano: 1,
+								Value:             &otlppb.NumberDataPoint_AsInt{AsInt: requests},
+							}},
+						}},
+					},
+					{
+						Name: "heap",
+						Data: &otlppb.Metric_Gauge{Gauge: &otlppb.Gauge{
+							DataPoints: []*otlppb.NumberDataPoint{{
+								Value: &otlppb.NumberDataPoint_AsDouble{AsDouble: heap},
+							}},
+						}},
+					},
+					{
+						Name: "latency",
+						Data: &otlppb.Metric_Histogram{Histogram: &otlppb.Histogram{
+							AggregationTemporality: otlppb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+							DataPoints: []*otlppb.HistogramDataPoint{{
+								Count:          3,
+								Sum:            proto.Float64(0.7),
+								ExplicitBounds: []float64{0.1, 0.5},
+								BucketCounts:   []uint64{1, 1, 1},
+							}},
+						}},
+					},
+					{
+						Name: "sizes",
+						Data: &otlppb.Metric_Summary{Summary: &otlppb.Summary{
+							DataPoints: []*otlppb.SummaryDataPoint{{Count: 1}},
+						}},
+					},
+				},
+			}},
+		}},
+	}
+}
+
+func TestServer_OTLPMetricsHandle(t *testing.T) {
+	memst := memstorage.NewMemStorage()
+	srv := &Server{
+		storage: memst,
+	}
+
+	send := func(request *collectorpb.ExportMetricsServiceRequest) *collectorpb.ExportMetricsServiceResponse {
+		body, err := proto.Marshal(request)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+		r.Header.Set("Content-Type", otlpContentType)
+		w := httptest.NewRecorder()
+
+		srv.OTLPMetricsHandle(w, r)
+
+		res := w.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var response collectorpb.ExportMetricsServiceResponse
+		require.NoError(t, proto.Unmarshal(w.Body.Bytes(), &response))
+		return &response
+	}
+
+	response := send(newOTLPRequest(5, 10.5))
+	assert.Equal(t, int64(1), response.GetPartialSuccess().GetRejectedDataPoints())
+	// cumulative sum is converted to deltas, so counter keeps the last reported total
+	send(newOTLPRequest(8, 20))
+
+	ctx := context.Background()
+	requests, err := srv.GetMetricValue(ctx, "counter", `http_requests{http_method="GET",service_name="billing"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), requests)
+
+	heap, err := srv.GetMetricValue(ctx, "gauge", `heap{service_name="billing"}`)
+	require.NoError(t, err)
+	assert.Equal(t, float64(20), heap)
+
+	// histogram has delta temporality, so its counters are summed
+	count, err := srv.GetMetricValue(ctx, "counter", `latency_count{service_name="billing"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+
+	bucket, err := srv.GetMetricValue(ctx, "counter", `latency_bucket{le="0.5",service_name="billing"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), bucket)
+
+	inf, err := srv.GetMetricValue(ctx, "counter", `latency_bucket{le="+Inf",service_name="billing"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), inf)
+}
+
+func TestServer_OTLPMetricsHandle_ContentType(t *testing.T) {
+	memst := memstorage.NewMemStorage()
+	srv := &Server{
+		storage: memst,
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader([]byte("{}")))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	srv.OTLPMetricsHandle(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+}
+
+func TestServer_OTLPMetricsHandle_TooLarge(t *testing.T) {
+	srv := &Server{
+		storage: memstorage.NewMemStorage(),
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(make([]byte, maxOTLPBodySize+1)))
+	r.Header.Set("Content-Type", otlpContentType)
+	w := httptest.NewRecorder()
+
+	srv.OTLPMetricsHandle(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
+
+func Test_cumulativeState_expire(t *testing.T) {
+	now := time.Now()
+	s := cumulativeState{now: func() time.Time { return now }}
+	assert.Equal(t, int64(10), s.delta("idle", 1, 10))
+	assert.Equal(t, int64(10), s.delta("active", 1, 10))
+
+	now = now.Add(cumulativeTTL / 2)
+	assert.Equal(t, int64(5), s.delta("active", 1, 15))
+
+	// idle series is forgotten, active one keeps its state
+	now = now.Add(cumulativeTTL/2 + time.Minute)
+	assert.Equal(t, int64(5), s.delta("active", 1, 20))
+	assert.NotContains(t, s.series, "idle")
+	assert.Equal(t, int64(12), s.delta("idle", 1, 12))
+}
+
+func Test_cumulativeState_delta(t *testing.T) {
+	var s cumulativeState
+	tests := []struct {
+		name  string
+		start uint64
+		value int64
+		want  int64
+	}{
+		{name: "first point", start: 1, value: 10, want: 10},
+		{name: "increase", start: 1, value: 15, want: 5},
+		{name: "value decreased", start: 1, value: 3, want: 3},
+		{name: "producer restarted", start: 2, value: 7, want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.delta("series", tt.start, tt.value))
+		})
+	}
+}
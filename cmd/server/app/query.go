@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/query"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// querySource provides metrics of server to queries
+type querySource struct {
+	srv *Server
+	// id of tenant of query, empty for queries over all tenants
+	tenant string
+}
+
+func (s querySource) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	// rules are evaluated outside of requests, so tenant is set here
+	return s.srv.storage.ListMetrics(tenant.WithTenant(ctx, s.tenant), f)
+}
+
+func (s querySource) Samples(ctx context.Context, mtype, id string, from time.Time) ([]history.Sample, error) {
+	return s.srv.samples(ctx, mtype, tenant.Scope(s.tenant, id), from)
+}
+
+func (s querySource) AnomalyScore(ctx context.Context, mtype, id string) (float64, bool, error) {
+	if mtype != metrics.MetricTypeGauge {
+		return 0, false, nil
+	}
+	return s.srv.anomalyScore(ctx, tenant.Scope(s.tenant, id))
+}
+
+// QueryResponse is a result of query
+type QueryResponse struct {
+	// "scalar" or "vector"
+	Type   string        `json:"type"`
+	Result []QuerySeries `json:"result"`
+}
+
+// QuerySeries is a value of one series in result of query
+type QuerySeries struct {
+	// name of metric, empty for computed series
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// value as string, so NaN and infinity are representable
+	Value string `json:"value"`
+}
+
+// Query evaluates query over current metrics
+func (srv *Server) Query(ctx context.Context, q string) (*QueryResponse, error) {
+	res, err := query.Eval(ctx, querySource{srv: srv, tenant: tenant.FromContext(ctx)}, q, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &QueryResponse{Type: "vector", Result: make([]QuerySeries, 0, len(res.Series))}
+	if res.Scalar {
+		response.Type = "scalar"
+	}
+	for _, s := range res.Series {
+		response.Result = append(response.Result, QuerySeries{
+			Name:   s.Name,
+			Labels: s.Labels,
+			Value:  strconv.FormatFloat(s.Value, 'f', -1, 64),
+		})
+	}
+	return response, nil
+}
+
+// QueryHandle godoc
+// @Tags getvalue
+// @Summary Evaluate query
+// @Description Evaluates query over current values of metrics, e.g. sum(CPUutilization*) or HeapAlloc / HeapSys.
+// @Description Query consists of selectors by name pattern and labels, numbers, arithmetic operators
+// @Description and functions sum, avg, min, max, rate, increase and delta.
+// @ID query
+// @Produce json
+// @Param query query string true "Query"
+// @Success 200 {object} QueryResponse
+// @Failure 400 {string} string "Invalid query"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/query [get]
+func (srv *Server) QueryHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query().Get("query")
+	if q == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+
+	response, err := srv.Query(r.Context(), q)
+	var queryErr *query.Error
+	if errors.As(err, &queryErr) {
+		http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_QueryHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   QueryResponse
+	}{
+		{
+			name:   "ratio",
+			query:  "HeapAlloc / HeapSys * 100",
+			status: http.StatusOK,
+			want:   QueryResponse{Type: "vector", Result: []QuerySeries{{Labels: map[string]string{}, Value: "25.01220703125"}}},
+		},
+		{
+			name:   "selector",
+			query:  "Heap*",
+			status: http.StatusOK,
+			want: QueryResponse{Type: "vector", Result: []QuerySeries{
+				{Name: "HeapAlloc", Labels: map[string]string{}, Value: "1024.5"},
+				{Name: "HeapSys", Labels: map[string]string{}, Value: "4096"},
+			}},
+		},
+		{
+			name:   "scalar",
+			query:  "1 / 0",
+			status: http.StatusOK,
+			want:   QueryResponse{Type: "scalar", Result: []QuerySeries{{Labels: map[string]string{}, Value: "+Inf"}}},
+		},
+		{name: "invalid query", query: "sum(", status: http.StatusBadRequest},
+		{name: "missing query", query: "", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/query?"+url.Values{"query": {tt.query}}.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			srv.QueryHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got QueryResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			// labels are omitted in JSON when empty
+			for i := range got.Result {
+				if got.Result[i].Labels == nil {
+					got.Result[i].Labels = map[string]string{}
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
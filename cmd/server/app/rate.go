@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// window of counter rate if it isn't set in request
+const defaultRateWindow = 5 * time.Minute
+
+var errCounterNotFound = errors.New("counter not found")
+
+// CounterRate is a growth of counter over time window
+type CounterRate struct {
+	ID     string `json:"id"`
+	Window string `json:"window"`
+	// growth of counter, resets of counter are taken into account
+	Increase float64 `json:"increase"`
+	// per-second growth of counter
+	Rate float64 `json:"rate"`
+	// count of samples in window, rate can't be computed with less than 2 samples
+	Samples int `json:"samples"`
+}
+
+// CounterRate computes increase and rate of counter id over last window
+func (srv *Server) CounterRate(ctx context.Context, id string, window time.Duration) (*CounterRate, error) {
+	found, err := srv.storage.ListMetrics(ctx, storage.Filter{
+		MType: metrics.MetricTypeCounter,
+		Regex: "^" + regexp.QuoteMeta(id) + "$",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errCounterNotFound
+	}
+
+	samples, err := srv.samples(ctx, metrics.MetricTypeCounter, scopedID(ctx, id), time.Now().Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	rate, _ := history.Rate(samples)
+	return &CounterRate{
+		ID:       id,
+		Window:   window.String(),
+		Increase: history.Increase(samples),
+		Rate:     rate,
+		Samples:  len(samples),
+	}, nil
+}
+
+// CounterRateHandle godoc
+// @Tags getvalue
+// @Summary Rate of counter
+// @Description Increase and per-second rate of counter over time window.
+// @Description Decrease of counter value is considered as its reset.
+// @ID rate
+// @Produce json
+// @Param id query string true "Counter name"
+// @Param window query string false "Time window, 5m by default"
+// @Success 200 {object} CounterRate
+// @Failure 400 {string} string "Invalid query"
+// @Failure 404 {string} string "Counter not found"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/rate [get]
+func (srv *Server) CounterRateHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		http.Error(w, "Missing name of counter", http.StatusBadRequest)
+		return
+	}
+	window := defaultRateWindow
+	if v := query.Get("window"); v != "" {
+		var err error
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			http.Error(w, "Invalid window", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rate, err := srv.CounterRate(r.Context(), id, window)
+	if errors.Is(err, errCounterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(rate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
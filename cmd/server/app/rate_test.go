@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	pb "github.com/kvvPro/metric-collector/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newRateServer returns server with counter PollCount which was reset 2 minutes ago
+func newRateServer(t *testing.T) *Server {
+	srv := newTestServer(t, testMetrics())
+	srv.history = history.New(100)
+	now := time.Now()
+	for i, v := range []float64{100, 130, 160, 10, 40} {
+		srv.history.Add(metrics.MetricTypeCounter, "PollCount", now.Add(time.Duration(i-4)*time.Minute), v)
+	}
+	return srv
+}
+
+func TestServer_CounterRateHandle(t *testing.T) {
+	srv := newRateServer(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   CounterRate
+	}{
+		{
+			name:   "default window",
+			target: "/api/rate?id=PollCount",
+			status: http.StatusOK,
+			want:   CounterRate{ID: "PollCount", Window: "5m0s", Increase: 100, Rate: 100.0 / 240, Samples: 5},
+		},
+		{
+			name:   "window after reset",
+			target: "/api/rate?id=PollCount&window=90s",
+			status: http.StatusOK,
+			want:   CounterRate{ID: "PollCount", Window: "1m30s", Increase: 30, Rate: 0.5, Samples: 2},
+		},
+		{name: "gauge", target: "/api/rate?id=HeapAlloc", status: http.StatusNotFound},
+		{name: "invalid window", target: "/api/rate?id=PollCount&window=-1m", status: http.StatusBadRequest},
+		{name: "missing id", target: "/api/rate", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			w := httptest.NewRecorder()
+
+			srv.CounterRateHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.status, res.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got CounterRate
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tt.want.ID, got.ID)
+			assert.Equal(t, tt.want.Window, got.Window)
+			assert.Equal(t, tt.want.Increase, got.Increase)
+			assert.InDelta(t, tt.want.Rate, got.Rate, 1e-6)
+			assert.Equal(t, tt.want.Samples, got.Samples)
+		})
+	}
+}
+
+func TestServer_GetCounterRate(t *testing.T) {
+	client := newBufconnClient(t, newRateServer(t))
+	ctx := context.Background()
+
+	resp, err := client.GetCounterRate(ctx, &pb.GetCounterRateRequest{ID: "PollCount", WindowSeconds: 600})
+	require.NoError(t, err)
+	assert.Equal(t, float64(100), resp.Increase)
+	assert.InDelta(t, 100.0/240, resp.Rate, 1e-6)
+	assert.Equal(t, int32(5), resp.Samples)
+
+	_, err = client.GetCounterRate(ctx, &pb.GetCounterRateRequest{ID: "Unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetCounterRate(ctx, &pb.GetCounterRateRequest{ID: "PollCount", WindowSeconds: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// RetryAfterHeader carries count of seconds after which rate limited request can be repeated,
+// the same key is used in gRPC metadata
+const RetryAfterHeader = "Retry-After"
+
+// pushStreamMethod is limited per received batch, so long-lived stream can't bypass limit
+var pushStreamMethod = "/" + pb.MetricServer_ServiceDesc.ServiceName + "/PushMetricsStream"
+
+// RateLimitMiddleware rejects requests of clients which exceeded rate limit of route
+func (srv *Server) RateLimitMiddleware(h http.Handler) http.Handler {
+	rateLimitFunc := func(w http.ResponseWriter, r *http.Request) {
+		if srv.limiter != nil {
+			ok, wait := srv.limiter.Allow(r.URL.Path, cardinality.ClientFromContext(r.Context()))
+			if !ok {
+				w.Header().Set(RetryAfterHeader, retryAfter(wait))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(rateLimitFunc)
+}
+
+func (srv *Server) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := srv.checkRateLimit(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (srv *Server) rateLimitStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	// batches of push stream are limited by PushMetricsStream, other streams are limited on opening
+	if info.FullMethod != pushStreamMethod {
+		if err := srv.checkRateLimit(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+	}
+	return handler(srvIface, ss)
+}
+
+// allowBatch takes token of client for batch of push stream,
+// returns delay before retry if batch is rejected
+func (srv *Server) allowBatch(ctx context.Context) (bool, time.Duration) {
+	if srv.limiter == nil {
+		return true, 0
+	}
+	return srv.limiter.Allow(pushStreamMethod, cardinality.ClientFromContext(ctx))
+}
+
+// checkRateLimit takes token of client of gRPC call, Retry-After is sent in header of rejected call
+func (srv *Server) checkRateLimit(ctx context.Context, method string) error {
+	if srv.limiter == nil {
+		return nil
+	}
+	ok, wait := srv.limiter.Allow(method, cardinality.ClientFromContext(ctx))
+	if ok {
+		return nil
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, retryAfter(wait)))
+	return status.Error(codes.ResourceExhausted, "too many requests")
+}
+
+// retryAfter returns value of Retry-After in whole seconds
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/ratelimit"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+func TestServer_RateLimitMiddleware(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	rules, err := ratelimit.ParseRules("/ping=0.5:2")
+	require.NoError(t, err)
+	srv.limiter = ratelimit.NewLimiter(rules)
+	h := srv.ClientMiddleware(srv.RateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	request := func(path, client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = client + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, request("/ping", "10.0.0.1").Code)
+	}
+	w := request("/ping", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get(RetryAfterHeader))
+
+	// other clients and routes aren't limited
+	assert.Equal(t, http.StatusOK, request("/ping", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusOK, request("/value/", "10.0.0.1").Code)
+}
+
+func TestServer_rateLimitInterceptor(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	srv.limiter = ratelimit.NewLimiter([]ratelimit.Rule{
+		{Route: "/" + pb.MetricServer_ServiceDesc.ServiceName + "/GetMetrics", Rate: 1, Burst: 1},
+	})
+	client := newBufconnClient(t, srv)
+
+	_, err := client.GetMetrics(context.Background(), &pb.GetMetricsRequest{})
+	require.NoError(t, err)
+	var header metadata.MD
+	_, err = client.GetMetrics(context.Background(), &pb.GetMetricsRequest{}, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, header.Get(RetryAfterHeader))
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	assert.NoError(t, err)
+}
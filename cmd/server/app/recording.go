@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/recording"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// newRecorders creates recorder of rules for each tenant, so results are stored
+// among metrics of tenant. The only recorder has empty key if multi-tenancy is disabled
+func (srv *Server) newRecorders(rules []recording.Rule) map[string]*recording.Recorder {
+	recorders := make(map[string]*recording.Recorder)
+	for _, id := range srv.tenantIDs() {
+		recorders[id] = recording.NewRecorder(rules, querySource{srv: srv, tenant: id})
+	}
+	return recorders
+}
+
+// startRecording runs evaluation of recording rules until ctx is done,
+// results are stored as usual gauges
+func (srv *Server) startRecording(ctx context.Context) {
+	if srv.recorders == nil {
+		return
+	}
+	interval := time.Duration(srv.RecordingInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	for id, recorder := range srv.recorders {
+		id, recorder := id, recorder
+		store := func(ctx context.Context, m []metrics.Metric) error {
+			// each node evaluates rules, results are stored once by leader of cluster
+			if !srv.isLeader() {
+				return nil
+			}
+			return srv.AddMetricsBatch(tenant.WithTenant(ctx, id), m)
+		}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			recorder.Run(ctx, interval, store, func(err error) {
+				Sugar.Infoln("Evaluation of recording rules failed: ", id, " ", err.Error())
+			})
+			Sugar.Infoln("остановка вычисления правил записи ", id)
+		}()
+	}
+}
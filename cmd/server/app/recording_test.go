@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/recording"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Recording(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	rule := recording.Rule{Record: "HeapUtilization", Query: "HeapAlloc / HeapSys * 100"}
+	require.NoError(t, rule.Validate())
+	srv.recorders = srv.newRecorders([]recording.Rule{rule})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.wg = &sync.WaitGroup{}
+	srv.startRecording(ctx)
+	defer func() {
+		cancel()
+		srv.wg.Wait()
+	}()
+
+	var value float64
+	require.Eventually(t, func() bool {
+		m, err := srv.GetRequestedValues(ctx, []metrics.Metric{{ID: "HeapUtilization", MType: metrics.MetricTypeGauge}})
+		if err != nil || len(m) != 1 || m[0].Value == nil {
+			return false
+		}
+		value = *m[0].Value
+		return value != 0
+	}, time.Second, 10*time.Millisecond)
+	assert.InDelta(t, 25.012, value, 1e-3)
+
+	// derived metric is shown like any other
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "text/plain")
+	w := httptest.NewRecorder()
+	srv.AllMetricsHandle(w, r)
+	assert.Contains(t, w.Body.String(), "gauge HeapUtilization 25.01")
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/proto/prompb"
+	"google.golang.org/protobuf/proto"
+)
+
+// label with name of metric in Prometheus series
+const prometheusNameLabel = "__name__"
+
+// maximum size of compressed and decompressed body of remote-write request
+const (
+	maxRemoteWriteBodySize    = 32 << 20
+	maxRemoteWriteDecodedSize = 128 << 20
+)
+
+// staleNaN is a special value which Prometheus uses to mark series as stale
+const staleNaN uint64 = 0x7ff0000000000002
+
+// RemoteWriteHandle godoc
+// @Tags update
+// @Summary Prometheus remote-write receiver
+// @Description Receive samples in Prometheus remote-write format (snappy-compressed protobuf WriteRequest).
+// @Description All samples are stored as gauges, only the latest sample of each series is kept.
+// @ID remoteWrite
+// @Accept  application/x-protobuf
+// @Success 204 {string} string "No content"
+// @Failure 400 {string} string "Invalid request body"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 413 {string} string "Request body is too large"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/v1/write [post]
+func (srv *Server) RemoteWriteHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRemoteWriteBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), readStatus(err))
+		return
+	}
+
+	// length of decoded body is declared in header, it's checked before allocation
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if size > maxRemoteWriteDecodedSize {
+		http.Error(w, "Decompressed body is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var request prompb.WriteRequest
+	if err := proto.Unmarshal(data, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m, err := convertRemoteWrite(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(m) > 0 {
+		if err := srv.AddMetricsBatch(r.Context(), m); err != nil {
+			http.Error(w, err.Error(), updateStatus(err))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// convertRemoteWrite maps Prometheus series to gauges.
+// Identifier of gauge is built from label __name__ and other labels of series.
+// If request contains several samples of one series, the latest one is used,
+// stale markers are skipped.
+func convertRemoteWrite(request *prompb.WriteRequest) ([]metrics.Metric, error) {
+	result := make([]metrics.Metric, 0, len(request.GetTimeseries()))
+
+	for _, ts := range request.GetTimeseries() {
+		var name string
+		labels := make(map[string]string, len(ts.GetLabels()))
+		for _, l := range ts.GetLabels() {
+			if l.GetName() == prometheusNameLabel {
+				name = l.GetValue()
+				continue
+			}
+			labels[l.GetName()] = l.GetValue()
+		}
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("missing name of metric")
+		}
+
+		var latest *prompb.Sample
+		for _, s := range ts.GetSamples() {
+			if math.Float64bits(s.GetValue()) == staleNaN {
+				continue
+			}
+			if latest == nil || s.GetTimestamp() >= latest.GetTimestamp() {
+				latest = s
+			}
+		}
+		if latest == nil {
+			continue
+		}
+
+		value := latest.GetValue()
+		result = append(result, *metrics.NewCommonMetric(metrics.SeriesName(name, labels), metrics.MetricTypeGauge, nil, &value))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/kvvPro/metric-collector/proto/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestServer_RemoteWriteHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *prompb.WriteRequest
+		code    int
+		want    map[string]float64
+	}{
+		{
+			name: "latest sample of series",
+			request: &prompb.WriteRequest{
+				Timeseries: []*prompb.TimeSeries{
+					{
+						Labels: []*prompb.Label{
+							{Name: "__name__", Value: "node_load1"},
+							{Name: "instance", Value: "db1"},
+						},
+						Samples: []*prompb.Sample{
+							{Value: 2.5, Timestamp: 2000},
+							{Value: 1.5, Timestamp: 1000},
+						},
+					},
+					{
+						Labels: []*prompb.Label{
+							{Name: "__name__", Value: "up"},
+						},
+						Samples: []*prompb.Sample{
+							{Value: 1, Timestamp: 1000},
+							{Value: math.Float64frombits(staleNaN), Timestamp: 2000},
+						},
+					},
+				},
+			},
+			code: http.StatusNoContent,
+			want: map[string]float64{
+				`node_load1{instance="db1"}`: 2.5,
+				"up":                         1,
+			},
+		},
+		{
+			name: "missing name",
+			request: &prompb.WriteRequest{
+				Timeseries: []*prompb.TimeSeries{
+					{
+						Labels:  []*prompb.Label{{Name: "job", Value: "node"}},
+						Samples: []*prompb.Sample{{Value: 1}},
+					},
+				},
+			},
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memst := memstorage.NewMemStorage()
+			srv := &Server{
+				storage: memst,
+			}
+
+			data, err := proto.Marshal(tt.request)
+			require.NoError(t, err)
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, data)))
+			r.Header.Set("Content-Encoding", "snappy")
+			r.Header.Set("Content-Type", "application/x-protobuf")
+			w := httptest.NewRecorder()
+
+			srv.RemoteWriteHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.code, res.StatusCode)
+
+			for id, want := range tt.want {
+				got, err := srv.GetMetricValue(context.Background(), "gauge", id)
+				require.NoError(t, err)
+				assert.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+func TestServer_RemoteWriteHandle_TooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{
+			name: "compressed body",
+			body: make([]byte, maxRemoteWriteBodySize+1),
+		},
+		{
+			// a few bytes which declare decoded length of 2 GB
+			name: "declared decoded length",
+			body: binary.AppendUvarint(nil, 1<<31),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{
+				storage: memstorage.NewMemStorage(),
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", "snappy")
+			w := httptest.NewRecorder()
+
+			srv.RemoteWriteHandle(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/cardinality"
+	"github.com/kvvPro/metric-collector/internal/cluster"
+	"github.com/kvvPro/metric-collector/internal/replication"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// settings of replication stream of leader
+const (
+	// interval of heartbeats, follower's lease must be longer
+	replicationHeartbeat = time.Second
+	// interval of resending of metrics changed since previous resync, it fixes
+	// values of metrics published by concurrent updates out of order
+	replicationResync = time.Minute
+	// max count of metrics in one event, full state of leader is sent in several events
+	replicationBatch = 500
+	// delay before reconnection of follower to leader
+	replicationReconnectDelay = time.Second
+)
+
+// errReadOnly is returned on writes to follower
+var errReadOnly = errors.New("server is a read-only replica")
+
+// PromoteResult is a result of promotion of follower
+type PromoteResult struct {
+	// false if server wasn't follower
+	Promoted bool `json:"promoted"`
+}
+
+// newFollower creates follower of leader set in settings, st is a storage of replica
+func newFollower(settings *config.ServerFlags, st *memstorage.MemStorage) (*replication.Follower, error) {
+	// certificate of server can't authenticate client, so follower has its own settings,
+	// leader is expected to serve TLS as this server does if they aren't set
+	tlsConfig, err := tlsconfig.Client(settings.ReplicationTLSCA, settings.ReplicationTLSCert, settings.ReplicationTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil && settings.TLSCert != "" {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return replication.NewFollower(replication.Config{
+		Leader:         settings.ReplicaOf,
+		Self:           settings.Address,
+		Lease:          time.Duration(settings.ReplicationLease) * time.Second,
+		ReconnectDelay: replicationReconnectDelay,
+		Token:          settings.ReplicationToken,
+		TLS:            tlsConfig,
+	}, st)
+}
+
+// startReplication replicates leader until ctx is done or follower is promoted
+func (srv *Server) startReplication(ctx context.Context) {
+	if srv.follower == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	srv.stopFollowing = cancel
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		err := srv.follower.Run(ctx, func(err error) {
+			Sugar.Infoln("Replication failed: ", err.Error())
+		})
+		if errors.Is(err, replication.ErrLeaseExpired) {
+			Sugar.Infoln("Lease of leader expired, follower is promoted")
+			srv.Promote()
+		}
+		Sugar.Infoln("остановка репликации")
+	}()
+}
+
+// Promote makes follower the leader: replication stops and writes are accepted.
+// Returns false if server isn't follower
+func (srv *Server) Promote() bool {
+	if !srv.readOnly.CompareAndSwap(true, false) {
+		return false
+	}
+	if srv.stopFollowing != nil {
+		srv.stopFollowing()
+	}
+	return true
+}
+
+// updateStatus returns HTTP status of failed write
+func updateStatus(err error) int {
+	if errors.Is(err, errReadOnly) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, tenant.ErrSeriesLimit) || errors.Is(err, cardinality.ErrLimit) {
+		return http.StatusTooManyRequests
+	}
+	// node of cluster rejected its part of batch
+	var peerErr *cluster.StatusError
+	if errors.As(err, &peerErr) && (peerErr.Code < http.StatusInternalServerError || peerErr.Code == http.StatusServiceUnavailable) {
+		return peerErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+// updateError returns gRPC status of failed write
+func updateError(err error) error {
+	if errors.Is(err, errReadOnly) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if errors.Is(err, tenant.ErrSeriesLimit) || errors.Is(err, cardinality.ErrLimit) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	var peerErr *cluster.StatusError
+	if errors.As(err, &peerErr) {
+		switch peerErr.Code {
+		case http.StatusServiceUnavailable:
+			return status.Error(codes.Unavailable, err.Error())
+		case http.StatusTooManyRequests:
+			return status.Error(codes.ResourceExhausted, err.Error())
+		case http.StatusBadRequest:
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// replicationService implements Replication service
+type replicationService struct {
+	pb.UnimplementedReplicationServer
+	srv *Server
+}
+
+// Subscribe sends full state of this server and then current values of changed metrics
+func (s *replicationService) Subscribe(in *pb.SubscribeRequest, stream pb.Replication_SubscribeServer) error {
+	if s.srv.readOnly.Load() {
+		return status.Error(codes.FailedPrecondition, errReadOnly.Error())
+	}
+	Sugar.Infoln("Follower subscribed: ", in.Follower)
+	err := s.srv.replicate(stream.Context(), stream.Send)
+	if errors.Is(err, errSlowSubscriber) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return err
+}
+
+// Promote stops replication of follower
+func (s *replicationService) Promote(ctx context.Context, in *pb.PromoteRequest) (*pb.PromoteResponse, error) {
+	return &pb.PromoteResponse{Promoted: s.srv.Promote()}, nil
+}
+
+// replicate sends events of replication stream until ctx is done
+func (srv *Server) replicate(ctx context.Context, send func(*pb.ReplicationEvent) error) error {
+	// subscribe before reading of snapshot, so no change is missed
+	events, cancel := srv.bus.subscribe(WatchFilter{})
+	defer cancel()
+
+	if err := srv.sendSnapshot(ctx, send); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	resync := time.NewTicker(replicationResync)
+	defer resync.Stop()
+	// metrics sent since previous resync
+	changed := make(map[metricKey]struct{})
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return errSlowSubscriber
+			}
+			changed[metricKey{e.Metric.MType, e.Metric.ID}] = struct{}{}
+			event := &pb.ReplicationEvent{Metrics: []*pb.Metric{toProtoMetric(&e.Metric)}}
+			// take changes which are already published
+			for more := true; more && len(event.Metrics) < replicationBatch; {
+				select {
+				case e, ok := <-events:
+					if !ok {
+						return errSlowSubscriber
+					}
+					changed[metricKey{e.Metric.MType, e.Metric.ID}] = struct{}{}
+					event.Metrics = append(event.Metrics, toProtoMetric(&e.Metric))
+				default:
+					more = false
+				}
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if err := send(&pb.ReplicationEvent{}); err != nil {
+				return err
+			}
+		case <-resync.C:
+			if err := srv.resendChanged(ctx, changed, send); err != nil {
+				return err
+			}
+			changed = make(map[metricKey]struct{})
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sendSnapshot sends full state of this server in parts of replicationBatch metrics,
+// the last part is marked, so follower replaces its state once
+func (srv *Server) sendSnapshot(ctx context.Context, send func(*pb.ReplicationEvent) error) error {
+	all, err := srv.localMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	for start := 0; ; start += replicationBatch {
+		end := start + replicationBatch
+		if end > len(all) {
+			end = len(all)
+		}
+		event := &pb.ReplicationEvent{
+			Snapshot:    true,
+			SnapshotEnd: end == len(all),
+			Metrics:     make([]*pb.Metric, 0, end-start),
+		}
+		for _, el := range all[start:end] {
+			event.Metrics = append(event.Metrics, toProtoMetric(el))
+		}
+		if err := send(event); err != nil {
+			return err
+		}
+		if event.SnapshotEnd {
+			return nil
+		}
+	}
+}
+
+// resendChanged sends current values of changed metrics
+func (srv *Server) resendChanged(ctx context.Context, changed map[metricKey]struct{}, send func(*pb.ReplicationEvent) error) error {
+	// names of metrics are sent as they are stored
+	ctx = tenant.WithTenant(ctx, "")
+	st := srv.localStorage()
+	event := &pb.ReplicationEvent{}
+	for k := range changed {
+		val, err := st.GetValue(ctx, k.mtype, k.id)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		event.Metrics = append(event.Metrics, toProtoMetric(metricWithValue(k.mtype, k.id, val)))
+		if len(event.Metrics) == replicationBatch {
+			if err := send(event); err != nil {
+				return err
+			}
+			event = &pb.ReplicationEvent{}
+		}
+	}
+	if len(event.Metrics) == 0 {
+		return nil
+	}
+	return send(event)
+}
+
+// startReplicationServer serves Replication service on separate address,
+// in grpc mode the service is also available on the main server
+func (srv *Server) startReplicationServer() {
+	if srv.ReplicationAddress == "" {
+		return
+	}
+	listen, err := net.Listen("tcp", srv.ReplicationAddress)
+	if err != nil {
+		Sugar.Fatal(err)
+	}
+	srv.replicationServer = srv.newGRPCServerWith(func(s *grpc.Server) {
+		pb.RegisterReplicationServer(s, &replicationService{srv: srv})
+	})
+	go func() {
+		if err := srv.replicationServer.Serve(listen); err != nil {
+			Sugar.Fatalw(err.Error(), "event", "start replication server")
+		}
+	}()
+}
+
+func (srv *Server) stopReplicationServer() {
+	if srv.replicationServer == nil {
+		return
+	}
+	// streams of followers are endless, so they are closed at once
+	srv.replicationServer.Stop()
+}
+
+// PromoteHandle godoc
+// @Tags replication
+// @Summary Promote follower
+// @Description Follower stops replication of leader and starts to accept writes
+// @ID promote
+// @Produce json
+// @Success 200 {object} PromoteResult
+// @Failure 405 {string} string "Invalid request type"
+// @Router /api/replication/promote [post]
+func (srv *Server) PromoteHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := json.Marshal(PromoteResult{Promoted: srv.Promote()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+func TestServer_Replication(t *testing.T) {
+	ctx := context.Background()
+	leader := newTestServer(t, testMetrics())
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	leader.grpcServer = leader.newGRPCServer()
+	go leader.grpcServer.Serve(listen)
+	defer leader.grpcServer.Stop()
+
+	memst := memstorage.NewMemStorage()
+	replica := &Server{storage: memst}
+	replica.follower, err = newFollower(&config.ServerFlags{ReplicaOf: listen.Addr().String()}, memst)
+	require.NoError(t, err)
+	replica.readOnly.Store(true)
+	replica.wg = &sync.WaitGroup{}
+	replicaCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	replica.startReplication(replicaCtx)
+
+	// snapshot of leader
+	require.Eventually(t, func() bool {
+		all, err := replica.GetAllMetricsNew(ctx)
+		return err == nil && len(all) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	poll := int64(3)
+	require.NoError(t, leader.AddMetricsBatch(ctx, []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &poll, nil),
+	}))
+	require.Eventually(t, func() bool {
+		got, err := replica.GetMetricValue(ctx, metrics.MetricTypeCounter, "PollCount")
+		return err == nil && got == int64(8)
+	}, time.Second, 10*time.Millisecond)
+
+	// follower is read-only
+	err = replica.AddMetricsBatch(ctx, testMetrics())
+	assert.ErrorIs(t, err, errReadOnly)
+	_, err = replica.PushMetrics(ctx, &pb.PushMetricsRequest{Metrics: []*pb.Metric{toProtoMetric(&testMetrics()[0])}})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	w := httptest.NewRecorder()
+	replica.UpdateHandle(w, httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	err = (&replicationService{srv: replica}).Subscribe(&pb.SubscribeRequest{}, nil)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// promotion stops replication
+	for _, want := range []bool{true, false} {
+		w := httptest.NewRecorder()
+		replica.PromoteHandle(w, httptest.NewRequest(http.MethodPost, "/api/replication/promote", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var got PromoteResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, want, got.Promoted)
+	}
+	replica.wg.Wait()
+
+	require.NoError(t, replica.AddMetricsBatch(ctx, []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &poll, nil),
+	}))
+	got, err := replica.GetMetricValue(ctx, metrics.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), got)
+}
+
+func TestServer_sendSnapshot(t *testing.T) {
+	ctx := context.Background()
+	collect := func(srv *Server) []*pb.ReplicationEvent {
+		var events []*pb.ReplicationEvent
+		require.NoError(t, srv.sendSnapshot(ctx, func(e *pb.ReplicationEvent) error {
+			events = append(events, e)
+			return nil
+		}))
+		return events
+	}
+
+	// empty state is also a snapshot
+	events := collect(newTestServer(t, nil))
+	require.Len(t, events, 1)
+	assert.True(t, events[0].Snapshot)
+	assert.True(t, events[0].SnapshotEnd)
+	assert.Empty(t, events[0].Metrics)
+
+	var m []metrics.Metric
+	for i := 0; i < 2*replicationBatch+10; i++ {
+		value := float64(i)
+		m = append(m, *metrics.NewCommonMetric(fmt.Sprintf("gauge_%d", i), metrics.MetricTypeGauge, nil, &value))
+	}
+	srv := newTestServer(t, m)
+	events = collect(srv)
+	require.Len(t, events, 3)
+	for i, e := range events {
+		assert.True(t, e.Snapshot)
+		assert.Equal(t, i == 2, e.SnapshotEnd)
+	}
+	assert.Len(t, events[0].Metrics, replicationBatch)
+	assert.Len(t, events[2].Metrics, 10)
+
+	// only changed metrics are resent
+	var resent []*pb.ReplicationEvent
+	changed := map[metricKey]struct{}{
+		{metrics.MetricTypeGauge, "gauge_3"}: {},
+		{metrics.MetricTypeGauge, "unknown"}: {},
+	}
+	require.NoError(t, srv.resendChanged(ctx, changed, func(e *pb.ReplicationEvent) error {
+		resent = append(resent, e)
+		return nil
+	}))
+	require.Len(t, resent, 1)
+	assert.False(t, resent[0].Snapshot)
+	require.Len(t, resent[0].Metrics, 1)
+	assert.Equal(t, "gauge_3", resent[0].Metrics[0].ID)
+	assert.Equal(t, 3.0, resent[0].Metrics[0].GetValue())
+}
+
+func TestNewServer_ReplicationInvalid(t *testing.T) {
+	_, err := NewServer(&config.ServerFlags{
+		Address:      "localhost:8080",
+		ReplicaOf:    "localhost:3200",
+		ClusterNodes: "localhost:8080",
+	})
+	assert.Error(t, err)
+}
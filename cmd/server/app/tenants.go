@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// TenantUsage describes tenant for admins
+type TenantUsage struct {
+	ID string `json:"id"`
+	// count of series of tenant
+	Series int `json:"series"`
+	// max count of series, 0 means no limit
+	MaxSeries int `json:"max_series"`
+	// true if requests of tenant must be signed by its key
+	Signed bool `json:"signed"`
+}
+
+// tenantIDs returns ids of all tenants, the only empty id if multi-tenancy is disabled
+func (srv *Server) tenantIDs() []string {
+	if srv.tenants == nil {
+		return []string{""}
+	}
+	list := srv.tenants.List()
+	ids := make([]string, 0, len(list))
+	for _, t := range list {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+// errTenantMismatch is returned when request names tenant other than tenant of its token
+var errTenantMismatch = errors.New("token doesn't belong to tenant")
+
+// tokenTenant returns tenant of request with tenant id from header. Token bound to tenant
+// selects its tenant if header is empty, requests of other tenants are rejected
+func tokenTenant(ctx context.Context, id string) (string, error) {
+	t, ok := auth.FromContext(ctx)
+	if !ok || t.Tenant == "" {
+		return id, nil
+	}
+	if id != "" && id != t.Tenant {
+		return "", fmt.Errorf("%w %s", errTenantMismatch, id)
+	}
+	return t.Tenant, nil
+}
+
+// TenantMiddleware scopes request to tenant from header or token, requests of unknown tenants,
+// requests with invalid sign and requests of tenants other than tenant of token are rejected
+func (srv *Server) TenantMiddleware(h http.Handler) http.Handler {
+	tenantFunc := func(w http.ResponseWriter, r *http.Request) {
+		if srv.tenants == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id, err := tokenTenant(r.Context(), r.Header.Get(tenant.Header))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		t, err := srv.tenants.Authenticate(id, r.Header.Get(tenant.SignHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), t.ID)))
+	}
+	return http.HandlerFunc(tenantFunc)
+}
+
+func (srv *Server) tenantInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := srv.tenantContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (srv *Server) tenantStreamInterceptor(srvIface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := srv.tenantContext(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srvIface, &contextServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// tenantContext scopes context of gRPC call to tenant from metadata or token.
+// Replication copies metrics of all tenants, so its calls aren't scoped
+func (srv *Server) tenantContext(ctx context.Context, method string) (context.Context, error) {
+	if srv.tenants == nil {
+		return ctx, nil
+	}
+	if strings.HasPrefix(method, "/"+pb.Replication_ServiceDesc.ServiceName+"/") {
+		return tenant.WithTenant(ctx, ""), nil
+	}
+	var id, sign string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(tenant.Header); len(v) > 0 {
+			id = v[0]
+		}
+		if v := md.Get(tenant.SignHeader); len(v) > 0 {
+			sign = v[0]
+		}
+	}
+	id, err := tokenTenant(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	t, err := srv.tenants.Authenticate(id, sign)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return tenant.WithTenant(ctx, t.ID), nil
+}
+
+// hashKey returns key of request hashes: key of tenant if it has own key, otherwise key of server
+func (srv *Server) hashKey(ctx context.Context) string {
+	if srv.tenants != nil {
+		if t, ok := srv.tenants.Get(tenant.FromContext(ctx)); ok && t.Key != "" {
+			return t.Key
+		}
+	}
+	return srv.HashKey
+}
+
+// scopedID returns name of metric id of request's tenant as it is kept in storage,
+// history and other state of server
+func scopedID(ctx context.Context, id string) string {
+	return tenant.Scope(tenant.FromContext(ctx), id)
+}
+
+// TenantsHandle godoc
+// @Tags admin
+// @Summary List of tenants
+// @Description Tenants with their usage of series, empty if multi-tenancy is disabled
+// @ID tenants
+// @Produce json
+// @Success 200 {array} TenantUsage
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Internal error"
+// @Router /api/tenants [get]
+func (srv *Server) TenantsHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := []TenantUsage{}
+	if srv.tenants != nil {
+		scoped, ok := srv.storage.(*tenant.Storage)
+		if !ok {
+			http.Error(w, errors.New("storage isn't scoped by tenants").Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, t := range srv.tenants.List() {
+			series, err := scoped.Series(r.Context(), t.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			list = append(list, TenantUsage{ID: t.ID, Series: series, MaxSeries: t.MaxSeries, Signed: t.Key != ""})
+		}
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/recording"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/kvvPro/metric-collector/internal/tenant"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// newTenantServer creates server with tenants team-a (signed by key, limited to 2 series) and team-b
+func newTenantServer(t *testing.T) (*Server, http.Handler) {
+	registry, err := tenant.NewRegistry([]tenant.Tenant{
+		{ID: "team-a", Key: "secret", MaxSeries: 2},
+		{ID: "team-b"},
+	})
+	require.NoError(t, err)
+	srv := &Server{
+		storage: tenant.NewStorage(memstorage.NewMemStorage(), registry),
+		tenants: registry,
+	}
+
+	r := chi.NewMux()
+	r.Use(srv.TenantMiddleware, srv.CheckHashMiddleware)
+	r.Handle("/update/", http.HandlerFunc(srv.UpdateJSONHandle))
+	r.Handle("/value/", http.HandlerFunc(srv.GetValueJSONHandle))
+	r.Handle("/api/metrics", http.HandlerFunc(srv.ListMetricsHandle))
+	r.Handle("/api/tenants", http.HandlerFunc(srv.TenantsHandle))
+	return srv, r
+}
+
+func tenantRequest(method, url, id string, body any) *http.Request {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, url, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	if id != "" {
+		r.Header.Set(tenant.Header, id)
+	}
+	if id == "team-a" {
+		r.Header.Set(tenant.SignHeader, tenant.Sign(id, "secret"))
+	}
+	return r
+}
+
+func TestServer_Tenants_HTTP(t *testing.T) {
+	_, h := newTenantServer(t)
+
+	a, b := 1.5, 2.5
+	for _, tt := range []struct {
+		id    string
+		value *float64
+	}{{"team-a", &a}, {"team-b", &b}} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tenantRequest(http.MethodPost, "/update/", tt.id,
+			metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: tt.value}))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// the same name of different tenants doesn't collide
+	for id, want := range map[string]float64{"team-a": a, "team-b": b} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tenantRequest(http.MethodPost, "/value/", id,
+			metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge}))
+		require.Equal(t, http.StatusOK, w.Code)
+		var got metrics.Metric
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		require.NotNil(t, got.Value)
+		assert.Equal(t, want, *got.Value, id)
+	}
+
+	// default tenant sees neither of them
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodGet, "/api/metrics", "", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var page MetricsPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Empty(t, page.Metrics)
+
+	// unknown tenant and invalid sign are rejected
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodGet, "/api/metrics", "team-c", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	r := tenantRequest(http.MethodGet, "/api/metrics", "team-a", nil)
+	r.Header.Set(tenant.SignHeader, tenant.Sign("team-a", "wrong"))
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// series limit of team-a
+	for name, code := range map[string]int{"Alloc": http.StatusOK, "HeapSys": http.StatusOK} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tenantRequest(http.MethodPost, "/update/", "team-a",
+			metrics.Metric{ID: name, MType: metrics.MetricTypeGauge, Value: &a}))
+		assert.Equal(t, code, w.Code, name)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodPost, "/update/", "team-a",
+		metrics.Metric{ID: "HeapAlloc", MType: metrics.MetricTypeGauge, Value: &a}))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodGet, "/api/tenants", "", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var usage []TenantUsage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &usage))
+	assert.Equal(t, []TenantUsage{
+		{ID: tenant.DefaultID},
+		{ID: "team-a", Series: 2, MaxSeries: 2, Signed: true},
+		{ID: "team-b", Series: 1},
+	}, usage)
+}
+
+func TestServer_Tenants_GRPC(t *testing.T) {
+	srv, _ := newTenantServer(t)
+	client := newBufconnClient(t, srv)
+
+	v := 3.5
+	ctxB := metadata.AppendToOutgoingContext(context.Background(), tenant.Header, "team-b")
+	_, err := client.PushMetrics(ctxB, &pb.PushMetricsRequest{
+		Metrics: []*pb.Metric{{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &v}},
+	})
+	require.NoError(t, err)
+
+	resp, err := client.GetMetric(ctxB, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	require.NoError(t, err)
+	assert.Equal(t, v, resp.Metric.GetValue())
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	assert.Error(t, err)
+
+	ctxA := metadata.AppendToOutgoingContext(context.Background(), tenant.Header, "team-a")
+	_, err = client.GetMetric(ctxA, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctxA = metadata.AppendToOutgoingContext(ctxA, tenant.SignHeader, tenant.Sign("team-a", "secret"))
+	_, err = client.PushMetrics(ctxA, &pb.PushMetricsRequest{Metrics: []*pb.Metric{
+		{ID: "a", MType: metrics.MetricTypeGauge, Value: &v},
+		{ID: "b", MType: metrics.MetricTypeGauge, Value: &v},
+		{ID: "c", MType: metrics.MetricTypeGauge, Value: &v},
+	}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServer_Tenants_Tokens(t *testing.T) {
+	srv, h := newTenantServer(t)
+	data, err := json.Marshal(auth.TokensFile{Tokens: []auth.Token{
+		{Name: "agent-b", SHA256: auth.Hash("agent-b"), Scopes: []auth.Scope{auth.ScopePush, auth.ScopeRead}, Tenant: "team-b"},
+		{Name: "agent", SHA256: auth.Hash("agent"), Scopes: []auth.Scope{auth.ScopePush, auth.ScopeRead}},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	srv.tokens, err = auth.NewStore(path)
+	require.NoError(t, err)
+	authorized := srv.AuthMiddleware(h)
+
+	v := 1.5
+	tests := []struct {
+		name   string
+		token  string
+		tenant string
+		status int
+	}{
+		{name: "own tenant", token: "agent-b", tenant: "team-b", status: http.StatusOK},
+		{name: "tenant of token by default", token: "agent-b", status: http.StatusOK},
+		{name: "other tenant", token: "agent-b", tenant: "team-a", status: http.StatusForbidden},
+		{name: "token without tenant", token: "agent", tenant: "team-a", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tenantRequest(http.MethodPost, "/update/", tt.tenant,
+				metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &v})
+			r.Header.Set(auth.Header, auth.Bearer(tt.token))
+			w := httptest.NewRecorder()
+			authorized.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	// metric written without header belongs to tenant of token
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodPost, "/value/", "team-b",
+		metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, tenantRequest(http.MethodGet, "/api/metrics", "", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var page MetricsPage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Empty(t, page.Metrics)
+
+	client := newBufconnClient(t, srv)
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		auth.Header, auth.Bearer("agent-b"),
+		tenant.Header, "team-a", tenant.SignHeader, tenant.Sign("team-a", "secret"))
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	ctx = metadata.AppendToOutgoingContext(context.Background(), auth.Header, auth.Bearer("agent-b"))
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{ID: "Alloc", MType: metrics.MetricTypeGauge})
+	require.NoError(t, err)
+	assert.Equal(t, v, resp.Metric.GetValue())
+}
+
+func TestServer_Tenants_Watch(t *testing.T) {
+	srv, _ := newTenantServer(t)
+	client := newBufconnClient(t, srv)
+
+	ctxB := metadata.AppendToOutgoingContext(context.Background(), tenant.Header, "team-b")
+	ctx, cancel := context.WithCancel(ctxB)
+	defer cancel()
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{IDs: []string{"HeapAlloc"}})
+	require.NoError(t, err)
+
+	// only selected metric of tenant is streamed
+	require.Eventually(t, srv.bus.hasSubscribers, time.Second, 10*time.Millisecond)
+	heap, sys := 2048.0, 4096.0
+	require.NoError(t, srv.AddMetricsBatch(tenant.WithTenant(context.Background(), "team-b"), []metrics.Metric{
+		*metrics.NewCommonMetric("HeapSys", metrics.MetricTypeGauge, nil, &sys),
+	}))
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+		*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &sys),
+	}))
+	require.NoError(t, srv.AddMetricsBatch(tenant.WithTenant(context.Background(), "team-b"), []metrics.Metric{
+		*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &heap),
+	}))
+
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "HeapAlloc", update.Metric.ID)
+	assert.Equal(t, heap, update.Metric.GetValue())
+}
+
+func TestServer_Tenants_Anomalies(t *testing.T) {
+	srv, _ := newTenantServer(t)
+	cfg := anomaly.DefaultConfig()
+	cfg.Pattern = "^HeapAlloc$"
+	detector, err := anomaly.New(cfg)
+	require.NoError(t, err)
+	srv.detector = detector
+
+	// anchored pattern matches gauge by name which tenant sent
+	ctx := tenant.WithTenant(context.Background(), "team-b")
+	update := func(v float64) {
+		require.NoError(t, srv.AddMetricsBatch(ctx, []metrics.Metric{
+			*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &v),
+		}))
+	}
+	for i := 0; i < 30; i++ {
+		update(1000 + float64(i%3)*10)
+	}
+	update(1e6)
+
+	list := detector.Anomalies(time.Time{})
+	require.Len(t, list, 1)
+	assert.Equal(t, "team-b/HeapAlloc", list[0].Series)
+}
+
+func TestServer_Tenants_Rules(t *testing.T) {
+	srv, h := newTenantServer(t)
+	heapA, heapB := 2000.0, 10.0
+	for id, value := range map[string]*float64{"team-a": &heapA, "team-b": &heapB} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tenantRequest(http.MethodPost, "/update/", id,
+			metrics.Metric{ID: "HeapAlloc", MType: metrics.MetricTypeGauge, Value: value}))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	alertRule := alert.Rule{Name: "HighHeap", Query: "HeapAlloc", Op: ">", Threshold: 1000, Severity: "warning"}
+	require.NoError(t, alertRule.Validate())
+	recordRule := recording.Rule{Record: "HeapDouble", Query: "HeapAlloc * 2"}
+	require.NoError(t, recordRule.Validate())
+	srv.alerts = srv.newAlertEngines([]alert.Rule{alertRule})
+	srv.recorders = srv.newRecorders([]recording.Rule{recordRule})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.wg = &sync.WaitGroup{}
+	srv.startAlerting(ctx)
+	srv.startRecording(ctx)
+	defer func() {
+		cancel()
+		srv.wg.Wait()
+	}()
+
+	// rules match names of metrics as tenant sent them, alerts are seen only by their tenant
+	alerts := func(id string) []alert.Alert {
+		r := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+		w := httptest.NewRecorder()
+		srv.AlertsHandle(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+		require.Equal(t, http.StatusOK, w.Code)
+		var list []alert.Alert
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		return list
+	}
+	require.Eventually(t, func() bool { return len(alerts("team-a")) == 1 }, time.Second, 10*time.Millisecond)
+	list := alerts("team-a")
+	assert.Equal(t, "HeapAlloc", list[0].Series)
+	assert.Equal(t, "team-a", list[0].Tenant)
+	assert.Empty(t, alerts("team-b"))
+
+	// results of recording rules are stored among metrics of tenant
+	for id, want := range map[string]float64{"team-a": 2 * heapA, "team-b": 2 * heapB} {
+		require.Eventually(t, func() bool {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tenantRequest(http.MethodPost, "/value/", id,
+				metrics.Metric{ID: "HeapDouble", MType: metrics.MetricTypeGauge}))
+			var got metrics.Metric
+			return w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &got) == nil &&
+				got.Value != nil && *got.Value == want
+		}, time.Second, 10*time.Millisecond, id)
+	}
+}
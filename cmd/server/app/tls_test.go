@@ -0,0 +1,183 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig/tlstest"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// newTLSServer creates server which requires client certificates signed by CA of files,
+// requests from IPs out of trusted subnet are rejected unless client presents certificate
+func newTLSServer(t *testing.T, files tlstest.Files) *Server {
+	srv := newTestServer(t, testMetrics())
+	cfg, err := tlsconfig.Server(files.ServerCert, files.ServerKey, files.CA)
+	require.NoError(t, err)
+	srv.tlsConfig = cfg
+	srv.TrustedSubnet = "10.0.0.0/8"
+	return srv
+}
+
+func TestServer_TLS_HTTP(t *testing.T) {
+	files := tlstest.Generate(t, "agent-1")
+	srv := newTLSServer(t, files)
+
+	ts := httptest.NewUnstartedServer(srv.router())
+	ts.TLS = srv.tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	value := 2.5
+	body, err := json.Marshal(metrics.Metric{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value})
+	require.NoError(t, err)
+	post := func(cfg *tls.Config) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		r, err := http.NewRequest(http.MethodPost, ts.URL+"/update/", bytes.NewReader(body))
+		require.NoError(t, err)
+		r.Header.Set("Content-Type", "application/json")
+		// IP isn't trusted, but identity of client is proved by certificate
+		r.Header.Set("X-Real-IP", "192.168.1.1")
+		return client.Do(r)
+	}
+
+	cfg, err := tlsconfig.Client(files.CA, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	resp, err := post(cfg)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	report, err := srv.Cardinality(context.Background(), 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.TopClients)
+	assert.Equal(t, "agent-1", report.TopClients[0].Client)
+
+	// client without certificate can't connect
+	cfg, err = tlsconfig.Client(files.CA, "", "")
+	require.NoError(t, err)
+	_, err = post(cfg)
+	assert.Error(t, err)
+}
+
+func TestServer_TLS_GRPC(t *testing.T) {
+	files := tlstest.Generate(t, "agent-2")
+	srv := newTLSServer(t, files)
+
+	listener := bufconn.Listen(1024 * 1024)
+	s := srv.newGRPCServer()
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	dial := func(cfg *tls.Config) pb.MetricServerClient {
+		cfg.ServerName = "localhost"
+		conn, err := grpc.DialContext(context.Background(), "bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return pb.NewMetricServerClient(conn)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", "192.168.1.1")
+	value := 1.5
+	push := &pb.PushMetricsRequest{Metrics: []*pb.Metric{{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value}}}
+
+	cfg, err := tlsconfig.Client(files.CA, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	_, err = dial(cfg).PushMetrics(ctx, push)
+	require.NoError(t, err)
+
+	report, err := srv.Cardinality(context.Background(), 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, report.TopClients)
+	assert.Equal(t, "agent-2", report.TopClients[0].Client)
+
+	cfg, err = tlsconfig.Client(files.CA, "", "")
+	require.NoError(t, err)
+	_, err = dial(cfg).PushMetrics(ctx, push)
+	assert.Error(t, err)
+}
+
+func TestServer_Cluster_TLS(t *testing.T) {
+	ctx := context.Background()
+	files := tlstest.Generate(t, "node")
+	// nodes verify each other by certificates
+	nodes := newTestCluster(t, 2, func(settings *config.ServerFlags) {
+		settings.TLSCert = files.ServerCert
+		settings.TLSKey = files.ServerKey
+		settings.TLSClientCA = files.CA
+		settings.PeerTLSCA = files.CA
+		settings.PeerTLSCert = files.ClientCert
+		settings.PeerTLSKey = files.ClientKey
+	})
+
+	var batch []metrics.Metric
+	for i := 0; i < 10; i++ {
+		delta := int64(i)
+		batch = append(batch, *metrics.NewCommonMetric(fmt.Sprintf("requests_%d", i), metrics.MetricTypeCounter, &delta, nil))
+	}
+	require.NoError(t, nodes[0].AddMetricsBatch(ctx, batch))
+	all, err := nodes[1].GetAllMetricsNew(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 10)
+	assert.NoError(t, nodes[1].Ping(ctx))
+}
+
+func TestServer_Replication_TLS(t *testing.T) {
+	ctx := context.Background()
+	files := tlstest.Generate(t, "follower")
+	leader := newTLSServer(t, files)
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	leader.grpcServer = leader.newGRPCServer()
+	go leader.grpcServer.Serve(listen)
+	defer leader.grpcServer.Stop()
+
+	// certificate of server has no client usage, follower presents its own one
+	memst := memstorage.NewMemStorage()
+	replica := &Server{storage: memst}
+	replica.follower, err = newFollower(&config.ServerFlags{
+		ReplicaOf:          listen.Addr().String(),
+		TLSCert:            files.ServerCert,
+		TLSKey:             files.ServerKey,
+		ReplicationTLSCA:   files.CA,
+		ReplicationTLSCert: files.ClientCert,
+		ReplicationTLSKey:  files.ClientKey,
+	}, memst)
+	require.NoError(t, err)
+	replica.readOnly.Store(true)
+	replica.wg = &sync.WaitGroup{}
+	replicaCtx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		replica.wg.Wait()
+	}()
+	replica.startReplication(replicaCtx)
+
+	require.Eventually(t, func() bool {
+		all, err := replica.GetAllMetricsNew(ctx)
+		return err == nil && len(all) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+}
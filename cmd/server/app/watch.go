@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/tenant"
+)
+
+// interval of keep-alive comments in event stream
+const keepAliveInterval = 15 * time.Second
+
+// errSlowSubscriber is returned when subscriber was unsubscribed because its queue is full
+var errSlowSubscriber = errors.New("subscriber is too slow to read changes")
+
+// watch subscribes to changes of metrics selected by filter and calls send for each change.
+// If initial is true, current values of selected metrics are sent first.
+// It returns when ctx is done or send returns error
+func (srv *Server) watch(ctx context.Context, filter WatchFilter, initial bool, send func(MetricEvent) error) error {
+	// events of bus have names of metrics as they are stored
+	id := tenant.FromContext(ctx)
+	var scoped WatchFilter
+	for _, name := range filter.Names {
+		scoped.Names = append(scoped.Names, tenant.Scope(id, name))
+	}
+	// prefix extends selection by names, so it is scoped only if it is set
+	// or if it selects all metrics of tenant
+	if filter.Prefix != "" || len(filter.Names) == 0 {
+		scoped.Prefix = tenant.Scope(id, filter.Prefix)
+	}
+
+	// subscribe before reading of current values, so no change is missed
+	events, cancel := srv.subscribe(scoped)
+	defer cancel()
+
+	if initial {
+		all, err := srv.GetAllMetricsNew(ctx)
+		if err != nil {
+			return err
+		}
+		names := make(map[string]struct{}, len(filter.Names))
+		for _, name := range filter.Names {
+			names[name] = struct{}{}
+		}
+		now := time.Now()
+		for _, el := range all {
+			if !filter.match(el.ID, names) {
+				continue
+			}
+			if err := send(MetricEvent{Metric: *el, Time: now}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return errSlowSubscriber
+			}
+			e.Metric.ID, _ = tenant.Unscope(id, e.Metric.ID)
+			if err := send(e); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// WatchHandle godoc
+// @Tags getvalue
+// @Summary Watch changes of metrics
+// @Description Stream of changes of metrics in Server-Sent Events format.
+// @Description Metrics are selected by names (parameter id, can be repeated) or by prefix of name.
+// @ID watch
+// @Produce text/event-stream
+// @Param id query string false "Metric name"
+// @Param prefix query string false "Prefix of metric name"
+// @Param initial query bool false "Send current values first"
+// @Success 200 {string} string "Event stream"
+// @Failure 405 {string} string "Invalid request type"
+// @Failure 500 {string} string "Streaming is not supported"
+// @Router /api/watch [get]
+func (srv *Server) WatchHandle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := WatchFilter{
+		Names:  query["id"],
+		Prefix: query.Get("prefix"),
+	}
+	initial := query.Get("initial") == "true"
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// keep-alive comments are written from the same goroutine as events,
+	// so writes to response are not concurrent
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	events := make(chan MetricEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.watch(ctx, filter, initial, func(e MetricEvent) error {
+			select {
+			case events <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	for {
+		select {
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: metric\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case err := <-done:
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
+				flusher.Flush()
+			}
+			return
+		}
+	}
+}
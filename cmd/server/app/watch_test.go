@@ -0,0 +1,133 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	pb "github.com/kvvPro/metric-collector/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_changeBus(t *testing.T) {
+	var bus changeBus
+	assert.False(t, bus.hasSubscribers())
+
+	byName, cancelByName := bus.subscribe(WatchFilter{Names: []string{"HeapAlloc"}})
+	byPrefix, cancelByPrefix := bus.subscribe(WatchFilter{Prefix: "CPU"})
+	defer cancelByPrefix()
+	assert.True(t, bus.hasSubscribers())
+
+	now := time.Now()
+	bus.publish([]MetricEvent{
+		{Metric: metrics.Metric{ID: "HeapAlloc", MType: metrics.MetricTypeGauge}, Time: now},
+		{Metric: metrics.Metric{ID: "CPUutilization1", MType: metrics.MetricTypeGauge}, Time: now},
+	})
+
+	e := <-byName
+	assert.Equal(t, "HeapAlloc", e.Metric.ID)
+	e = <-byPrefix
+	assert.Equal(t, "CPUutilization1", e.Metric.ID)
+
+	cancelByName()
+	_, opened := <-byName
+	assert.False(t, opened)
+}
+
+func Test_changeBus_SlowSubscriber(t *testing.T) {
+	var bus changeBus
+	events, cancel := bus.subscribe(WatchFilter{})
+	defer cancel()
+
+	batch := make([]MetricEvent, subscriberBuffer+1)
+	for i := range batch {
+		batch[i] = MetricEvent{Metric: metrics.Metric{ID: "PollCount", MType: metrics.MetricTypeCounter}}
+	}
+	bus.publish(batch)
+
+	// queue is full, so subscriber is removed and its channel is closed
+	count := 0
+	for range events {
+		count++
+	}
+	assert.Equal(t, subscriberBuffer, count)
+	assert.False(t, bus.hasSubscribers())
+}
+
+func TestServer_WatchMetrics(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	client := newBufconnClient(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Prefix: "Heap", Initial: true})
+	require.NoError(t, err)
+
+	// current values of HeapAlloc and HeapSys
+	initial := make(map[string]float64)
+	for i := 0; i < 2; i++ {
+		update, err := stream.Recv()
+		require.NoError(t, err)
+		initial[update.Metric.ID] = update.Metric.GetValue()
+	}
+	assert.Equal(t, map[string]float64{"HeapAlloc": 1024.5, "HeapSys": 4096}, initial)
+
+	// wait until subscription is registered and apply changes
+	require.Eventually(t, srv.bus.hasSubscribers, time.Second, 10*time.Millisecond)
+	heap := 2048.0
+	delta := int64(1)
+	require.NoError(t, srv.AddMetricsBatch(context.Background(), []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &delta, nil),
+		*metrics.NewCommonMetric("HeapAlloc", metrics.MetricTypeGauge, nil, &heap),
+	}))
+
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "HeapAlloc", update.Metric.ID)
+	assert.Equal(t, heap, update.Metric.GetValue())
+	assert.NotZero(t, update.Timestamp)
+}
+
+func TestServer_WatchHandle(t *testing.T) {
+	srv := newTestServer(t, testMetrics())
+	ts := httptest.NewServer(http.HandlerFunc(srv.WatchHandle))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/watch?id=PollCount", nil)
+	require.NoError(t, err)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	require.Eventually(t, srv.bus.hasSubscribers, time.Second, 10*time.Millisecond)
+	delta := int64(3)
+	require.NoError(t, srv.AddMetricNew(context.Background(),
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &delta, nil)))
+
+	scanner := bufio.NewScanner(response.Body)
+	var data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+			break
+		}
+	}
+	require.NotEmpty(t, data)
+
+	var e MetricEvent
+	require.NoError(t, json.Unmarshal([]byte(data), &e))
+	assert.Equal(t, "PollCount", e.Metric.ID)
+	// current value of counter is sent, not the applied delta
+	assert.Equal(t, int64(8), *e.Metric.Delta)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/notify"
+)
+
+// newNotifier creates notifier for webhooks from settings
+func newNotifier(settings *config.ServerFlags) *notify.Notifier {
+	cfg := notify.DefaultConfig()
+	for _, url := range strings.Split(settings.WebhookURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			cfg.URLs = append(cfg.URLs, url)
+		}
+	}
+	cfg.Key = settings.WebhookKey
+	cfg.MetricEvents = settings.WebhookMetricEvents
+	cfg.DeadLetterPath = settings.WebhookDeadLetter
+	return notify.New(cfg)
+}
+
+// notifyAlert sends changed alert to webhooks
+func (srv *Server) notifyAlert(a alert.Alert) {
+	// leader of replication or cluster notifies about the same alerts
+	if srv.notifier == nil || srv.readOnly.Load() || !srv.isLeader() {
+		return
+	}
+	srv.notifier.Notify(notify.Event{
+		Type:  notify.EventAlert,
+		Time:  time.Now(),
+		Alert: &a,
+	})
+}
+
+// startNotifier delivers notifications to webhooks until ctx is done
+func (srv *Server) startNotifier(ctx context.Context) {
+	if srv.notifier == nil {
+		return
+	}
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.notifier.Run(ctx)
+		Sugar.Infoln("остановка отправки уведомлений")
+	}()
+
+	if !srv.notifier.MetricEvents() {
+		return
+	}
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.forwardMetricEvents(ctx)
+	}()
+}
+
+// forwardMetricEvents sends changes of metrics to webhooks
+func (srv *Server) forwardMetricEvents(ctx context.Context) {
+	for {
+		events, cancel := srv.bus.subscribe(WatchFilter{})
+		for opened := true; opened; {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					// too slow subscriber is unsubscribed by bus, subscribe again
+					Sugar.Infoln("metric events for webhooks were dropped")
+					opened = false
+					continue
+				}
+				m := e.Metric
+				srv.notifier.Notify(notify.Event{
+					Type:   notify.EventMetric,
+					Time:   e.Time,
+					Metric: &m,
+				})
+			case <-ctx.Done():
+				cancel()
+				return
+			}
+		}
+	}
+}
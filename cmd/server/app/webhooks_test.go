@@ -0,0 +1,83 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/cmd/server/config"
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Webhooks(t *testing.T) {
+	var mx sync.Mutex
+	var received []notify.Event
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var e notify.Event
+		if json.Unmarshal(body, &e) == nil {
+			mx.Lock()
+			received = append(received, e)
+			mx.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer hook.Close()
+
+	srv := newTestServer(t, testMetrics())
+	srv.notifier = newNotifier(&config.ServerFlags{
+		WebhookURLs:         " " + hook.URL + ",",
+		WebhookKey:          "secret",
+		WebhookMetricEvents: true,
+	})
+	rule := alert.Rule{Name: "HighHeap", Query: "HeapAlloc", Op: ">", Threshold: 1000, Severity: "warning"}
+	require.NoError(t, rule.Validate())
+	srv.alerts = srv.newAlertEngines([]alert.Rule{rule})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv.wg = &sync.WaitGroup{}
+	srv.startNotifier(ctx)
+	defer func() {
+		cancel()
+		srv.wg.Wait()
+	}()
+	require.Eventually(t, srv.bus.hasSubscribers, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srv.alerts[""].Evaluate(ctx, time.Now()))
+	poll := int64(1)
+	require.NoError(t, srv.AddMetricsBatch(ctx, []metrics.Metric{
+		*metrics.NewCommonMetric("PollCount", metrics.MetricTypeCounter, &poll, nil),
+	}))
+
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	mx.Lock()
+	defer mx.Unlock()
+	for _, e := range received {
+		switch e.Type {
+		case notify.EventAlert:
+			require.NotNil(t, e.Alert)
+			assert.Equal(t, "HighHeap", e.Alert.Rule)
+			assert.Equal(t, alert.StateFiring, e.Alert.State)
+		case notify.EventMetric:
+			require.NotNil(t, e.Metric)
+			assert.Equal(t, "PollCount", e.Metric.ID)
+			assert.Equal(t, int64(6), *e.Metric.Delta)
+		default:
+			t.Errorf("unexpected event type %q", e.Type)
+		}
+	}
+}
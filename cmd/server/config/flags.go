@@ -11,17 +11,58 @@ import (
 )
 
 type ServerFlags struct {
-	Address         string `env:"ADDRESS" json:"address"`
-	StoreInterval   int    `env:"STORE_INTERVAL" json:"store_interval"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" json:"store_file"`
-	Restore         bool   `env:"RESTORE" json:"restore"`
-	DBConnection    string `env:"DATABASE_DSN" json:"database_dsn"`
-	HashKey         string `env:"KEY" json:"hash_key"`
-	MemProfile      string `env:"MEM_PROFILE" json:"mem_profile"`
-	CryptoKey       string `env:"CRYPTO_KEY" json:"crypto_key"`
-	TrustedSubnet   string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	ExchangeMode    string `env:"EXCHANGE_MODE" json:"exchange_mode"`
-	Config          string `env:"CONFIG" json:"config"`
+	Address               string  `env:"ADDRESS" json:"address"`
+	StoreInterval         int     `env:"STORE_INTERVAL" json:"store_interval"`
+	FileStoragePath       string  `env:"FILE_STORAGE_PATH" json:"store_file"`
+	Restore               bool    `env:"RESTORE" json:"restore"`
+	DBConnection          string  `env:"DATABASE_DSN" json:"database_dsn"`
+	HashKey               string  `env:"KEY" json:"hash_key"`
+	MemProfile            string  `env:"MEM_PROFILE" json:"mem_profile"`
+	CryptoKey             string  `env:"CRYPTO_KEY" json:"crypto_key"`
+	TrustedSubnet         string  `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	ExchangeMode          string  `env:"EXCHANGE_MODE" json:"exchange_mode"`
+	Config                string  `env:"CONFIG" json:"config"`
+	HistorySize           int     `env:"HISTORY_SIZE" json:"history_size"`
+	AlertRules            string  `env:"ALERT_RULES" json:"alert_rules"`
+	AlertInterval         int     `env:"ALERT_INTERVAL" json:"alert_interval"`
+	WebhookURLs           string  `env:"WEBHOOK_URLS" json:"webhook_urls"`
+	WebhookKey            string  `env:"WEBHOOK_KEY" json:"webhook_key"`
+	WebhookMetricEvents   bool    `env:"WEBHOOK_METRIC_EVENTS" json:"webhook_metric_events"`
+	WebhookDeadLetter     string  `env:"WEBHOOK_DEAD_LETTER" json:"webhook_dead_letter"`
+	AnomalyGauges         string  `env:"ANOMALY_GAUGES" json:"anomaly_gauges"`
+	AnomalyAlpha          float64 `env:"ANOMALY_ALPHA" json:"anomaly_alpha"`
+	AnomalyK              float64 `env:"ANOMALY_K" json:"anomaly_k"`
+	RecordingRules        string  `env:"RECORDING_RULES" json:"recording_rules"`
+	RecordingInterval     int     `env:"RECORDING_INTERVAL" json:"recording_interval"`
+	ForwardURLs           string  `env:"FORWARD_URLS" json:"forward_urls"`
+	ForwardRegex          string  `env:"FORWARD_REGEX" json:"forward_regex"`
+	ForwardKey            string  `env:"FORWARD_KEY" json:"forward_key"`
+	ForwardCryptoKey      string  `env:"FORWARD_CRYPTO_KEY" json:"forward_crypto_key"`
+	ForwardBatchSize      int     `env:"FORWARD_BATCH_SIZE" json:"forward_batch_size"`
+	ForwardInterval       int     `env:"FORWARD_INTERVAL" json:"forward_interval"`
+	ClusterNodes          string  `env:"CLUSTER_NODES" json:"cluster_nodes"`
+	ClusterSelf           string  `env:"CLUSTER_SELF" json:"cluster_self"`
+	ReplicaOf             string  `env:"REPLICA_OF" json:"replica_of"`
+	ReplicationAddress    string  `env:"REPLICATION_ADDRESS" json:"replication_address"`
+	ReplicationLease      int     `env:"REPLICATION_LEASE" json:"replication_lease"`
+	Tenants               string  `env:"TENANTS" json:"tenants"`
+	MaxSeries             int     `env:"MAX_SERIES" json:"max_series"`
+	MaxNewSeriesPerMinute int     `env:"MAX_NEW_SERIES_PER_MINUTE" json:"max_new_series_per_minute"`
+	MaxBatchSize          int     `env:"MAX_BATCH_SIZE" json:"max_batch_size"`
+	RateLimits            string  `env:"RATE_LIMITS" json:"rate_limits"`
+	Tokens                string  `env:"TOKENS" json:"tokens"`
+	ReplicationToken      string  `env:"REPLICATION_TOKEN" json:"replication_token"`
+	TLSCert               string  `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey                string  `env:"TLS_KEY" json:"tls_key"`
+	TLSClientCA           string  `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	NodeToken             string  `env:"NODE_TOKEN" json:"node_token"`
+	PeerTLSCA             string  `env:"PEER_TLS_CA" json:"peer_tls_ca"`
+	PeerTLSCert           string  `env:"PEER_TLS_CERT" json:"peer_tls_cert"`
+	PeerTLSKey            string  `env:"PEER_TLS_KEY" json:"peer_tls_key"`
+	ReplicationTLSCA      string  `env:"REPLICATION_TLS_CA" json:"replication_tls_ca"`
+	ReplicationTLSCert    string  `env:"REPLICATION_TLS_CERT" json:"replication_tls_cert"`
+	ReplicationTLSKey     string  `env:"REPLICATION_TLS_KEY" json:"replication_tls_key"`
+	TrustedProxies        string  `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
 }
 
 func Initialize(flags *ServerFlags) error {
@@ -41,6 +82,47 @@ func Initialize(flags *ServerFlags) error {
 	pflag.StringVarP(&flags.CryptoKey, "crypto-key", "e", "/workspaces/metric-collector/cmd/keys/key", "Path to private key RSA to decrypt messages")
 	pflag.StringVarP(&flags.TrustedSubnet, "trusted-subnet", "t", "", "Trusted subnet for clients")
 	pflag.StringVarP(&flags.ExchangeMode, "exchange-mode", "x", "http", "Exchange mode - http or grpc")
+	pflag.IntVar(&flags.HistorySize, "history-size", 720, "Number of last values of each metric kept for charts, rates and queries. History is disabled if 0")
+	pflag.StringVar(&flags.AlertRules, "alert-rules", "", "Path to JSON file with alerting rules, alerting is disabled if empty")
+	pflag.IntVar(&flags.AlertInterval, "alert-interval", 10, "Interval in seconds between evaluations of alerting rules")
+	pflag.StringVar(&flags.WebhookURLs, "webhook-urls", "", "Comma separated URLs of webhooks for alerts, notifications are disabled if empty")
+	pflag.StringVar(&flags.WebhookKey, "webhook-key", "", "Key to sign requests to webhooks")
+	pflag.BoolVar(&flags.WebhookMetricEvents, "webhook-metric-events", false, "True if changes of metrics are sent to webhooks too")
+	pflag.StringVar(&flags.WebhookDeadLetter, "webhook-dead-letter", "/tmp/webhook-dead-letter.json", "Path to file where undelivered notifications are saved")
+	pflag.StringVar(&flags.AnomalyGauges, "anomaly-gauges", "", "Regular expression of gauges watched by anomaly detector, detector is disabled if empty")
+	pflag.Float64Var(&flags.AnomalyAlpha, "anomaly-alpha", 0.1, "Smoothing factor of baselines of anomaly detector in (0, 1]")
+	pflag.Float64Var(&flags.AnomalyK, "anomaly-k", 3, "Count of std deviations from baseline which makes value anomalous")
+	pflag.StringVar(&flags.RecordingRules, "recording-rules", "", "Path to JSON file with recording rules, recording is disabled if empty")
+	pflag.IntVar(&flags.RecordingInterval, "recording-interval", 10, "Interval in seconds between evaluations of recording rules")
+	pflag.StringVar(&flags.ForwardURLs, "forward-urls", "", "Comma separated upstream servers, http(s)://host:port or grpc(s)://host:port, forwarding is disabled if empty")
+	pflag.StringVar(&flags.ForwardRegex, "forward-regex", "", "Regular expression of names of forwarded metrics, all metrics are forwarded if empty")
+	pflag.StringVar(&flags.ForwardKey, "forward-key", "", "Hash key of upstream servers")
+	pflag.StringVar(&flags.ForwardCryptoKey, "forward-crypto-key", "", "Path to public RSA key of upstream servers to encrypt requests")
+	pflag.IntVar(&flags.ForwardBatchSize, "forward-batch-size", 500, "Max count of metrics in one request to upstream")
+	pflag.IntVar(&flags.ForwardInterval, "forward-interval", 1, "Interval in seconds between requests to upstream")
+	pflag.StringVar(&flags.ClusterNodes, "cluster-nodes", "", "Comma separated addresses host:port of all nodes of cluster including this one, cluster mode is disabled if empty")
+	pflag.StringVar(&flags.ClusterSelf, "cluster-self", "", "Address of this node in cluster-nodes, the same as addr if empty")
+	pflag.StringVar(&flags.ReplicaOf, "replica-of", "", "Address host:port of Replication service of leader, server is a read-only follower if it is set")
+	pflag.StringVar(&flags.ReplicationAddress, "replication-addr", "", "Address host:port to serve Replication service to followers, in grpc mode the service is also served on addr")
+	pflag.IntVar(&flags.ReplicationLease, "replication-lease", 0, "Follower is promoted if leader is unavailable for this count of seconds, 0 means promotion only by admin call")
+	pflag.StringVar(&flags.Tenants, "tenants", "", "Path to JSON file with tenants, multi-tenancy is disabled if empty")
+	pflag.IntVar(&flags.MaxSeries, "max-series", 0, "Max count of series, writes of new series over limit are rejected, 0 means no limit")
+	pflag.IntVar(&flags.MaxNewSeriesPerMinute, "max-new-series", 0, "Max count of new series created by one client per minute, 0 means no limit")
+	pflag.IntVar(&flags.MaxBatchSize, "max-batch-size", 0, "Max count of metrics in one batch, 0 means no limit")
+	pflag.StringVar(&flags.RateLimits, "rate-limits", "", "Comma separated limits of requests of each client route=rate[:burst], rate is per second. Route is a prefix of HTTP path or gRPC method, * matches all requests")
+	pflag.StringVar(&flags.Tokens, "tokens", "", "Path to JSON file with SHA-256 hashes and scopes of tokens of clients, it is reloaded on SIGHUP. Clients aren't authenticated if empty")
+	pflag.StringVar(&flags.ReplicationToken, "replication-token", "", "Token with admin scope sent by follower to leader")
+	pflag.StringVar(&flags.TLSCert, "tls-cert", "", "Path to PEM certificate of server for HTTP and gRPC, TLS is disabled if empty")
+	pflag.StringVar(&flags.TLSKey, "tls-key", "", "Path to PEM private key of certificate of server")
+	pflag.StringVar(&flags.TLSClientCA, "tls-client-ca", "", "Path to PEM CA which must sign certificates of clients, client is identified by common name of certificate. Clients certificates are not required if empty")
+	pflag.StringVar(&flags.NodeToken, "node-token", "", "Token sent to other nodes of cluster and to upstreams of forwarder, nodes of cluster require its admin scope if tokens are set")
+	pflag.StringVar(&flags.PeerTLSCA, "peer-tls-ca", "", "Path to PEM CA which verifies other nodes of cluster and upstreams of forwarder, system CAs are used if empty")
+	pflag.StringVar(&flags.PeerTLSCert, "peer-tls-cert", "", "Path to PEM client certificate presented to other nodes of cluster and upstreams of forwarder which verify clients")
+	pflag.StringVar(&flags.PeerTLSKey, "peer-tls-key", "", "Path to PEM private key of peer-tls-cert")
+	pflag.StringVar(&flags.ReplicationTLSCA, "replication-tls-ca", "", "Path to PEM CA which verifies leader of replication, system CAs are used if empty")
+	pflag.StringVar(&flags.ReplicationTLSCert, "replication-tls-cert", "", "Path to PEM client certificate presented by follower to leader which verifies clients")
+	pflag.StringVar(&flags.ReplicationTLSKey, "replication-tls-key", "", "Path to PEM private key of replication-tls-cert")
+	pflag.StringVar(&flags.TrustedProxies, "trusted-proxies", "", "Comma separated subnets of reverse proxies which pass IP of client in X-Real-IP, clients are identified by their own IP if empty")
 	// pflag.StringVarP(&flags.Config, "config", "c", "/workspaces/metric-collector/cmd/server/config/config.json", "Path to server config file")
 
 	pflag.Parse()
@@ -57,6 +139,47 @@ func Initialize(flags *ServerFlags) error {
 	fmt.Printf("TRUSTED_SUBNET=%v", flags.TrustedSubnet)
 	fmt.Printf("\nEXCHANGE_MODE=%v", flags.ExchangeMode)
 	fmt.Printf("CONFIG=%v", flags.Config)
+	fmt.Printf("HISTORY_SIZE=%v", flags.HistorySize)
+	fmt.Printf("ALERT_RULES=%v", flags.AlertRules)
+	fmt.Printf("ALERT_INTERVAL=%v", flags.AlertInterval)
+	fmt.Printf("WEBHOOK_URLS=%v", flags.WebhookURLs)
+	fmt.Printf("WEBHOOK_KEY=%v", flags.WebhookKey)
+	fmt.Printf("WEBHOOK_METRIC_EVENTS=%v", flags.WebhookMetricEvents)
+	fmt.Printf("WEBHOOK_DEAD_LETTER=%v", flags.WebhookDeadLetter)
+	fmt.Printf("ANOMALY_GAUGES=%v", flags.AnomalyGauges)
+	fmt.Printf("ANOMALY_ALPHA=%v", flags.AnomalyAlpha)
+	fmt.Printf("ANOMALY_K=%v", flags.AnomalyK)
+	fmt.Printf("RECORDING_RULES=%v", flags.RecordingRules)
+	fmt.Printf("RECORDING_INTERVAL=%v", flags.RecordingInterval)
+	fmt.Printf("FORWARD_URLS=%v", flags.ForwardURLs)
+	fmt.Printf("FORWARD_REGEX=%v", flags.ForwardRegex)
+	fmt.Printf("FORWARD_KEY=%v", flags.ForwardKey)
+	fmt.Printf("FORWARD_CRYPTO_KEY=%v", flags.ForwardCryptoKey)
+	fmt.Printf("FORWARD_BATCH_SIZE=%v", flags.ForwardBatchSize)
+	fmt.Printf("FORWARD_INTERVAL=%v", flags.ForwardInterval)
+	fmt.Printf("CLUSTER_NODES=%v", flags.ClusterNodes)
+	fmt.Printf("CLUSTER_SELF=%v", flags.ClusterSelf)
+	fmt.Printf("REPLICA_OF=%v", flags.ReplicaOf)
+	fmt.Printf("REPLICATION_ADDRESS=%v", flags.ReplicationAddress)
+	fmt.Printf("REPLICATION_LEASE=%v", flags.ReplicationLease)
+	fmt.Printf("TENANTS=%v", flags.Tenants)
+	fmt.Printf("MAX_SERIES=%v", flags.MaxSeries)
+	fmt.Printf("MAX_NEW_SERIES_PER_MINUTE=%v", flags.MaxNewSeriesPerMinute)
+	fmt.Printf("MAX_BATCH_SIZE=%v", flags.MaxBatchSize)
+	fmt.Printf("RATE_LIMITS=%v", flags.RateLimits)
+	fmt.Printf("TOKENS=%v", flags.Tokens)
+	fmt.Printf("REPLICATION_TOKEN=%v", flags.ReplicationToken != "")
+	fmt.Printf("TLS_CERT=%v", flags.TLSCert)
+	fmt.Printf("TLS_KEY=%v", flags.TLSKey)
+	fmt.Printf("TLS_CLIENT_CA=%v", flags.TLSClientCA)
+	fmt.Printf("NODE_TOKEN=%v", flags.NodeToken != "")
+	fmt.Printf("PEER_TLS_CA=%v", flags.PeerTLSCA)
+	fmt.Printf("PEER_TLS_CERT=%v", flags.PeerTLSCert)
+	fmt.Printf("PEER_TLS_KEY=%v", flags.PeerTLSKey)
+	fmt.Printf("REPLICATION_TLS_CA=%v", flags.ReplicationTLSCA)
+	fmt.Printf("REPLICATION_TLS_CERT=%v", flags.ReplicationTLSCert)
+	fmt.Printf("REPLICATION_TLS_KEY=%v", flags.ReplicationTLSKey)
+	fmt.Printf("TRUSTED_PROXIES=%v", flags.TrustedProxies)
 
 	// try to get vars from env
 	if err := env.Parse(flags); err != nil {
@@ -74,6 +197,47 @@ func Initialize(flags *ServerFlags) error {
 	fmt.Printf("TRUSTED_SUBNET=%v", flags.TrustedSubnet)
 	fmt.Printf("\nEXCHANGE_MODE=%v", flags.ExchangeMode)
 	fmt.Printf("CONFIG=%v", flags.Config)
+	fmt.Printf("HISTORY_SIZE=%v", flags.HistorySize)
+	fmt.Printf("ALERT_RULES=%v", flags.AlertRules)
+	fmt.Printf("ALERT_INTERVAL=%v", flags.AlertInterval)
+	fmt.Printf("WEBHOOK_URLS=%v", flags.WebhookURLs)
+	fmt.Printf("WEBHOOK_KEY=%v", flags.WebhookKey)
+	fmt.Printf("WEBHOOK_METRIC_EVENTS=%v", flags.WebhookMetricEvents)
+	fmt.Printf("WEBHOOK_DEAD_LETTER=%v", flags.WebhookDeadLetter)
+	fmt.Printf("ANOMALY_GAUGES=%v", flags.AnomalyGauges)
+	fmt.Printf("ANOMALY_ALPHA=%v", flags.AnomalyAlpha)
+	fmt.Printf("ANOMALY_K=%v", flags.AnomalyK)
+	fmt.Printf("RECORDING_RULES=%v", flags.RecordingRules)
+	fmt.Printf("RECORDING_INTERVAL=%v", flags.RecordingInterval)
+	fmt.Printf("FORWARD_URLS=%v", flags.ForwardURLs)
+	fmt.Printf("FORWARD_REGEX=%v", flags.ForwardRegex)
+	fmt.Printf("FORWARD_KEY=%v", flags.ForwardKey)
+	fmt.Printf("FORWARD_CRYPTO_KEY=%v", flags.ForwardCryptoKey)
+	fmt.Printf("FORWARD_BATCH_SIZE=%v", flags.ForwardBatchSize)
+	fmt.Printf("FORWARD_INTERVAL=%v", flags.ForwardInterval)
+	fmt.Printf("CLUSTER_NODES=%v", flags.ClusterNodes)
+	fmt.Printf("CLUSTER_SELF=%v", flags.ClusterSelf)
+	fmt.Printf("REPLICA_OF=%v", flags.ReplicaOf)
+	fmt.Printf("REPLICATION_ADDRESS=%v", flags.ReplicationAddress)
+	fmt.Printf("REPLICATION_LEASE=%v", flags.ReplicationLease)
+	fmt.Printf("TENANTS=%v", flags.Tenants)
+	fmt.Printf("MAX_SERIES=%v", flags.MaxSeries)
+	fmt.Printf("MAX_NEW_SERIES_PER_MINUTE=%v", flags.MaxNewSeriesPerMinute)
+	fmt.Printf("MAX_BATCH_SIZE=%v", flags.MaxBatchSize)
+	fmt.Printf("RATE_LIMITS=%v", flags.RateLimits)
+	fmt.Printf("TOKENS=%v", flags.Tokens)
+	fmt.Printf("REPLICATION_TOKEN=%v", flags.ReplicationToken != "")
+	fmt.Printf("TLS_CERT=%v", flags.TLSCert)
+	fmt.Printf("TLS_KEY=%v", flags.TLSKey)
+	fmt.Printf("TLS_CLIENT_CA=%v", flags.TLSClientCA)
+	fmt.Printf("NODE_TOKEN=%v", flags.NodeToken != "")
+	fmt.Printf("PEER_TLS_CA=%v", flags.PeerTLSCA)
+	fmt.Printf("PEER_TLS_CERT=%v", flags.PeerTLSCert)
+	fmt.Printf("PEER_TLS_KEY=%v", flags.PeerTLSKey)
+	fmt.Printf("REPLICATION_TLS_CA=%v", flags.ReplicationTLSCA)
+	fmt.Printf("REPLICATION_TLS_CERT=%v", flags.ReplicationTLSCert)
+	fmt.Printf("REPLICATION_TLS_KEY=%v", flags.ReplicationTLSKey)
+	fmt.Printf("TRUSTED_PROXIES=%v", flags.TrustedProxies)
 
 	return nil
 }
@@ -0,0 +1,227 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/query"
+)
+
+// State is a state of alert
+type State string
+
+const (
+	// condition is true, but not during For duration yet
+	StatePending State = "pending"
+	// condition is true during For duration
+	StateFiring State = "firing"
+	// condition of firing alert became false
+	StateResolved State = "resolved"
+)
+
+// how long resolved alerts are kept
+const resolvedRetention = 15 * time.Minute
+
+// Alert is a state of rule for one series
+type Alert struct {
+	// tenant which metrics were evaluated, empty if multi-tenancy is disabled
+	Tenant   string `json:"tenant,omitempty"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary,omitempty"`
+	// identifier of series which value satisfies rule
+	Series    string            `json:"series"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	State     State             `json:"state"`
+	Op        string            `json:"op"`
+	Threshold float64           `json:"threshold"`
+	// the last evaluated value
+	Value float64 `json:"value"`
+	// time when condition became true
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Engine periodically evaluates rules and keeps states of alerts.
+// It is safe for concurrent use
+type Engine struct {
+	rules []Rule
+	src   query.Source
+
+	mx sync.RWMutex
+	// alerts by rule name and series
+	alerts map[alertKey]*Alert
+	// called for each change of alert state, may be nil
+	onChange func(Alert)
+}
+
+type alertKey struct {
+	rule   string
+	series string
+}
+
+// NewEngine creates engine for validated rules
+func NewEngine(rules []Rule, src query.Source) *Engine {
+	return &Engine{
+		rules:  rules,
+		src:    src,
+		alerts: make(map[alertKey]*Alert),
+	}
+}
+
+// Rules returns rules of engine
+func (e *Engine) Rules() []Rule {
+	return e.rules
+}
+
+// OnChange sets function called after each change of alert state.
+// It must be set before evaluation starts
+func (e *Engine) OnChange(fn func(Alert)) {
+	e.onChange = fn
+}
+
+// Run evaluates rules every interval until ctx is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Evaluate(ctx, time.Now()); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Evaluate evaluates all rules at moment now and updates states of alerts.
+// Alerts of rule which query failed keep their states
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	var errs []error
+	for i := range e.rules {
+		rule := &e.rules[i]
+		res, err := query.Evaluate(ctx, e.src, rule.expr, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+			continue
+		}
+		for _, a := range e.apply(rule, res, now) {
+			if e.onChange != nil {
+				e.onChange(a)
+			}
+		}
+	}
+
+	e.mx.Lock()
+	for key, a := range e.alerts {
+		if a.State == StateResolved && now.Sub(*a.ResolvedAt) > resolvedRetention {
+			delete(e.alerts, key)
+		}
+	}
+	e.mx.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// apply updates alerts of rule by result of its query and returns
+// copies of alerts which state has changed
+func (e *Engine) apply(rule *Rule, res *query.Result, now time.Time) []Alert {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	var changed []Alert
+
+	compare := comparisons[rule.Op]
+	active := make(map[string]struct{}, len(res.Series))
+	for _, s := range res.Series {
+		if !compare(s.Value, rule.Threshold) {
+			continue
+		}
+		id := s.ID()
+		active[id] = struct{}{}
+		key := alertKey{rule: rule.Name, series: id}
+
+		a, ok := e.alerts[key]
+		created := !ok || a.State == StateResolved
+		if created {
+			a = &Alert{
+				Rule:      rule.Name,
+				Severity:  rule.Severity,
+				Summary:   rule.Summary,
+				Series:    id,
+				Labels:    s.Labels,
+				State:     StatePending,
+				Op:        rule.Op,
+				Threshold: rule.Threshold,
+				ActiveAt:  now,
+			}
+			e.alerts[key] = a
+		}
+		a.Value = s.Value
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= time.Duration(rule.For) {
+			firedAt := now
+			a.State = StateFiring
+			a.FiredAt = &firedAt
+			changed = append(changed, *a)
+		} else if created {
+			changed = append(changed, *a)
+		}
+	}
+
+	for key, a := range e.alerts {
+		if key.rule != rule.Name || a.State == StateResolved {
+			continue
+		}
+		if _, ok := active[key.series]; ok {
+			continue
+		}
+		if a.State == StatePending {
+			// alert which hasn't fired just disappears
+			delete(e.alerts, key)
+			continue
+		}
+		resolvedAt := now
+		a.State = StateResolved
+		a.ResolvedAt = &resolvedAt
+		changed = append(changed, *a)
+	}
+	return changed
+}
+
+// Alerts returns copies of alerts in given states, all alerts if states are empty.
+// Alerts are sorted by rule and series
+func (e *Engine) Alerts(states ...State) []Alert {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	res := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		if len(states) > 0 && !hasState(states, a.State) {
+			continue
+		}
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Rule != res[j].Rule {
+			return res[i].Rule < res[j].Rule
+		}
+		return res[i].Series < res[j].Series
+	})
+	return res
+}
+
+func hasState(states []State, s State) bool {
+	for _, el := range states {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package alert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSource struct {
+	*memstorage.MemStorage
+	*history.Store
+}
+
+func (s testSource) Samples(_ context.Context, mtype, id string, from time.Time) ([]history.Sample, error) {
+	return s.Store.Samples(mtype, id, from), nil
+}
+
+func newRule(t *testing.T, r Rule) Rule {
+	require.NoError(t, r.Validate())
+	return r
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	st := memstorage.NewMemStorage()
+	st.Gauges["FreeMemory"] = 100
+	st.Gauges[`disk_free{host="a"}`] = 5
+	st.Gauges[`disk_free{host="b"}`] = 50
+	e := NewEngine([]Rule{
+		newRule(t, Rule{Name: "NoMemory", Query: "FreeMemory", Op: "<", Threshold: 10, For: Duration(time.Minute), Severity: "critical"}),
+		newRule(t, Rule{Name: "DiskFull", Query: "disk_free", Op: "<=", Threshold: 10, Severity: "warning"}),
+	}, testSource{MemStorage: st, Store: history.New(10)})
+
+	ctx := context.Background()
+	start := time.Unix(10000, 0)
+	states := func() map[string]State {
+		res := make(map[string]State)
+		for _, a := range e.Alerts() {
+			res[a.Rule+" "+a.Series] = a.State
+		}
+		return res
+	}
+
+	// rule without for duration fires immediately
+	require.NoError(t, e.Evaluate(ctx, start))
+	assert.Equal(t, map[string]State{`DiskFull disk_free{host="a"}`: StateFiring}, states())
+
+	st.Gauges["FreeMemory"] = 0
+	require.NoError(t, e.Evaluate(ctx, start.Add(10*time.Second)))
+	assert.Equal(t, StatePending, states()["NoMemory FreeMemory"])
+
+	require.NoError(t, e.Evaluate(ctx, start.Add(40*time.Second)))
+	assert.Equal(t, StatePending, states()["NoMemory FreeMemory"])
+
+	require.NoError(t, e.Evaluate(ctx, start.Add(70*time.Second)))
+	assert.Equal(t, StateFiring, states()["NoMemory FreeMemory"])
+	firing := e.Alerts(StateFiring)
+	require.Len(t, firing, 2)
+	assert.Equal(t, "critical", firing[1].Severity)
+	assert.Equal(t, float64(0), firing[1].Value)
+	assert.Equal(t, start.Add(10*time.Second), firing[1].ActiveAt)
+
+	// condition is false now
+	st.Gauges["FreeMemory"] = 100
+	st.Gauges[`disk_free{host="a"}`] = 50
+	require.NoError(t, e.Evaluate(ctx, start.Add(80*time.Second)))
+	assert.Equal(t, map[string]State{
+		`DiskFull disk_free{host="a"}`: StateResolved,
+		"NoMemory FreeMemory":          StateResolved,
+	}, states())
+	assert.Empty(t, e.Alerts(StatePending, StateFiring))
+
+	// pending alert disappears when condition becomes false
+	st.Gauges["FreeMemory"] = 0
+	require.NoError(t, e.Evaluate(ctx, start.Add(90*time.Second)))
+	assert.Equal(t, StatePending, states()["NoMemory FreeMemory"])
+	st.Gauges["FreeMemory"] = 100
+	require.NoError(t, e.Evaluate(ctx, start.Add(100*time.Second)))
+	assert.Empty(t, e.Alerts(StatePending))
+
+	// resolved alerts are forgotten after retention
+	require.NoError(t, e.Evaluate(ctx, start.Add(80*time.Second+resolvedRetention+time.Second)))
+	assert.Empty(t, e.Alerts())
+}
+
+func TestEngine_OnChange(t *testing.T) {
+	st := memstorage.NewMemStorage()
+	st.Gauges["FreeMemory"] = 0
+	e := NewEngine([]Rule{
+		newRule(t, Rule{Name: "NoMemory", Query: "FreeMemory", Op: "<", Threshold: 10, For: Duration(time.Minute)}),
+	}, testSource{MemStorage: st, Store: history.New(10)})
+	var changes []State
+	e.OnChange(func(a Alert) {
+		changes = append(changes, a.State)
+	})
+
+	ctx := context.Background()
+	start := time.Unix(10000, 0)
+	require.NoError(t, e.Evaluate(ctx, start))
+	require.NoError(t, e.Evaluate(ctx, start.Add(30*time.Second)))
+	require.NoError(t, e.Evaluate(ctx, start.Add(time.Minute)))
+	require.NoError(t, e.Evaluate(ctx, start.Add(90*time.Second)))
+	st.Gauges["FreeMemory"] = 100
+	require.NoError(t, e.Evaluate(ctx, start.Add(2*time.Minute)))
+	require.NoError(t, e.Evaluate(ctx, start.Add(3*time.Minute)))
+
+	assert.Equal(t, []State{StatePending, StateFiring, StateResolved}, changes)
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	rules, err := LoadRules(write(`{"rules": [
+		{"name": "NoMemory", "query": "FreeMemory", "op": "<", "threshold": 1e6, "for": "5m", "severity": "critical"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, Duration(5*time.Minute), rules[0].For)
+	assert.NotNil(t, rules[0].expr)
+
+	invalid := []string{
+		`{"rules": [{"name": "A", "query": "x", "op": "~", "threshold": 1}]}`,
+		`{"rules": [{"name": "A", "query": "sum(", "op": ">", "threshold": 1}]}`,
+		`{"rules": [{"name": "A", "query": "x", "op": ">", "for": "soon"}]}`,
+		`{"rules": [{"query": "x", "op": ">"}]}`,
+		`{"rules": [{"name": "A", "query": "x", "op": ">"}, {"name": "A", "query": "y", "op": ">"}]}`,
+	}
+	for _, content := range invalid {
+		_, err := LoadRules(write(content))
+		assert.Error(t, err, content)
+	}
+}
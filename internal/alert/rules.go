@@ -0,0 +1,107 @@
+// Package alert evaluates threshold alerting rules over metrics
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/query"
+)
+
+// comparison operators of rules
+var comparisons = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Duration is a time.Duration written in config as string, e.g. "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule fires alert for each series of query which value satisfies comparison
+// with threshold during For duration
+type Rule struct {
+	Name string `json:"name"`
+	// selector or any other query, e.g. FreeMemory or HeapAlloc / HeapSys
+	Query string `json:"query"`
+	// one of > >= < <= == !=
+	Op        string   `json:"op"`
+	Threshold float64  `json:"threshold"`
+	For       Duration `json:"for"`
+	Severity  string   `json:"severity"`
+	// short description of alert for humans
+	Summary string `json:"summary,omitempty"`
+
+	expr query.Expr
+}
+
+// RulesFile is a format of file with rules
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Validate checks rule and parses its query
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule without name")
+	}
+	if _, ok := comparisons[r.Op]; !ok {
+		return fmt.Errorf("rule %s: unknown comparison %q", r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("rule %s: negative for duration", r.Name)
+	}
+	expr, err := query.Parse(r.Query)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", r.Name, err)
+	}
+	r.expr = expr
+	return nil
+}
+
+// LoadRules reads rules from JSON file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file RulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(file.Rules))
+	for i := range file.Rules {
+		if err := file.Rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[file.Rules[i].Name]; ok {
+			return nil, fmt.Errorf("duplicate rule %s", file.Rules[i].Name)
+		}
+		names[file.Rules[i].Name] = struct{}{}
+	}
+	return file.Rules, nil
+}
@@ -0,0 +1,182 @@
+// Package anomaly detects sudden deviations of gauges from their baselines
+package anomaly
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// std deviation is never less than this, so spike on flat series has finite score
+const minStdDev = 1e-9
+
+var (
+	errInvalidAlpha = errors.New("alpha must be in (0, 1]")
+	errInvalidK     = errors.New("k must be positive")
+)
+
+// Config is a settings of detector
+type Config struct {
+	// regular expression of names of gauges to watch
+	Pattern string
+	// smoothing factor of EWMA in (0, 1], bigger value forgets history faster
+	Alpha float64
+	// point is anomalous if it deviates from mean by more than K std deviations
+	K float64
+	// count of points to learn baseline before detection starts
+	Warmup int
+	// count of the last anomalies kept
+	Capacity int
+}
+
+// DefaultConfig returns config which watches all gauges
+func DefaultConfig() Config {
+	return Config{
+		Pattern:  ".*",
+		Alpha:    0.1,
+		K:        3,
+		Warmup:   10,
+		Capacity: 1000,
+	}
+}
+
+// Anomaly is a point which deviates from baseline of series
+type Anomaly struct {
+	Series string    `json:"series"`
+	Time   time.Time `json:"time"`
+	Value  float64   `json:"value"`
+	// baseline before the point
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	// deviation from mean in std deviations, negative for dips
+	Score float64 `json:"score"`
+}
+
+// baseline is an exponentially weighted mean and variance of series
+type baseline struct {
+	mean     float64
+	variance float64
+	count    int
+	// score of the last point
+	score float64
+}
+
+// Detector keeps baselines of gauges and detects anomalies. It is safe for concurrent use
+type Detector struct {
+	cfg     Config
+	pattern *regexp.Regexp
+
+	mx        sync.RWMutex
+	baselines map[string]*baseline
+	// the last anomalies from the oldest to the newest
+	anomalies []Anomaly
+}
+
+// New creates detector, it returns error if pattern or parameters are invalid
+func New(cfg Config) (*Detector, error) {
+	pattern, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		return nil, errInvalidAlpha
+	}
+	if cfg.K <= 0 {
+		return nil, errInvalidK
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultConfig().Capacity
+	}
+	return &Detector{
+		cfg:       cfg,
+		pattern:   pattern,
+		baselines: make(map[string]*baseline),
+	}, nil
+}
+
+// Watches returns true if detector watches gauge id
+func (d *Detector) Watches(id string) bool {
+	return d.pattern.MatchString(id)
+}
+
+// Observe checks new value of gauge id against its baseline and updates baseline.
+// It returns anomaly if value deviates by more than K std deviations
+func (d *Detector) Observe(id string, t time.Time, v float64) (Anomaly, bool) {
+	return d.ObserveKey(id, id, t, v)
+}
+
+// ObserveKey is Observe of gauge name which baseline and anomalies are kept by key,
+// so gauges of different owners with the same name are matched by pattern but not mixed
+func (d *Detector) ObserveKey(key, name string, t time.Time, v float64) (Anomaly, bool) {
+	if !d.Watches(name) || math.IsNaN(v) || math.IsInf(v, 0) {
+		return Anomaly{}, false
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	b, ok := d.baselines[key]
+	if !ok {
+		b = &baseline{mean: v}
+		d.baselines[key] = b
+	}
+
+	std := math.Max(math.Sqrt(b.variance), minStdDev)
+	b.score = 0
+	if b.count >= d.cfg.Warmup {
+		b.score = (v - b.mean) / std
+	}
+	anomaly := Anomaly{
+		Series: key,
+		Time:   t,
+		Value:  v,
+		Mean:   b.mean,
+		StdDev: math.Sqrt(b.variance),
+		Score:  b.score,
+	}
+
+	// incremental EWMA of mean and variance
+	diff := v - b.mean
+	incr := d.cfg.Alpha * diff
+	b.mean += incr
+	b.variance = (1 - d.cfg.Alpha) * (b.variance + diff*incr)
+	b.count++
+
+	if math.Abs(anomaly.Score) <= d.cfg.K {
+		return Anomaly{}, false
+	}
+	d.anomalies = append(d.anomalies, anomaly)
+	if extra := len(d.anomalies) - d.cfg.Capacity; extra > 0 {
+		d.anomalies = append(d.anomalies[:0], d.anomalies[extra:]...)
+	}
+	return anomaly, true
+}
+
+// Score returns score of the last point of gauge id, false if gauge isn't watched
+// or has no points
+func (d *Detector) Score(id string) (float64, bool) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	b, ok := d.baselines[id]
+	if !ok {
+		return 0, false
+	}
+	return b.score, true
+}
+
+// Anomalies returns anomalies detected since from, from the oldest to the newest
+func (d *Detector) Anomalies(from time.Time) []Anomaly {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	res := make([]Anomaly, 0)
+	for _, a := range d.anomalies {
+		if !a.Time.Before(from) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
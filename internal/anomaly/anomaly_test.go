@@ -0,0 +1,97 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetector_Observe(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Pattern = "^Heap"
+	d, err := New(cfg)
+	require.NoError(t, err)
+
+	start := time.Unix(10000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * 10 * time.Second) }
+	// noisy series around 1000
+	i := 0
+	for ; i < 50; i++ {
+		_, ok := d.Observe("HeapAlloc", at(i), 1000+float64(i%5)*10)
+		require.False(t, ok, "point %d", i)
+	}
+
+	a, ok := d.Observe("HeapAlloc", at(i), 5000)
+	require.True(t, ok)
+	assert.Equal(t, "HeapAlloc", a.Series)
+	assert.Equal(t, at(i), a.Time)
+	assert.Greater(t, a.Score, cfg.K)
+	assert.InDelta(t, 1020, a.Mean, 10)
+
+	score, ok := d.Score("HeapAlloc")
+	require.True(t, ok)
+	assert.Equal(t, a.Score, score)
+
+	i++
+	a, ok = d.Observe("HeapAlloc", at(i), -5000)
+	require.True(t, ok)
+	assert.Less(t, a.Score, -cfg.K)
+
+	// gauges which aren't watched are ignored
+	for j := 0; j < 20; j++ {
+		_, ok = d.Observe("Alloc", at(j), 1)
+		assert.False(t, ok)
+	}
+	_, ok = d.Observe("Alloc", at(21), 1e9)
+	assert.False(t, ok)
+	_, ok = d.Score("Alloc")
+	assert.False(t, ok)
+
+	assert.Len(t, d.Anomalies(start), 2)
+	assert.Len(t, d.Anomalies(at(i)), 1)
+}
+
+func TestDetector_Warmup(t *testing.T) {
+	d, err := New(DefaultConfig())
+	require.NoError(t, err)
+
+	start := time.Unix(10000, 0)
+	for i, v := range []float64{1, 100, 1, 1000, 5} {
+		_, ok := d.Observe("Alloc", start.Add(time.Duration(i)*time.Second), v)
+		assert.False(t, ok)
+	}
+}
+
+func TestDetector_Capacity(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Warmup = 1
+	cfg.Capacity = 3
+	d, err := New(cfg)
+	require.NoError(t, err)
+
+	start := time.Unix(10000, 0)
+	d.Observe("Alloc", start, 1)
+	// each next point is far from slowly moving baseline
+	for i, v := range []float64{1e1, 1e2, 1e3, 1e4, 1e5} {
+		_, ok := d.Observe("Alloc", start.Add(time.Duration(i+1)*time.Second), v)
+		require.True(t, ok)
+	}
+	list := d.Anomalies(start)
+	require.Len(t, list, 3)
+	assert.Equal(t, 1e3, list[0].Value)
+	assert.Equal(t, 1e5, list[2].Value)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Pattern: "(", Alpha: 0.1, K: 3},
+		{Pattern: ".*", Alpha: 0, K: 3},
+		{Pattern: ".*", Alpha: 1.5, K: 3},
+		{Pattern: ".*", Alpha: 0.1, K: 0},
+	} {
+		_, err := New(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
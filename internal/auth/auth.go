@@ -0,0 +1,174 @@
+// Package auth authenticates clients by bearer tokens. Each token has a name,
+// usually one token per agent, and scopes of allowed requests. Only SHA-256 hashes
+// of tokens are kept in file of tokens, so the file doesn't disclose tokens
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Scope is a kind of requests allowed by token
+type Scope string
+
+const (
+	// ScopePush allows writes of metrics
+	ScopePush Scope = "push"
+	// ScopeRead allows reads of metrics
+	ScopeRead Scope = "read"
+	// ScopeAdmin allows administration of server
+	ScopeAdmin Scope = "admin"
+)
+
+// Header carries token in HTTP requests as "Bearer <token>", the same key is used in gRPC metadata
+const Header = "Authorization"
+
+const bearerPrefix = "Bearer "
+
+var (
+	ErrNoToken      = errors.New("token isn't set")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Token is a settings of token
+type Token struct {
+	// name of client, it identifies client in logs and limits
+	Name string `json:"name"`
+	// hex encoded SHA-256 hash of token, see Hash
+	SHA256 string  `json:"sha256"`
+	Scopes []Scope `json:"scopes"`
+	// tenant which metrics token writes and reads, empty means any tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// TokensFile is a format of file with tokens
+type TokensFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// Allows returns true if token has scope
+func (t *Token) Allows(scope Scope) bool {
+	for _, el := range t.Scopes {
+		if el == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks settings of token
+func (t *Token) Validate() error {
+	if t.Name == "" {
+		return errors.New("name of token isn't set")
+	}
+	if b, err := hex.DecodeString(t.SHA256); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("token %s: invalid sha256", t.Name)
+	}
+	for _, el := range t.Scopes {
+		if el != ScopePush && el != ScopeRead && el != ScopeAdmin {
+			return fmt.Errorf("token %s: unknown scope %q", t.Name, el)
+		}
+	}
+	return nil
+}
+
+// Hash returns hex encoded SHA-256 hash of token
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// BearerToken returns token from value of Authorization header
+func BearerToken(header string) (string, error) {
+	token, ok := strings.CutPrefix(header, bearerPrefix)
+	if !ok || token == "" {
+		return "", ErrNoToken
+	}
+	return token, nil
+}
+
+// Bearer returns value of Authorization header with token
+func Bearer(token string) string {
+	return bearerPrefix + token
+}
+
+// Store keeps tokens loaded from file
+type Store struct {
+	path string
+
+	mx     sync.RWMutex
+	tokens map[string]Token
+}
+
+// NewStore loads tokens from JSON file
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads file of tokens again, current tokens are kept if file is invalid
+func (s *Store) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var file TokensFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	tokens := make(map[string]Token, len(file.Tokens))
+	names := make(map[string]struct{}, len(file.Tokens))
+	for _, t := range file.Tokens {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicate token %s", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		tokens[strings.ToLower(t.SHA256)] = t
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.tokens = tokens
+	return nil
+}
+
+// Authenticate returns settings of token
+func (s *Store) Authenticate(token string) (Token, error) {
+	if token == "" {
+		return Token{}, ErrNoToken
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	t, ok := s.tokens[Hash(token)]
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+	return t, nil
+}
+
+type ctxKey struct{}
+
+// WithToken returns context of requests authenticated by token t
+func WithToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns token set by WithToken
+func FromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(ctxKey{}).(Token)
+	return t, ok
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokens(t *testing.T, path string, tokens ...Token) {
+	data, err := json.Marshal(TokensFile{Tokens: tokens})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+}
+
+func TestStore_Authenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, path,
+		Token{Name: "agent-1", SHA256: Hash("secret-1"), Scopes: []Scope{ScopePush}},
+		Token{Name: "grafana", SHA256: Hash("secret-2"), Scopes: []Scope{ScopeRead, ScopeAdmin}},
+	)
+	s, err := NewStore(path)
+	require.NoError(t, err)
+
+	tok, err := s.Authenticate("secret-1")
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", tok.Name)
+	assert.True(t, tok.Allows(ScopePush))
+	assert.False(t, tok.Allows(ScopeRead))
+
+	_, err = s.Authenticate("")
+	assert.ErrorIs(t, err, ErrNoToken)
+	_, err = s.Authenticate("secret-3")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// leaked token of one agent is revoked by reload
+	writeTokens(t, path, Token{Name: "grafana", SHA256: Hash("secret-2"), Scopes: []Scope{ScopeRead}})
+	require.NoError(t, s.Reload())
+	_, err = s.Authenticate("secret-1")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	tok, err = s.Authenticate("secret-2")
+	require.NoError(t, err)
+	assert.False(t, tok.Allows(ScopeAdmin))
+
+	// invalid file doesn't replace tokens
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	assert.Error(t, s.Reload())
+	_, err = s.Authenticate("secret-2")
+	assert.NoError(t, err)
+}
+
+func TestToken_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		token Token
+	}{
+		{name: "no name", token: Token{SHA256: Hash("a")}},
+		{name: "invalid hash", token: Token{Name: "a", SHA256: "abc"}},
+		{name: "unknown scope", token: Token{Name: "a", SHA256: Hash("a"), Scopes: []Scope{"write"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.token.Validate())
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	token, err := BearerToken(Bearer("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", token)
+	_, err = BearerToken("Basic abc")
+	assert.ErrorIs(t, err, ErrNoToken)
+}
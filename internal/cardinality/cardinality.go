@@ -0,0 +1,60 @@
+// Package cardinality protects storage from clients which create too many series:
+// total count of series, count of new series per minute of each client
+// and size of one batch are limited
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLimit is a base error of rejected writes
+	ErrLimit = errors.New("cardinality limit is exceeded")
+	// ErrSeriesLimit is returned if write creates more series than total limit
+	ErrSeriesLimit = fmt.Errorf("%w: too many series", ErrLimit)
+	// ErrNewSeriesRate is returned if client creates too many new series during one minute
+	ErrNewSeriesRate = fmt.Errorf("%w: too many new series of client", ErrLimit)
+	// ErrBatchSize is returned for too large batch
+	ErrBatchSize = fmt.Errorf("%w: batch is too large", ErrLimit)
+)
+
+// Limits is a settings of limits, 0 means no limit
+type Limits struct {
+	// max count of series in storage
+	MaxSeries int
+	// max count of new series created by one client during one minute
+	MaxNewSeriesPerMinute int
+	// max count of metrics in one batch
+	MaxBatchSize int
+}
+
+// Validate checks limits
+func (l Limits) Validate() error {
+	if l.MaxSeries < 0 || l.MaxNewSeriesPerMinute < 0 || l.MaxBatchSize < 0 {
+		return errors.New("cardinality limits can't be negative")
+	}
+	return nil
+}
+
+// CheckBatch checks size of batch
+func (l Limits) CheckBatch(n int) error {
+	if l.MaxBatchSize > 0 && n > l.MaxBatchSize {
+		return fmt.Errorf("%w: %d metrics, max %d", ErrBatchSize, n, l.MaxBatchSize)
+	}
+	return nil
+}
+
+type ctxKey struct{}
+
+// WithClient returns context of requests of client, usually client is identified by its IP
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, client)
+}
+
+// ClientFromContext returns client set by WithClient, empty string for internal writes of server
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(ctxKey{}).(string)
+	return client
+}
@@ -0,0 +1,223 @@
+package cardinality
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// window of rate of new series
+const rateWindow = time.Minute
+
+// Storage checks limits of series before writes to inner storage. Writes of clients
+// set by WithClient are limited, internal writes of server without client are only counted.
+// Batch which exceeds limits is rejected entirely.
+// It implements storage.Storage
+type Storage struct {
+	inner  storage.Storage
+	limits Limits
+	now    func() time.Time
+
+	// new series are reserved under lock, so concurrent writes can't exceed limits
+	mx sync.Mutex
+	// known series, they are loaded from inner storage on the first write
+	series map[storage.Cursor]struct{}
+	// reserved series which aren't written yet and count of writes in flight which contain them
+	pending map[storage.Cursor]int
+	loaded  bool
+	// new series of clients in current windows
+	clients map[string]*clientWindow
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// clientWindow is a count of new series of client since start of window
+type clientWindow struct {
+	start time.Time
+	count int
+}
+
+// NewStorage creates storage which checks limits of series
+func NewStorage(inner storage.Storage, limits Limits) *Storage {
+	return &Storage{
+		inner:   inner,
+		limits:  limits,
+		now:     time.Now,
+		series:  make(map[storage.Cursor]struct{}),
+		pending: make(map[storage.Cursor]int),
+		clients: make(map[string]*clientWindow),
+	}
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.inner.Ping(ctx)
+}
+
+func (s *Storage) Update(ctx context.Context, t string, n string, v string) error {
+	return s.write(ctx, []metrics.Metric{{ID: n, MType: t}}, func() error {
+		return s.inner.Update(ctx, t, n, v)
+	})
+}
+
+func (s *Storage) UpdateNew(ctx context.Context, t string, n string, delta *int64, value *float64) error {
+	return s.write(ctx, []metrics.Metric{{ID: n, MType: t}}, func() error {
+		return s.inner.UpdateNew(ctx, t, n, delta, value)
+	})
+}
+
+func (s *Storage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	return s.write(ctx, m, func() error {
+		return s.inner.UpdateBatch(ctx, m)
+	})
+}
+
+func (s *Storage) GetValue(ctx context.Context, t string, n string) (any, error) {
+	return s.inner.GetValue(ctx, t, n)
+}
+
+func (s *Storage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
+	return s.inner.GetAllMetricsNew(ctx)
+}
+
+func (s *Storage) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	return s.inner.ListMetrics(ctx, f)
+}
+
+// Series returns count of known series
+func (s *Storage) Series(ctx context.Context) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if err := s.load(ctx); err != nil {
+		return 0, err
+	}
+	return len(s.series), nil
+}
+
+// write calls fn which writes metrics m if they don't exceed limits.
+// New series are reserved before fn and the reservation is rolled back if fn fails,
+// lock isn't held during fn
+func (s *Storage) write(ctx context.Context, m []metrics.Metric, fn func() error) error {
+	added, held, window, err := s.reserve(ctx, m)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	s.release(added, held, window, err == nil)
+	return err
+}
+
+// reserve checks limits and reserves new series of metrics m. It returns new series,
+// all not yet written series which the write contains and window of client if it's limited
+func (s *Storage) reserve(ctx context.Context, m []metrics.Metric) (added, held []storage.Cursor, window *clientWindow, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return nil, nil, nil, err
+	}
+
+	seen := make(map[storage.Cursor]struct{}, len(m))
+	for _, el := range m {
+		k := storage.Cursor{MType: el.MType, ID: el.ID}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		if _, ok := s.series[k]; !ok {
+			added = append(added, k)
+		} else if s.pending[k] > 0 {
+			// series is reserved by other write which isn't finished yet
+			held = append(held, k)
+		}
+	}
+
+	client := ClientFromContext(ctx)
+	if client != "" && len(added) > 0 {
+		if s.limits.MaxSeries > 0 && len(s.series)+len(added) > s.limits.MaxSeries {
+			return nil, nil, nil, fmt.Errorf("%w: %d of %d series, %d new series are rejected",
+				ErrSeriesLimit, len(s.series), s.limits.MaxSeries, len(added))
+		}
+		if s.limits.MaxNewSeriesPerMinute > 0 {
+			window = s.window(client)
+			if window.count+len(added) > s.limits.MaxNewSeriesPerMinute {
+				return nil, nil, nil, fmt.Errorf("%w: client %s created %d of %d new series per minute, %d new series are rejected",
+					ErrNewSeriesRate, client, window.count, s.limits.MaxNewSeriesPerMinute, len(added))
+			}
+			window.count += len(added)
+		}
+	}
+
+	for _, k := range added {
+		s.series[k] = struct{}{}
+	}
+	held = append(held, added...)
+	for _, k := range held {
+		s.pending[k]++
+	}
+	return added, held, window, nil
+}
+
+// release finishes write of reserved series. Series written by any write become known,
+// series which all writes failed are removed and new series of failed write aren't counted by window
+func (s *Storage) release(added, held []storage.Cursor, window *clientWindow, written bool) {
+	if len(held) == 0 {
+		return
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, k := range held {
+		n, ok := s.pending[k]
+		switch {
+		case !ok:
+			// series is already written by other write
+		case written:
+			delete(s.pending, k)
+		case n > 1:
+			s.pending[k] = n - 1
+		default:
+			delete(s.pending, k)
+			delete(s.series, k)
+		}
+	}
+	if !written && window != nil {
+		window.count -= len(added)
+	}
+}
+
+// load reads known series from inner storage once
+func (s *Storage) load(ctx context.Context) error {
+	if s.loaded {
+		return nil
+	}
+	list, err := s.inner.GetAllMetricsNew(ctx)
+	if err != nil {
+		return err
+	}
+	for _, el := range list {
+		s.series[storage.Cursor{MType: el.MType, ID: el.ID}] = struct{}{}
+	}
+	s.loaded = true
+	return nil
+}
+
+// window returns current window of client, expired windows of all clients are dropped
+func (s *Storage) window(client string) *clientWindow {
+	now := s.now()
+	w, ok := s.clients[client]
+	if ok && now.Sub(w.start) < rateWindow {
+		return w
+	}
+	for c, el := range s.clients {
+		if now.Sub(el.start) >= rateWindow {
+			delete(s.clients, c)
+		}
+	}
+	w = &clientWindow{start: now}
+	s.clients[client] = w
+	return w
+}
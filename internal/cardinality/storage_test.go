@@ -0,0 +1,137 @@
+package cardinality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+)
+
+func gauges(prefix string, n int) []metrics.Metric {
+	m := make([]metrics.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		v := float64(i)
+		m = append(m, metrics.Metric{ID: fmt.Sprintf("%s_%d", prefix, i), MType: metrics.MetricTypeGauge, Value: &v})
+	}
+	return m
+}
+
+func TestStorage_MaxSeries(t *testing.T) {
+	inner := memstorage.NewMemStorage()
+	// series written before start are counted
+	require.NoError(t, inner.UpdateBatch(context.Background(), gauges("old", 2)))
+	s := NewStorage(inner, Limits{MaxSeries: 5})
+	ctx := WithClient(context.Background(), "10.0.0.1")
+
+	require.NoError(t, s.UpdateBatch(ctx, gauges("a", 3)))
+	// updates of known series aren't limited
+	require.NoError(t, s.UpdateBatch(ctx, gauges("a", 3)))
+	require.NoError(t, s.Update(ctx, metrics.MetricTypeGauge, "old_0", "1.5"))
+
+	err := s.UpdateBatch(ctx, gauges("b", 2))
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	assert.ErrorIs(t, err, ErrLimit)
+	// rejected batch isn't written
+	_, err = inner.GetValue(context.Background(), metrics.MetricTypeGauge, "b_0")
+	assert.Error(t, err)
+
+	// internal writes of server aren't limited
+	require.NoError(t, s.UpdateBatch(context.Background(), gauges("c", 2)))
+	n, err := s.Series(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 7, n)
+}
+
+func TestStorage_MaxNewSeriesPerMinute(t *testing.T) {
+	s := NewStorage(memstorage.NewMemStorage(), Limits{MaxNewSeriesPerMinute: 3})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctxA := WithClient(context.Background(), "10.0.0.1")
+	ctxB := WithClient(context.Background(), "10.0.0.2")
+
+	require.NoError(t, s.UpdateBatch(ctxA, gauges("a", 3)))
+	value := 1.0
+	err := s.UpdateNew(ctxA, metrics.MetricTypeGauge, "a_new", nil, &value)
+	assert.ErrorIs(t, err, ErrNewSeriesRate)
+	// known series and other clients aren't affected
+	require.NoError(t, s.UpdateBatch(ctxA, gauges("a", 3)))
+	require.NoError(t, s.UpdateBatch(ctxB, gauges("b", 3)))
+
+	now = now.Add(rateWindow)
+	require.NoError(t, s.UpdateNew(ctxA, metrics.MetricTypeGauge, "a_new", nil, &value))
+}
+
+// hookStorage calls hook before each batch write to inner storage
+type hookStorage struct {
+	storage.Storage
+	hook func(m []metrics.Metric) error
+}
+
+func (s *hookStorage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	if err := s.hook(m); err != nil {
+		return err
+	}
+	return s.Storage.UpdateBatch(ctx, m)
+}
+
+func TestStorage_RollbackFailedWrite(t *testing.T) {
+	failure := errors.New("write failed")
+	inner := &hookStorage{Storage: memstorage.NewMemStorage(), hook: func([]metrics.Metric) error { return failure }}
+	s := NewStorage(inner, Limits{MaxSeries: 3, MaxNewSeriesPerMinute: 3})
+	ctx := WithClient(context.Background(), "10.0.0.1")
+
+	assert.ErrorIs(t, s.UpdateBatch(ctx, gauges("a", 3)), failure)
+	n, err := s.Series(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// reservation of failed write doesn't use limits
+	inner.hook = func([]metrics.Metric) error { return nil }
+	require.NoError(t, s.UpdateBatch(ctx, gauges("a", 3)))
+	n, err = s.Series(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func TestStorage_ConcurrentWrites(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	inner := &hookStorage{Storage: memstorage.NewMemStorage(), hook: func(m []metrics.Metric) error {
+		if m[0].ID == "slow_0" {
+			close(started)
+			<-unblock
+		}
+		return nil
+	}}
+	s := NewStorage(inner, Limits{MaxSeries: 4})
+	ctx := WithClient(context.Background(), "10.0.0.1")
+
+	slow := make(chan error, 1)
+	go func() { slow <- s.UpdateBatch(ctx, gauges("slow", 2)) }()
+	<-started
+
+	// slow write doesn't block other writes, but its series are reserved
+	require.NoError(t, s.UpdateBatch(ctx, gauges("fast", 2)))
+	assert.ErrorIs(t, s.UpdateBatch(ctx, gauges("other", 1)), ErrSeriesLimit)
+
+	close(unblock)
+	require.NoError(t, <-slow)
+	n, err := s.Series(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
+
+func TestLimits_CheckBatch(t *testing.T) {
+	assert.NoError(t, Limits{}.CheckBatch(1000))
+	assert.NoError(t, Limits{MaxBatchSize: 10}.CheckBatch(10))
+	assert.ErrorIs(t, Limits{MaxBatchSize: 10}.CheckBatch(11), ErrBatchSize)
+	assert.Error(t, Limits{MaxSeries: -1}.Validate())
+}
@@ -0,0 +1,218 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/hash"
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// Internal endpoints of node, they work only with local storage of node
+const (
+	PingPath    = "/cluster/ping"
+	UpdatesPath = "/cluster/updates"
+	MetricsPath = "/cluster/metrics"
+)
+
+// StatusError is a rejection of request by peer, status of peer is kept
+// so rejection can be returned to client with the same meaning
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v %v: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+// HTTPPeer is a node available by HTTP
+type HTTPPeer struct {
+	url     string
+	host    string
+	hashKey string
+	token   string
+	client  *http.Client
+}
+
+var _ StatePeer = (*HTTPPeer)(nil)
+
+// NewHTTPPeer creates peer with address Host[:Port] or URL, requests are signed with hashKey
+// and authenticated by bearer token if they aren't empty. Peer is called by HTTPS
+// with tlsConfig if it isn't nil
+func NewHTTPPeer(addr string, hashKey string, token string, tlsConfig *tls.Config) *HTTPPeer {
+	base := strings.TrimSuffix(addr, "/")
+	if !strings.Contains(base, "://") {
+		if tlsConfig != nil {
+			base = "https://" + base
+		} else {
+			base = "http://" + base
+		}
+	}
+	// address with port is required to find outbound ip
+	host := base[strings.Index(base, "://")+3:]
+	if u, err := url.Parse(base); err == nil && u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	client := &http.Client{}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return &HTTPPeer{
+		url:     base,
+		host:    host,
+		hashKey: hashKey,
+		token:   token,
+		client:  client,
+	}
+}
+
+func (p *HTTPPeer) Ping(ctx context.Context) error {
+	return p.do(ctx, http.MethodGet, PingPath, nil, nil)
+}
+
+func (p *HTTPPeer) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	return p.do(ctx, http.MethodPost, UpdatesPath, m, nil)
+}
+
+func (p *HTTPPeer) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	var res []*metrics.Metric
+	if err := p.do(ctx, http.MethodPost, MetricsPath, f, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *HTTPPeer) Samples(ctx context.Context, r SamplesRequest) ([]history.Sample, error) {
+	var res []history.Sample
+	if err := p.do(ctx, http.MethodPost, SamplesPath, r, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (p *HTTPPeer) AnomalyScore(ctx context.Context, r ScoreRequest) (ScoreResponse, error) {
+	var res ScoreResponse
+	err := p.do(ctx, http.MethodPost, ScorePath, r, &res)
+	return res, err
+}
+
+func (p *HTTPPeer) Anomalies(ctx context.Context, r AnomaliesRequest) ([]anomaly.Anomaly, error) {
+	var res []anomaly.Anomaly
+	if err := p.do(ctx, http.MethodPost, AnomaliesPath, r, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Watch reads stream of changes, each change is a JSON object on separate line
+func (p *HTTPPeer) Watch(ctx context.Context, r WatchRequest, fn func(Event)) error {
+	request, err := p.newRequest(ctx, http.MethodPost, WatchPath, r)
+	if err != nil {
+		return err
+	}
+	// compressed stream is buffered by server
+	request.Header.Set("Accept-Encoding", "identity")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := checkStatus(response); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(response.Body)
+	for {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		fn(e)
+	}
+}
+
+// do sends in as JSON and decodes response to out if it isn't nil
+func (p *HTTPPeer) do(ctx context.Context, method string, path string, in any, out any) error {
+	request, err := p.newRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkStatus(response); err != nil {
+		return err
+	}
+	if out == nil {
+		io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// newRequest creates signed and authenticated request with body in encoded as JSON
+func (p *HTTPPeer) newRequest(ctx context.Context, method string, path string, in any) (*http.Request, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, p.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if p.hashKey != "" && in != nil {
+		sign := hash.GetHashSHA256(string(body), p.hashKey)
+		request.Header.Set("HashSHA256", base64.URLEncoding.EncodeToString(sign))
+	}
+	if p.token != "" {
+		request.Header.Set(auth.Header, auth.Bearer(p.token))
+	}
+	localIP, err := ip.OutboundIP(p.host)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-Real-IP", localIP.String())
+	return request, nil
+}
+
+// checkStatus returns rejection of request by peer
+func checkStatus(response *http.Response) error {
+	if response.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(response.Body)
+		return &StatusError{Code: response.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
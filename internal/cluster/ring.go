@@ -0,0 +1,58 @@
+// Package cluster shares metrics between several server nodes: each metric
+// is owned by one node chosen by consistent hashing on its name
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is a count of points of each node on ring by default
+const DefaultReplicas = 128
+
+// Ring is a consistent hash ring of nodes. Adding or removing node moves
+// only metrics of its neighbours on ring
+type Ring struct {
+	nodes  []string
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing places each node on ring replicas times
+func NewRing(nodes []string, replicas int) *Ring {
+	r := &Ring{owners: make(map[uint32]string, len(nodes)*replicas)}
+	for _, node := range nodes {
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < replicas; i++ {
+			p := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+			// on collision the first node keeps point
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.owners[p] = node
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	sort.Strings(r.nodes)
+	return r
+}
+
+// Nodes returns sorted nodes of ring
+func (r *Ring) Nodes() []string {
+	return r.nodes
+}
+
+// Owner returns node which owns metric with name id, empty string if ring is empty
+func (r *Ring) Owner(id string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(id))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
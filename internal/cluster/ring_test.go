@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing_Owner(t *testing.T) {
+	nodes := []string{"node-c:8080", "node-a:8080", "node-b:8080"}
+	r := NewRing(nodes, DefaultReplicas)
+	assert.Equal(t, []string{"node-a:8080", "node-b:8080", "node-c:8080"}, r.Nodes())
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("metric_%d", i)
+		owner := r.Owner(id)
+		counts[owner]++
+		// order of nodes doesn't change owner
+		assert.Equal(t, owner, NewRing([]string{"node-a:8080", "node-b:8080", "node-c:8080"}, DefaultReplicas).Owner(id))
+	}
+	for _, node := range nodes {
+		assert.Greater(t, counts[node], 500, node)
+	}
+
+	assert.Equal(t, "", NewRing(nil, DefaultReplicas).Owner("metric"))
+}
+
+func TestRing_AddNode(t *testing.T) {
+	before := NewRing([]string{"node-a:8080", "node-b:8080", "node-c:8080"}, DefaultReplicas)
+	after := NewRing([]string{"node-a:8080", "node-b:8080", "node-c:8080", "node-d:8080"}, DefaultReplicas)
+
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("metric_%d", i)
+		if before.Owner(id) != after.Owner(id) {
+			// metrics move only to the new node
+			assert.Equal(t, "node-d:8080", after.Owner(id))
+			moved++
+		}
+	}
+	assert.Less(t, moved, 1500)
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+// Internal endpoints of node which share state kept in memory of node
+const (
+	SamplesPath   = "/cluster/samples"
+	ScorePath     = "/cluster/score"
+	AnomaliesPath = "/cluster/anomalies"
+	WatchPath     = "/cluster/watch"
+)
+
+// delay before watch of peer is restarted after error
+const watchRetryDelay = time.Second
+
+// StatePeer is a Peer which also shares state of metrics kept in memory of node:
+// history, anomaly scores and changes. Node keeps state only of metrics which it owns
+type StatePeer interface {
+	Peer
+	Samples(ctx context.Context, r SamplesRequest) ([]history.Sample, error)
+	AnomalyScore(ctx context.Context, r ScoreRequest) (ScoreResponse, error)
+	Anomalies(ctx context.Context, r AnomaliesRequest) ([]anomaly.Anomaly, error)
+	// Watch calls fn for each change of metrics selected by request until ctx is done or stream fails
+	Watch(ctx context.Context, r WatchRequest, fn func(Event)) error
+}
+
+// SamplesRequest selects samples of history of metric since From
+type SamplesRequest struct {
+	MType string    `json:"type"`
+	ID    string    `json:"id"`
+	From  time.Time `json:"from"`
+}
+
+// ScoreRequest selects anomaly score of gauge
+type ScoreRequest struct {
+	ID string `json:"id"`
+}
+
+// ScoreResponse is an anomaly score of gauge, Found is false if gauge isn't watched by detector
+type ScoreResponse struct {
+	Score float64 `json:"score"`
+	Found bool    `json:"found"`
+}
+
+// AnomaliesRequest selects anomalies detected since From
+type AnomaliesRequest struct {
+	From time.Time `json:"from"`
+}
+
+// WatchRequest selects changes of metrics by names or by prefix of name
+type WatchRequest struct {
+	Names  []string `json:"names,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+// Event is a change of metric applied by node
+type Event struct {
+	Metric metrics.Metric `json:"metric"`
+	Time   time.Time      `json:"time"`
+}
+
+var errNoState = errors.New("node doesn't share state")
+
+// statePeer returns peer which owns metric id
+func (s *Storage) statePeer(id string) (string, StatePeer, error) {
+	owner := s.ring.Owner(id)
+	p, ok := s.peers[owner].(StatePeer)
+	if !ok {
+		return owner, nil, fmt.Errorf("node %s: %w", owner, errNoState)
+	}
+	return owner, p, nil
+}
+
+// Samples returns samples of history of metric owned by other node
+func (s *Storage) Samples(ctx context.Context, r SamplesRequest) ([]history.Sample, error) {
+	owner, p, err := s.statePeer(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.Samples(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", owner, err)
+	}
+	return res, nil
+}
+
+// AnomalyScore returns anomaly score of gauge owned by other node
+func (s *Storage) AnomalyScore(ctx context.Context, r ScoreRequest) (ScoreResponse, error) {
+	owner, p, err := s.statePeer(r.ID)
+	if err != nil {
+		return ScoreResponse{}, err
+	}
+	res, err := p.AnomalyScore(ctx, r)
+	if err != nil {
+		return ScoreResponse{}, fmt.Errorf("node %s: %w", owner, err)
+	}
+	return res, nil
+}
+
+// PeerAnomalies returns anomalies detected by other nodes
+func (s *Storage) PeerAnomalies(ctx context.Context, r AnomaliesRequest) ([]anomaly.Anomaly, error) {
+	var mx sync.Mutex
+	var res []anomaly.Anomaly
+	nodes := make(map[string][]metrics.Metric, len(s.peers))
+	for node := range s.peers {
+		nodes[node] = nil
+	}
+	err := s.each(nodes, func(node string, _ []metrics.Metric) error {
+		p, ok := s.peers[node].(StatePeer)
+		if !ok {
+			return fmt.Errorf("node %s: %w", node, errNoState)
+		}
+		list, err := p.Anomalies(ctx, r)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node, err)
+		}
+		mx.Lock()
+		res = append(res, list...)
+		mx.Unlock()
+		return nil
+	})
+	return res, err
+}
+
+// WatchPeers calls fn for each change of metrics applied by other nodes until ctx is done.
+// fn is called concurrently. Watch of failed peer is restarted after onError is called,
+// so changes made while peer is unavailable are missed
+func (s *Storage) WatchPeers(ctx context.Context, r WatchRequest, fn func(Event), onError func(error)) {
+	var wg sync.WaitGroup
+	for node, peer := range s.peers {
+		p, ok := peer.(StatePeer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(node string, p StatePeer) {
+			defer wg.Done()
+			for {
+				err := p.Watch(ctx, r, fn)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil && onError != nil {
+					onError(fmt.Errorf("node %s: %w", node, err))
+				}
+				select {
+				case <-time.After(watchRetryDelay):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(node, p)
+	}
+	wg.Wait()
+}
+
+// Leader returns true if this node is the first available node of cluster in sorted order.
+// Leader does work which must be done once per cluster
+func (s *Storage) Leader(ctx context.Context) bool {
+	for _, node := range s.ring.Nodes() {
+		if node == s.self {
+			return true
+		}
+		if s.peers[node].Ping(ctx) == nil {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// Peer is a remote node of cluster. Peer works only with its local storage
+type Peer interface {
+	Ping(ctx context.Context) error
+	UpdateBatch(ctx context.Context, m []metrics.Metric) error
+	ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error)
+}
+
+// Storage routes writes of metrics to their owners and merges reads from all nodes.
+// It implements storage.Storage
+type Storage struct {
+	self  string
+	local storage.Storage
+	ring  *Ring
+	peers map[string]Peer
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// NewStorage creates storage of node self. Peers must contain all nodes of ring except self
+func NewStorage(self string, local storage.Storage, ring *Ring, peers map[string]Peer) (*Storage, error) {
+	found := false
+	for _, node := range ring.Nodes() {
+		if node == self {
+			found = true
+			continue
+		}
+		if _, ok := peers[node]; !ok {
+			return nil, fmt.Errorf("no peer for node %s", node)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("node %s isn't in cluster", self)
+	}
+	return &Storage{self: self, local: local, ring: ring, peers: peers}, nil
+}
+
+// Local returns storage of metrics owned by this node
+func (s *Storage) Local() storage.Storage {
+	return s.local
+}
+
+// Owns returns true if metric with name id is owned by this node
+func (s *Storage) Owns(id string) bool {
+	return s.ring.Owner(id) == s.self
+}
+
+// Ping checks local storage and all peers
+func (s *Storage) Ping(ctx context.Context) error {
+	errs := []error{s.local.Ping(ctx)}
+	for node, p := range s.peers {
+		if err := p.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Storage) Update(ctx context.Context, t string, n string, v string) error {
+	if s.Owns(n) {
+		return s.local.Update(ctx, t, n, v)
+	}
+	m := metrics.Metric{ID: n, MType: t}
+	switch t {
+	case metrics.MetricTypeCounter:
+		delta, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		m.Delta = &delta
+	case metrics.MetricTypeGauge:
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		m.Value = &value
+	default:
+		return errors.New("uknown metric type")
+	}
+	return s.UpdateBatch(ctx, []metrics.Metric{m})
+}
+
+func (s *Storage) UpdateNew(ctx context.Context, t string, n string, delta *int64, value *float64) error {
+	if s.Owns(n) {
+		return s.local.UpdateNew(ctx, t, n, delta, value)
+	}
+	return s.UpdateBatch(ctx, []metrics.Metric{{ID: n, MType: t, Delta: delta, Value: value}})
+}
+
+// UpdateBatch splits batch by owners and updates all nodes concurrently
+func (s *Storage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	parts := make(map[string][]metrics.Metric)
+	for _, el := range m {
+		owner := s.ring.Owner(el.ID)
+		parts[owner] = append(parts[owner], el)
+	}
+
+	return s.each(parts, func(node string, part []metrics.Metric) error {
+		if node == s.self {
+			return s.local.UpdateBatch(ctx, part)
+		}
+		if err := s.peers[node].UpdateBatch(ctx, part); err != nil {
+			return fmt.Errorf("node %s: %w", node, err)
+		}
+		return nil
+	})
+}
+
+func (s *Storage) GetValue(ctx context.Context, t string, n string) (any, error) {
+	if s.Owns(n) {
+		return s.local.GetValue(ctx, t, n)
+	}
+	if t != metrics.MetricTypeCounter && t != metrics.MetricTypeGauge {
+		return nil, errors.New("uknown metric type")
+	}
+	owner := s.ring.Owner(n)
+	list, err := s.peers[owner].ListMetrics(ctx, storage.Filter{
+		MType: t,
+		Regex: "^" + regexp.QuoteMeta(n) + "$",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", owner, err)
+	}
+	if len(list) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	if t == metrics.MetricTypeCounter {
+		return *list[0].Delta, nil
+	}
+	return *list[0].Value, nil
+}
+
+func (s *Storage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
+	return s.ListMetrics(ctx, storage.Filter{})
+}
+
+// ListMetrics requests the same filter from all nodes and merges results.
+// Each node returns at most Limit metrics after cursor, so merged page is complete
+func (s *Storage) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	matcher, err := storage.NewMatcher(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var mx sync.Mutex
+	var res []*metrics.Metric
+	nodes := make(map[string][]metrics.Metric, len(s.peers)+1)
+	for _, node := range s.ring.Nodes() {
+		nodes[node] = nil
+	}
+	err = s.each(nodes, func(node string, _ []metrics.Metric) error {
+		var list []*metrics.Metric
+		var err error
+		if node == s.self {
+			list, err = s.local.ListMetrics(ctx, f)
+		} else {
+			list, err = s.peers[node].ListMetrics(ctx, f)
+		}
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node, err)
+		}
+		mx.Lock()
+		res = append(res, list...)
+		mx.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return matcher.Less(storage.Cursor{MType: res[i].MType, ID: res[i].ID}, storage.Cursor{MType: res[j].MType, ID: res[j].ID})
+	})
+	if f.Limit > 0 && len(res) > f.Limit {
+		res = res[:f.Limit]
+	}
+	if res == nil {
+		res = []*metrics.Metric{}
+	}
+	return res, nil
+}
+
+// each calls fn for each node concurrently and joins errors
+func (s *Storage) each(parts map[string][]metrics.Metric, fn func(node string, part []metrics.Metric) error) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(parts))
+	for node, part := range parts {
+		wg.Add(1)
+		go func(node string, part []metrics.Metric) {
+			defer wg.Done()
+			errs <- fn(node, part)
+		}(node, part)
+	}
+	wg.Wait()
+	close(errs)
+
+	var res []error
+	for err := range errs {
+		res = append(res, err)
+	}
+	return errors.Join(res...)
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+)
+
+var testNodes = []string{"node-a:8080", "node-b:8080", "node-c:8080"}
+
+// newTestCluster returns storage of node-a and local storages of all nodes,
+// memstorage of other nodes is used as peer
+func newTestCluster(t *testing.T) (*Storage, map[string]*memstorage.MemStorage) {
+	locals := make(map[string]*memstorage.MemStorage, len(testNodes))
+	peers := make(map[string]Peer, len(testNodes))
+	for _, node := range testNodes {
+		locals[node] = memstorage.NewMemStorage()
+		if node != testNodes[0] {
+			peers[node] = locals[node]
+		}
+	}
+	s, err := NewStorage(testNodes[0], locals[testNodes[0]], NewRing(testNodes, DefaultReplicas), peers)
+	require.NoError(t, err)
+	return s, locals
+}
+
+func TestStorage_UpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	s, locals := newTestCluster(t)
+
+	var batch []metrics.Metric
+	for i := 0; i < 30; i++ {
+		delta := int64(i)
+		batch = append(batch, metrics.Metric{ID: fmt.Sprintf("counter_%d", i), MType: metrics.MetricTypeCounter, Delta: &delta})
+	}
+	require.NoError(t, s.UpdateBatch(ctx, batch))
+	require.NoError(t, s.Update(ctx, metrics.MetricTypeCounter, "counter_1", "10"))
+	value := 1.5
+	require.NoError(t, s.UpdateNew(ctx, metrics.MetricTypeGauge, "gauge", nil, &value))
+
+	// each metric is kept only by its owner
+	for node, local := range locals {
+		all, err := local.GetAllMetricsNew(ctx)
+		require.NoError(t, err)
+		assert.NotEmpty(t, all, node)
+		for _, el := range all {
+			assert.Equal(t, node, s.ring.Owner(el.ID), el.ID)
+		}
+	}
+
+	got, err := s.GetValue(ctx, metrics.MetricTypeCounter, "counter_1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), got)
+	got, err = s.GetValue(ctx, metrics.MetricTypeGauge, "gauge")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got)
+	_, err = s.GetValue(ctx, metrics.MetricTypeGauge, "counter_1")
+	assert.Error(t, err)
+
+	all, err := s.GetAllMetricsNew(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 31)
+}
+
+func TestStorage_ListMetrics(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestCluster(t)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.Update(ctx, metrics.MetricTypeGauge, fmt.Sprintf("gauge_%02d", i), "1"))
+	}
+
+	// pages merged from all nodes are complete and ordered
+	var names []string
+	f := storage.Filter{Prefix: "gauge_", Desc: true, Limit: 7}
+	for {
+		page, err := s.ListMetrics(ctx, f)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		for _, el := range page {
+			names = append(names, el.ID)
+		}
+		last := page[len(page)-1]
+		f.After = &storage.Cursor{MType: last.MType, ID: last.ID}
+	}
+	require.Len(t, names, 20)
+	for i, name := range names {
+		assert.Equal(t, fmt.Sprintf("gauge_%02d", 19-i), name)
+	}
+
+	_, err := s.ListMetrics(ctx, storage.Filter{MType: "unknown"})
+	assert.Error(t, err)
+}
+
+type failedPeer struct {
+	*memstorage.MemStorage
+}
+
+func (p failedPeer) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	return errors.New("node is down")
+}
+
+func (p failedPeer) Ping(ctx context.Context) error {
+	return errors.New("node is down")
+}
+
+func TestStorage_FailedPeer(t *testing.T) {
+	ctx := context.Background()
+	s, locals := newTestCluster(t)
+	s.peers[testNodes[1]] = failedPeer{locals[testNodes[1]]}
+
+	var batch []metrics.Metric
+	for i := 0; i < 30; i++ {
+		value := float64(i)
+		batch = append(batch, metrics.Metric{ID: fmt.Sprintf("gauge_%d", i), MType: metrics.MetricTypeGauge, Value: &value})
+	}
+	err := s.UpdateBatch(ctx, batch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), testNodes[1])
+	assert.Error(t, s.Ping(ctx))
+
+	// metrics of available nodes are applied
+	for _, el := range batch {
+		owner := s.ring.Owner(el.ID)
+		_, err := locals[owner].GetValue(ctx, el.MType, el.ID)
+		assert.Equal(t, owner != testNodes[1], err == nil, el.ID)
+	}
+}
+
+func TestStorage_Leader(t *testing.T) {
+	ctx := context.Background()
+	locals := map[string]Peer{}
+	for _, node := range testNodes {
+		locals[node] = memstorage.NewMemStorage()
+	}
+	storageOf := func(self string) *Storage {
+		peers := make(map[string]Peer)
+		for node, p := range locals {
+			if node != self {
+				peers[node] = p
+			}
+		}
+		s, err := NewStorage(self, memstorage.NewMemStorage(), NewRing(testNodes, DefaultReplicas), peers)
+		require.NoError(t, err)
+		return s
+	}
+
+	assert.True(t, storageOf(testNodes[0]).Leader(ctx))
+	assert.False(t, storageOf(testNodes[1]).Leader(ctx))
+
+	// the next available node becomes leader
+	locals[testNodes[0]] = failedPeer{memstorage.NewMemStorage()}
+	assert.True(t, storageOf(testNodes[1]).Leader(ctx))
+	assert.False(t, storageOf(testNodes[2]).Leader(ctx))
+}
+
+func TestNewStorage_Invalid(t *testing.T) {
+	ring := NewRing(testNodes, DefaultReplicas)
+	_, err := NewStorage("node-d:8080", memstorage.NewMemStorage(), ring, map[string]Peer{})
+	assert.Error(t, err)
+	_, err = NewStorage(testNodes[0], memstorage.NewMemStorage(), ring, map[string]Peer{testNodes[1]: memstorage.NewMemStorage()})
+	assert.Error(t, err)
+}
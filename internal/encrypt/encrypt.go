@@ -1,13 +1,52 @@
+// Package encrypt encrypts bodies of requests of agent by public RSA key of server.
+// Body is sealed into envelope: random AES-GCM data key encrypts body and RSA-OAEP
+// wraps the data key, so size of body isn't limited by size of RSA key.
+// Version of scheme is sent in VersionHeader, requests of old agents without header
+// are encrypted by RSA PKCS #1 v1.5 as a whole
 package encrypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
 )
 
+const (
+	// VersionHeader carries version of encryption scheme of request body
+	VersionHeader = "X-Encryption-Version"
+	// VersionPKCS1 is a body encrypted by RSA PKCS #1 v1.5 as a whole, see Encrypt.
+	// It's assumed for requests without VersionHeader
+	VersionPKCS1 = "1"
+	// VersionEnvelope is a body sealed into envelope, see EncryptEnvelope
+	VersionEnvelope = "2"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported encryption version")
+	ErrInvalidEnvelope    = errors.New("invalid envelope")
+)
+
+const (
+	// first byte of envelope
+	envelopeVersion byte = 2
+	// size of AES-256 data key
+	dataKeySize = 32
+	// size of header of envelope: version and length of wrapped key
+	envelopeHeaderSize = 3
+)
+
+// Encrypt encrypts plainText by RSA PKCS #1 v1.5, plainText can't be longer than
+// size of key minus 11 bytes.
+//
+// Deprecated: use EncryptEnvelope, Encrypt is kept to test compatibility with old agents
 func Encrypt(publicKeyPath, plainText string) (string, error) {
 	bytes, err := os.ReadFile(publicKeyPath)
 	if err != nil {
@@ -31,6 +70,9 @@ func convertBytesToPublicKey(keyBytes []byte) (*rsa.PublicKey, error) {
 	var err error
 
 	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, errors.New("public key isn't PEM encoded")
+	}
 	blockBytes := block.Bytes
 
 	cert, err := x509.ParseCertificate(blockBytes)
@@ -38,7 +80,11 @@ func convertBytesToPublicKey(keyBytes []byte) (*rsa.PublicKey, error) {
 		return nil, err
 	}
 
-	return cert.PublicKey.(*rsa.PublicKey), nil
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key isn't RSA key")
+	}
+	return publicKey, nil
 }
 
 func cipherToPemString(cipher []byte) string {
@@ -52,6 +98,7 @@ func cipherToPemString(cipher []byte) string {
 	)
 }
 
+// Decrypt decrypts message encrypted by Encrypt
 func Decrypt(privateKeyPath, encryptedMessage string) (string, error) {
 	bytes, err := os.ReadFile(privateKeyPath)
 	if err != nil {
@@ -76,6 +123,9 @@ func convertBytesToPrivateKey(keyBytes []byte) (*rsa.PrivateKey, error) {
 	var err error
 
 	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, errors.New("private key isn't PEM encoded")
+	}
 	blockBytes := block.Bytes
 
 	privateKey, err := x509.ParsePKCS1PrivateKey(blockBytes)
@@ -88,6 +138,127 @@ func convertBytesToPrivateKey(keyBytes []byte) (*rsa.PrivateKey, error) {
 
 func pemStringToCipher(encryptedMessage string) []byte {
 	b, _ := pem.Decode([]byte(encryptedMessage))
+	if b == nil {
+		return nil
+	}
 
 	return b.Bytes
 }
+
+// EncryptEnvelope seals plain into envelope by public key from certificate publicKeyPath
+func EncryptEnvelope(publicKeyPath string, plain []byte) ([]byte, error) {
+	bytes, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := convertBytesToPublicKey(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(publicKey, plain)
+}
+
+// DecryptEnvelope opens envelope made by EncryptEnvelope by private key privateKeyPath
+func DecryptEnvelope(privateKeyPath string, data []byte) ([]byte, error) {
+	bytes, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := convertBytesToPrivateKey(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(privateKey, data)
+}
+
+// DecryptVersion decrypts data encrypted by scheme version from VersionHeader,
+// empty version means VersionPKCS1
+func DecryptVersion(privateKeyPath, version string, data []byte) ([]byte, error) {
+	switch version {
+	case "", VersionPKCS1:
+		plain, err := Decrypt(privateKeyPath, string(data))
+		return []byte(plain), err
+	case VersionEnvelope:
+		return DecryptEnvelope(privateKeyPath, data)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedVersion, version)
+	}
+}
+
+// seal returns envelope: version byte, big-endian uint16 length of wrapped key,
+// data key wrapped by RSA-OAEP with SHA-256, nonce and plain sealed by AES-GCM.
+// Header of envelope is authenticated as additional data of AES-GCM
+func seal(publicKey *rsa.PublicKey, plain []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, envelopeHeaderSize, envelopeHeaderSize+len(wrapped)+gcm.NonceSize())
+	header[0] = envelopeVersion
+	binary.BigEndian.PutUint16(header[1:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, 0, len(header)+len(nonce)+len(plain)+gcm.Overhead())
+	res = append(res, header...)
+	res = append(res, nonce...)
+	return gcm.Seal(res, nonce, plain, header), nil
+}
+
+// open returns plain text of envelope made by seal
+func open(privateKey *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if len(data) < envelopeHeaderSize {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidEnvelope)
+	}
+	if data[0] != envelopeVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidEnvelope, data[0])
+	}
+	keyEnd := envelopeHeaderSize + int(binary.BigEndian.Uint16(data[1:]))
+	if len(data) < keyEnd {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidEnvelope)
+	}
+
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, data[envelopeHeaderSize:keyEnd], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceEnd := keyEnd + gcm.NonceSize()
+	if len(data) < nonceEnd {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidEnvelope)
+	}
+	plain, err := gcm.Open(nil, data[keyEnd:nonceEnd], data[nonceEnd:], data[:keyEnd])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,154 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeys writes certificate with public RSA key and private key
+// in the same format as tools/certs, returns their paths
+func writeKeys(t *testing.T, bits int) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	pub := filepath.Join(dir, "key.pub")
+	priv := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(pub, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600))
+	require.NoError(t, os.WriteFile(priv, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), 0600))
+	return pub, priv
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestEnvelope(t *testing.T) {
+	pub, priv := writeKeys(t, 2048)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty", size: 0},
+		{name: "small", size: 100},
+		// larger than RSA block
+		{name: "batch", size: 16 * 1024},
+		{name: "large", size: 4 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomBytes(t, tt.size)
+			sealed, err := EncryptEnvelope(pub, plain)
+			require.NoError(t, err)
+			assert.False(t, tt.size > 0 && bytes.Contains(sealed, plain))
+
+			got, err := DecryptVersion(priv, VersionEnvelope, sealed)
+			require.NoError(t, err)
+			assert.Equal(t, len(plain), len(got))
+			assert.True(t, bytes.Equal(plain, got))
+		})
+	}
+}
+
+func TestEnvelope_RandomDataKey(t *testing.T) {
+	pub, _ := writeKeys(t, 2048)
+	plain := []byte("the same body")
+	first, err := EncryptEnvelope(pub, plain)
+	require.NoError(t, err)
+	second, err := EncryptEnvelope(pub, plain)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestEnvelope_Invalid(t *testing.T) {
+	pub, priv := writeKeys(t, 2048)
+	_, otherPriv := writeKeys(t, 2048)
+
+	sealed, err := EncryptEnvelope(pub, randomBytes(t, 1024))
+	require.NoError(t, err)
+
+	tampered := func(i int) []byte {
+		b := bytes.Clone(sealed)
+		b[i] ^= 0xff
+		return b
+	}
+	tests := []struct {
+		name string
+		key  string
+		data []byte
+	}{
+		{name: "empty", key: priv, data: nil},
+		{name: "truncated header", key: priv, data: sealed[:2]},
+		{name: "truncated key", key: priv, data: sealed[:100]},
+		{name: "truncated body", key: priv, data: sealed[:len(sealed)-1]},
+		{name: "wrong version", key: priv, data: tampered(0)},
+		{name: "tampered key", key: priv, data: tampered(10)},
+		{name: "tampered body", key: priv, data: tampered(len(sealed) - 20)},
+		{name: "other key", key: otherPriv, data: sealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecryptEnvelope(tt.key, tt.data)
+			assert.ErrorIs(t, err, ErrInvalidEnvelope)
+		})
+	}
+}
+
+func TestDecryptVersion_PKCS1(t *testing.T) {
+	pub, priv := writeKeys(t, 2048)
+
+	// old agents encrypt small bodies without version header
+	encrypted, err := Encrypt(pub, "small body")
+	require.NoError(t, err)
+	for _, version := range []string{"", VersionPKCS1} {
+		got, err := DecryptVersion(priv, version, []byte(encrypted))
+		require.NoError(t, err)
+		assert.Equal(t, "small body", string(got))
+	}
+
+	// body larger than RSA block can't be encrypted by old scheme
+	_, err = Encrypt(pub, string(randomBytes(t, 1024)))
+	assert.Error(t, err)
+
+	_, err = DecryptVersion(priv, "3", []byte(encrypted))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestInvalidKeys(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a key"), 0600))
+
+	_, err := EncryptEnvelope(notPEM, []byte("body"))
+	assert.Error(t, err)
+	_, err = DecryptEnvelope(notPEM, []byte("body"))
+	assert.Error(t, err)
+	_, err = EncryptEnvelope(filepath.Join(t.TempDir(), "missing"), []byte("body"))
+	assert.Error(t, err)
+}
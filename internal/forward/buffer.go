@@ -0,0 +1,112 @@
+package forward
+
+import (
+	"sync"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+type key struct {
+	mtype string
+	id    string
+}
+
+// buffer keeps metrics waiting for sending, one entry per metric in order of arrival.
+// It is safe for concurrent use
+type buffer struct {
+	mx      sync.Mutex
+	entries map[key]*metrics.Metric
+	order   []key
+}
+
+func newBuffer() *buffer {
+	return &buffer{entries: make(map[key]*metrics.Metric)}
+}
+
+// add merges metrics into buffer and returns count of buffered metrics
+func (b *buffer) add(m []metrics.Metric) int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for _, el := range m {
+		k := key{el.MType, el.ID}
+		cur, ok := b.entries[k]
+		if !ok {
+			b.entries[k] = copyMetric(el)
+			b.order = append(b.order, k)
+			continue
+		}
+		if el.MType == metrics.MetricTypeCounter {
+			sum := deltaOf(cur) + deltaOf(&el)
+			cur.Delta = &sum
+		} else {
+			cur.Value = copyMetric(el).Value
+		}
+	}
+	return len(b.order)
+}
+
+// take removes up to n the oldest metrics from buffer
+func (b *buffer) take(n int) []metrics.Metric {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if n > len(b.order) {
+		n = len(b.order)
+	}
+	res := make([]metrics.Metric, 0, n)
+	for _, k := range b.order[:n] {
+		res = append(res, *b.entries[k])
+		delete(b.entries, k)
+	}
+	b.order = b.order[n:]
+	return res
+}
+
+// putBack returns batch which wasn't sent. Deltas of counters are added to buffered ones,
+// gauges are returned only if buffer has no newer value
+func (b *buffer) putBack(batch []metrics.Metric) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for _, el := range batch {
+		k := key{el.MType, el.ID}
+		cur, ok := b.entries[k]
+		if !ok {
+			b.entries[k] = copyMetric(el)
+			b.order = append(b.order, k)
+			continue
+		}
+		if el.MType == metrics.MetricTypeCounter {
+			sum := deltaOf(cur) + deltaOf(&el)
+			cur.Delta = &sum
+		}
+	}
+}
+
+func (b *buffer) len() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return len(b.order)
+}
+
+// copyMetric copies metric with its values, so caller can reuse them
+func copyMetric(m metrics.Metric) *metrics.Metric {
+	res := &metrics.Metric{ID: m.ID, MType: m.MType}
+	if m.Delta != nil {
+		delta := *m.Delta
+		res.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		res.Value = &value
+	}
+	return res
+}
+
+func deltaOf(m *metrics.Metric) int64 {
+	if m.Delta == nil {
+		return 0
+	}
+	return *m.Delta
+}
@@ -0,0 +1,225 @@
+// Package forward sends ingested metrics to upstream collector servers,
+// so a global collector can aggregate collectors of datacenters
+package forward
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/retry"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// Config is a settings of forwarder
+type Config struct {
+	// addresses of upstream servers: http://host:port or https://host:port for HTTP /updates/,
+	// grpc://host:port or grpcs://host:port for gRPC PushMetrics
+	Upstreams []string
+	// metrics selected by filter are forwarded
+	Filter storage.Filter
+	// key to sign HTTP requests, the same as KEY of upstream
+	HashKey string
+	// path to public RSA key of upstream if it accepts only encrypted HTTP requests
+	CryptoKey string
+	// bearer token of upstream if it authenticates clients
+	Token string
+	// TLS settings of https and grpcs upstreams, system CAs are used if nil
+	TLS *tls.Config
+	// max count of metrics in one request
+	BatchSize int
+	// interval between sending of buffered metrics
+	FlushInterval time.Duration
+	// count of attempts to send one batch
+	Attempts  uint
+	InitDelay time.Duration
+	Step      time.Duration
+}
+
+// DefaultConfig returns config with default batching and retry settings
+func DefaultConfig() Config {
+	return Config{
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		Attempts:      3,
+		InitDelay:     1000 * time.Millisecond,
+		Step:          2000 * time.Millisecond,
+	}
+}
+
+// sender delivers batch of metrics to upstream
+type sender interface {
+	send(ctx context.Context, batch []metrics.Metric) error
+	close() error
+}
+
+// upstream is a server with its own buffer, so unavailable upstream doesn't delay others
+type upstream struct {
+	target string
+	sender sender
+	buffer *buffer
+	// signals that buffer has full batch
+	full chan struct{}
+}
+
+// Forwarder buffers ingested metrics and sends them to upstreams in batches with retries.
+// Buffer keeps one entry per metric: deltas of counter are summed and
+// only the last value of gauge is kept, so buffer doesn't grow while upstream is down
+type Forwarder struct {
+	cfg       Config
+	matcher   *storage.Matcher
+	upstreams []*upstream
+}
+
+// New creates forwarder, Run must be called to send metrics
+func New(cfg Config) (*Forwarder, error) {
+	if len(cfg.Upstreams) == 0 {
+		return nil, errors.New("no upstreams to forward metrics")
+	}
+	if cfg.BatchSize <= 0 || cfg.FlushInterval <= 0 {
+		return nil, errors.New("batch size and flush interval must be positive")
+	}
+	matcher, err := storage.NewMatcher(cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forwarder{cfg: cfg, matcher: matcher}
+	for _, target := range cfg.Upstreams {
+		s, err := newSender(cfg, target)
+		if err != nil {
+			f.close()
+			return nil, err
+		}
+		f.upstreams = append(f.upstreams, &upstream{
+			target: target,
+			sender: s,
+			buffer: newBuffer(),
+			full:   make(chan struct{}, 1),
+		})
+	}
+	return f, nil
+}
+
+func newSender(cfg Config, target string) (sender, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upstream %s: missing host", target)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return newHTTPSender(cfg, u), nil
+	case "grpc":
+		return newGRPCSender(cfg, u.Host, false)
+	case "grpcs":
+		return newGRPCSender(cfg, u.Host, true)
+	}
+	return nil, fmt.Errorf("upstream %s: unknown scheme %q", target, u.Scheme)
+}
+
+// Forward adds metrics selected by filter to buffers of all upstreams, they are sent
+// after Run is called. Counters must contain deltas as they were ingested, not totals
+func (f *Forwarder) Forward(m []metrics.Metric) {
+	selected := make([]metrics.Metric, 0, len(m))
+	for _, el := range m {
+		if f.matcher.Match(el.MType, el.ID) {
+			selected = append(selected, el)
+		}
+	}
+	if len(selected) == 0 {
+		return
+	}
+	for _, u := range f.upstreams {
+		if u.buffer.add(selected) >= f.cfg.BatchSize {
+			select {
+			case u.full <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Pending returns count of buffered metrics for each upstream
+func (f *Forwarder) Pending() map[string]int {
+	res := make(map[string]int, len(f.upstreams))
+	for _, u := range f.upstreams {
+		res[u.target] = u.buffer.len()
+	}
+	return res
+}
+
+// Run sends buffered metrics until ctx is done, then tries to send the rest
+// during flushTimeout and closes connections. Errors are passed to onError
+func (f *Forwarder) Run(ctx context.Context, flushTimeout time.Duration, onError func(error)) {
+	var wg sync.WaitGroup
+	for _, u := range f.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			f.runUpstream(ctx, u, onError)
+
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			if err := f.flush(flushCtx, u); err != nil && onError != nil {
+				onError(err)
+			}
+		}(u)
+	}
+	wg.Wait()
+	f.close()
+}
+
+func (f *Forwarder) runUpstream(ctx context.Context, u *upstream, onError func(error)) {
+	ticker := time.NewTicker(f.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-u.full:
+		case <-ctx.Done():
+			return
+		}
+		if err := f.flush(ctx, u); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// flush sends buffered metrics to upstream by batches. Batch which wasn't sent
+// is returned to buffer and will be sent next time
+func (f *Forwarder) flush(ctx context.Context, u *upstream) error {
+	for {
+		batch := u.buffer.take(f.cfg.BatchSize)
+		if len(batch) == 0 {
+			return nil
+		}
+		err := retry.Do(func() error {
+			return u.sender.send(ctx, batch)
+		},
+			retry.Attempts(f.cfg.Attempts),
+			retry.InitDelay(f.cfg.InitDelay),
+			retry.Step(f.cfg.Step),
+			retry.LastErrorOnly(true),
+			retry.Context(ctx),
+		)
+		if err != nil {
+			u.buffer.putBack(batch)
+			return fmt.Errorf("forward to %s: %w", u.target, err)
+		}
+	}
+}
+
+func (f *Forwarder) close() {
+	for _, u := range f.upstreams {
+		u.sender.close()
+	}
+}
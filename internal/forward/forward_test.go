@@ -0,0 +1,324 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/encrypt"
+	"github.com/kvvPro/metric-collector/internal/hash"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig"
+	"github.com/kvvPro/metric-collector/internal/tlsconfig/tlstest"
+	pb "github.com/kvvPro/metric-collector/proto"
+	"github.com/kvvPro/metric-collector/tools/certs"
+)
+
+func counter(id string, delta int64) metrics.Metric {
+	return *metrics.NewCommonMetric(id, metrics.MetricTypeCounter, &delta, nil)
+}
+
+func gauge(id string, value float64) metrics.Metric {
+	return *metrics.NewCommonMetric(id, metrics.MetricTypeGauge, nil, &value)
+}
+
+// collected keeps totals of counters and the last values of gauges received by upstream
+type collected struct {
+	mx       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	batches  int
+}
+
+func newCollected() *collected {
+	return &collected{counters: make(map[string]int64), gauges: make(map[string]float64)}
+}
+
+func (c *collected) add(batch []metrics.Metric) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.batches++
+	for _, el := range batch {
+		if el.MType == metrics.MetricTypeCounter {
+			c.counters[el.ID] += *el.Delta
+		} else {
+			c.gauges[el.ID] = *el.Value
+		}
+	}
+}
+
+func (c *collected) snapshot() (map[string]int64, map[string]float64, int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	counters := make(map[string]int64, len(c.counters))
+	for k, v := range c.counters {
+		counters[k] = v
+	}
+	gauges := make(map[string]float64, len(c.gauges))
+	for k, v := range c.gauges {
+		gauges[k] = v
+	}
+	return counters, gauges, c.batches
+}
+
+// newHTTPUpstream returns upstream which fails first failures requests
+func newHTTPUpstream(t *testing.T, key string, failures int32) (*httptest.Server, *collected) {
+	c := newCollected()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, auth.Bearer("node"), r.Header.Get(auth.Header))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		sign := base64.URLEncoding.EncodeToString(hash.GetHashSHA256(string(body), key))
+		assert.Equal(t, sign, r.Header.Get("HashSHA256"))
+
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		var batch []metrics.Metric
+		require.NoError(t, json.NewDecoder(gz).Decode(&batch))
+		c.add(batch)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, c
+}
+
+func testConfig(upstreams ...string) Config {
+	cfg := DefaultConfig()
+	cfg.Upstreams = upstreams
+	cfg.HashKey = "secret"
+	cfg.Token = "node"
+	cfg.FlushInterval = 10 * time.Millisecond
+	cfg.InitDelay = time.Millisecond
+	cfg.Step = time.Millisecond
+	return cfg
+}
+
+func runForwarder(t *testing.T, f *Forwarder) (cancel func()) {
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx, time.Second, nil)
+		close(done)
+	}()
+	return func() {
+		stop()
+		<-done
+	}
+}
+
+func TestForwarder_HTTP(t *testing.T) {
+	upstream, got := newHTTPUpstream(t, "secret", 0)
+	cfg := testConfig(upstream.URL)
+	cfg.Filter = storage.Filter{Prefix: "app_"}
+	cfg.BatchSize = 2
+	f, err := New(cfg)
+	require.NoError(t, err)
+
+	f.Forward([]metrics.Metric{counter("app_requests", 2), gauge("app_heap", 10), gauge("HeapAlloc", 1)})
+	f.Forward([]metrics.Metric{counter("app_requests", 3), gauge("app_heap", 20), gauge("app_cpu", 0.5)})
+	stop := runForwarder(t, f)
+	defer stop()
+
+	// 3 metrics by 2 in batch
+	require.Eventually(t, func() bool {
+		_, _, batches := got.snapshot()
+		return batches == 2
+	}, time.Second, 10*time.Millisecond)
+	counters, gauges, _ := got.snapshot()
+	assert.Equal(t, map[string]int64{"app_requests": 5}, counters)
+	assert.Equal(t, map[string]float64{"app_heap": 20, "app_cpu": 0.5}, gauges)
+	assert.Zero(t, f.Pending()[upstream.URL])
+}
+
+func TestForwarder_Retry(t *testing.T) {
+	// each flush makes 3 attempts, so the first flush fails
+	upstream, got := newHTTPUpstream(t, "secret", 4)
+	f, err := New(testConfig(upstream.URL))
+	require.NoError(t, err)
+
+	f.Forward([]metrics.Metric{counter("PollCount", 1)})
+	require.Error(t, f.flush(context.Background(), f.upstreams[0]))
+	assert.Equal(t, 1, f.Pending()[upstream.URL])
+
+	// deltas ingested while upstream was down are summed with failed batch
+	f.Forward([]metrics.Metric{counter("PollCount", 2)})
+	require.NoError(t, f.flush(context.Background(), f.upstreams[0]))
+	counters, _, _ := got.snapshot()
+	assert.Equal(t, map[string]int64{"PollCount": 3}, counters)
+}
+
+func TestForwarder_UnresolvedUpstream(t *testing.T) {
+	cfg := testConfig("http://upstream.invalid:8080")
+	cfg.Attempts = 1
+	f, err := New(cfg)
+	require.NoError(t, err)
+
+	// failed resolving of upstream doesn't stop server, batch is kept for the next flush
+	f.Forward([]metrics.Metric{counter("PollCount", 1)})
+	require.Error(t, f.flush(context.Background(), f.upstreams[0]))
+	assert.Equal(t, 1, f.Pending()["http://upstream.invalid:8080"])
+}
+
+func TestForwarder_HTTP_EncryptedLargeBatch(t *testing.T) {
+	dir := t.TempDir()
+	keys := &certs.Settings{PathToCert: filepath.Join(dir, "key.pub"), PathToPrivateKey: filepath.Join(dir, "key")}
+	require.NoError(t, certs.MakeRSACert(keys))
+
+	got := newCollected()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, encrypt.VersionEnvelope, r.Header.Get(encrypt.VersionHeader))
+		plain, err := encrypt.DecryptVersion(keys.PathToPrivateKey, r.Header.Get(encrypt.VersionHeader), data)
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(bytes.NewReader(plain))
+		require.NoError(t, err)
+		var batch []metrics.Metric
+		require.NoError(t, json.NewDecoder(gz).Decode(&batch))
+		got.add(batch)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := testConfig(upstream.URL)
+	cfg.CryptoKey = keys.PathToCert
+	cfg.BatchSize = 500
+	f, err := New(cfg)
+	require.NoError(t, err)
+
+	// compressed batch is much larger than RSA block
+	batch := make([]metrics.Metric, 0, 500)
+	for i := 0; i < 500; i++ {
+		batch = append(batch, gauge(fmt.Sprintf("Gauge%d", i), float64(i)*1.37))
+	}
+	f.Forward(batch)
+	require.NoError(t, f.flush(context.Background(), f.upstreams[0]))
+	_, gauges, batches := got.snapshot()
+	assert.Equal(t, 1, batches)
+	assert.Len(t, gauges, 500)
+	assert.Zero(t, f.Pending()[upstream.URL])
+}
+
+func TestForwarder_FlushOnStop(t *testing.T) {
+	upstream, got := newHTTPUpstream(t, "secret", 0)
+	cfg := testConfig(upstream.URL)
+	cfg.FlushInterval = time.Hour
+	f, err := New(cfg)
+	require.NoError(t, err)
+
+	stop := runForwarder(t, f)
+	f.Forward([]metrics.Metric{gauge("Alloc", 7)})
+	stop()
+
+	_, gauges, _ := got.snapshot()
+	assert.Equal(t, map[string]float64{"Alloc": 7}, gauges)
+}
+
+type testMetricServer struct {
+	pb.UnimplementedMetricServerServer
+	got *collected
+}
+
+func (s *testMetricServer) PushMetrics(ctx context.Context, in *pb.PushMetricsRequest) (*pb.PushMetricsResponse, error) {
+	batch := make([]metrics.Metric, 0, len(in.Metrics))
+	for _, el := range in.Metrics {
+		batch = append(batch, metrics.Metric{ID: el.ID, MType: el.MType, Delta: el.Delta, Value: el.Value})
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get(auth.Header); len(got) != 1 || got[0] != auth.Bearer("node") {
+		return nil, status.Error(codes.Unauthenticated, "no token")
+	}
+	s.got.add(batch)
+	return &pb.PushMetricsResponse{}, nil
+}
+
+func TestForwarder_GRPC(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	got := newCollected()
+	s := grpc.NewServer()
+	pb.RegisterMetricServerServer(s, &testMetricServer{got: got})
+	go s.Serve(listen)
+	defer s.Stop()
+
+	target := "grpc://" + listen.Addr().String()
+	f, err := New(testConfig(target))
+	require.NoError(t, err)
+	stop := runForwarder(t, f)
+	defer stop()
+
+	f.Forward([]metrics.Metric{counter("PollCount", 4), gauge("Alloc", 1.5)})
+	require.Eventually(t, func() bool {
+		_, _, batches := got.snapshot()
+		return batches == 1
+	}, time.Second, 10*time.Millisecond)
+	counters, gauges, _ := got.snapshot()
+	assert.Equal(t, map[string]int64{"PollCount": 4}, counters)
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, gauges)
+}
+
+func TestForwarder_GRPCS(t *testing.T) {
+	files := tlstest.Generate(t, "collector")
+	serverTLS, err := tlsconfig.Server(files.ServerCert, files.ServerKey, files.CA)
+	require.NoError(t, err)
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	got := newCollected()
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	pb.RegisterMetricServerServer(s, &testMetricServer{got: got})
+	go s.Serve(listen)
+	defer s.Stop()
+
+	// upstream requires client certificate
+	cfg := testConfig("grpcs://" + listen.Addr().String())
+	cfg.TLS, err = tlsconfig.Client(files.CA, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	f, err := New(cfg)
+	require.NoError(t, err)
+
+	f.Forward([]metrics.Metric{counter("PollCount", 4)})
+	require.NoError(t, f.flush(context.Background(), f.upstreams[0]))
+	counters, _, _ := got.snapshot()
+	assert.Equal(t, map[string]int64{"PollCount": 4}, counters)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, upstreams := range [][]string{
+		nil,
+		{"localhost:8080"},
+		{"ftp://localhost:8080"},
+		{"http://"},
+	} {
+		_, err := New(testConfig(upstreams...))
+		assert.Error(t, err, upstreams)
+	}
+}
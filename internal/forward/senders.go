@@ -0,0 +1,169 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/encrypt"
+	"github.com/kvvPro/metric-collector/internal/hash"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	"github.com/kvvPro/metric-collector/internal/retry"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// httpSender sends batches to /updates/ of upstream as agent does
+type httpSender struct {
+	url       string
+	host      string
+	hashKey   string
+	cryptoKey string
+	token     string
+	client    *http.Client
+}
+
+func newHTTPSender(cfg Config, u *url.URL) *httpSender {
+	client := &http.Client{}
+	if cfg.TLS != nil {
+		client.Transport = &http.Transport{TLSClientConfig: cfg.TLS}
+	}
+	return &httpSender{
+		url:       u.Scheme + "://" + u.Host + "/updates/",
+		host:      u.Host,
+		hashKey:   cfg.HashKey,
+		cryptoKey: cfg.CryptoKey,
+		token:     cfg.Token,
+		client:    client,
+	}
+}
+
+func (s *httpSender) send(ctx context.Context, batch []metrics.Metric) error {
+	body := new(bytes.Buffer)
+	gzb := gzip.NewWriter(body)
+	if err := json.NewEncoder(gzb).Encode(batch); err != nil {
+		return retry.Unrecoverable(err)
+	}
+	if err := gzb.Close(); err != nil {
+		return retry.Unrecoverable(err)
+	}
+
+	// upstream checks hash of decrypted body
+	var sign string
+	if s.hashKey != "" {
+		sign = base64.URLEncoding.EncodeToString(hash.GetHashSHA256(body.String(), s.hashKey))
+	}
+	if s.cryptoKey != "" {
+		encrypted, err := encrypt.EncryptEnvelope(s.cryptoKey, body.Bytes())
+		if err != nil {
+			return retry.Unrecoverable(err)
+		}
+		body = bytes.NewBuffer(encrypted)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, body)
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	if sign != "" {
+		request.Header.Set("HashSHA256", sign)
+	}
+	if s.cryptoKey != "" {
+		request.Header.Set(encrypt.VersionHeader, encrypt.VersionEnvelope)
+	}
+	if s.token != "" {
+		request.Header.Set(auth.Header, auth.Bearer(s.token))
+	}
+	// upstream can be unavailable for a while, so failed resolving is retried
+	localIP, err := ip.OutboundIP(s.host)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("X-Real-IP", localIP.String())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream responded %v", response.Status)
+	}
+	return nil
+}
+
+func (s *httpSender) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// grpcSender sends batches by PushMetrics of upstream
+type grpcSender struct {
+	host   string
+	token  string
+	conn   *grpc.ClientConn
+	client pb.MetricServerClient
+}
+
+func newGRPCSender(cfg Config, host string, secure bool) (*grpcSender, error) {
+	creds := insecure.NewCredentials()
+	if secure {
+		tlsConfig := cfg.TLS
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	// connection is established on the first call
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcSender{host: host, token: cfg.Token, conn: conn, client: pb.NewMetricServerClient(conn)}, nil
+}
+
+func (s *grpcSender) send(ctx context.Context, batch []metrics.Metric) error {
+	req := pb.PushMetricsRequest{
+		Metrics: make([]*pb.Metric, 0, len(batch)),
+	}
+	for _, el := range batch {
+		req.Metrics = append(req.Metrics, &pb.Metric{
+			ID:    el.ID,
+			MType: el.MType,
+			Delta: el.Delta,
+			Value: el.Value,
+		})
+	}
+
+	localIP, err := ip.OutboundIP(s.host)
+	if err != nil {
+		return err
+	}
+	md := metadata.New(map[string]string{"X-Real-IP": localIP.String()})
+	if s.token != "" {
+		md.Set(auth.Header, auth.Bearer(s.token))
+	}
+	_, err = s.client.PushMetrics(metadata.NewOutgoingContext(ctx, md), &req)
+	return err
+}
+
+func (s *grpcSender) close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,147 @@
+// Package history keeps recent values of metrics in memory
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCapacity is a number of samples kept for each metric by default
+const DefaultCapacity = 720
+
+// Sample is a value of metric at some moment.
+// Value of counter is its total at that moment
+type Sample struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// key identifies metric
+type key struct {
+	mtype string
+	id    string
+}
+
+// ring is a buffer of up to capacity samples of one metric, the oldest sample is overwritten first.
+// Buffer grows on demand, so rarely updated metrics don't take memory of full buffer
+type ring struct {
+	samples []Sample
+	// index of the next sample to overwrite when buffer is full
+	next int
+}
+
+func (r *ring) add(s Sample, capacity int) {
+	if len(r.samples) < capacity {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.next] = s
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+	}
+}
+
+// ordered returns copy of samples from the oldest to the newest
+func (r *ring) ordered() []Sample {
+	if r.next == 0 {
+		res := make([]Sample, len(r.samples))
+		copy(res, r.samples)
+		return res
+	}
+	res := make([]Sample, 0, len(r.samples))
+	res = append(res, r.samples[r.next:]...)
+	return append(res, r.samples[:r.next]...)
+}
+
+// Store keeps last samples of each metric. It is safe for concurrent use
+type Store struct {
+	mx       sync.RWMutex
+	capacity int
+	series   map[key]*ring
+}
+
+// New creates store which keeps up to capacity samples of each metric.
+// It returns nil if capacity isn't positive, so history isn't kept
+func New(capacity int) *Store {
+	if capacity <= 0 {
+		return nil
+	}
+	return &Store{
+		capacity: capacity,
+		series:   make(map[key]*ring),
+	}
+}
+
+// Add appends sample of metric
+func (s *Store) Add(mtype, id string, t time.Time, value float64) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	k := key{mtype, id}
+	r, ok := s.series[k]
+	if !ok {
+		r = &ring{}
+		s.series[k] = r
+	}
+	r.add(Sample{Time: t, Value: value}, s.capacity)
+}
+
+// Samples returns samples of metric since from, ordered by time.
+// All kept samples are returned if from is zero
+func (s *Store) Samples(mtype, id string, from time.Time) []Sample {
+	s.mx.RLock()
+	r, ok := s.series[key{mtype, id}]
+	var all []Sample
+	if ok {
+		all = r.ordered()
+	}
+	s.mx.RUnlock()
+
+	if from.IsZero() {
+		return all
+	}
+	for i, el := range all {
+		if !el.Time.Before(from) {
+			return all[i:]
+		}
+	}
+	return nil
+}
+
+// Last returns up to n last samples of metric, ordered by time
+func (s *Store) Last(mtype, id string, n int) []Sample {
+	all := s.Samples(mtype, id, time.Time{})
+	if len(all) > n {
+		return all[len(all)-n:]
+	}
+	return all
+}
+
+// Increase returns growth of counter over samples. Counter is considered reset
+// when its value decreases, then it is counted from zero
+func Increase(samples []Sample) float64 {
+	var res float64
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1].Value, samples[i].Value
+		if cur < prev {
+			res += cur
+		} else {
+			res += cur - prev
+		}
+	}
+	return res
+}
+
+// Rate returns per-second growth of counter between the first and the last samples.
+// It returns false if there are less than two samples or they have the same time
+func Rate(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	seconds := samples[len(samples)-1].Time.Sub(samples[0].Time).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	return Increase(samples) / seconds, true
+}
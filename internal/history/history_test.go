@@ -0,0 +1,101 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func values(samples []Sample) []float64 {
+	res := make([]float64, 0, len(samples))
+	for _, el := range samples {
+		res = append(res, el.Value)
+	}
+	return res
+}
+
+func TestStore(t *testing.T) {
+	s := New(3)
+	start := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		s.Add("gauge", "Alloc", start.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	s.Add("counter", "PollCount", start, 1)
+
+	tests := []struct {
+		name  string
+		mtype string
+		id    string
+		from  time.Time
+		want  []float64
+	}{
+		{name: "oldest samples are overwritten", mtype: "gauge", id: "Alloc", want: []float64{2, 3, 4}},
+		{name: "since time", mtype: "gauge", id: "Alloc", from: start.Add(3 * time.Second), want: []float64{3, 4}},
+		{name: "nothing since time", mtype: "gauge", id: "Alloc", from: start.Add(time.Minute), want: []float64{}},
+		{name: "not full ring", mtype: "counter", id: "PollCount", want: []float64{1}},
+		{name: "types are separated", mtype: "counter", id: "Alloc", want: []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, values(s.Samples(tt.mtype, tt.id, tt.from)))
+		})
+	}
+
+	assert.Equal(t, []float64{3, 4}, values(s.Last("gauge", "Alloc", 2)))
+	assert.Equal(t, []float64{2, 3, 4}, values(s.Last("gauge", "Alloc", 10)))
+}
+
+func TestNew_Disabled(t *testing.T) {
+	assert.Nil(t, New(0))
+	assert.Nil(t, New(-1))
+}
+
+func TestStore_LazyGrowth(t *testing.T) {
+	s := New(DefaultCapacity)
+	start := time.Unix(1000, 0)
+	s.Add("gauge", "Alloc", start, 1)
+	// buffer of rarely updated metric isn't preallocated
+	assert.Less(t, cap(s.series[key{"gauge", "Alloc"}].samples), DefaultCapacity)
+
+	for i := 1; i < DefaultCapacity+2; i++ {
+		s.Add("gauge", "Alloc", start.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	all := s.Samples("gauge", "Alloc", time.Time{})
+	assert.Len(t, all, DefaultCapacity)
+	assert.Equal(t, float64(2), all[0].Value)
+	assert.Equal(t, float64(DefaultCapacity+1), all[len(all)-1].Value)
+}
+
+func TestIncrease(t *testing.T) {
+	start := time.Unix(1000, 0)
+	samples := func(values ...float64) []Sample {
+		res := make([]Sample, 0, len(values))
+		for i, v := range values {
+			res = append(res, Sample{Time: start.Add(time.Duration(i) * 10 * time.Second), Value: v})
+		}
+		return res
+	}
+
+	tests := []struct {
+		name     string
+		samples  []Sample
+		increase float64
+		rate     float64
+		ok       bool
+	}{
+		{name: "no samples", samples: nil},
+		{name: "one sample", samples: samples(5), ok: false},
+		{name: "growth", samples: samples(5, 10, 25), increase: 20, rate: 1, ok: true},
+		{name: "reset", samples: samples(100, 110, 4, 10), increase: 20, rate: 20.0 / 30, ok: true},
+		{name: "reset to zero", samples: samples(50, 0, 20), increase: 20, rate: 1, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.increase, Increase(tt.samples))
+			rate, ok := Rate(tt.samples)
+			assert.Equal(t, tt.ok, ok)
+			assert.InDelta(t, tt.rate, rate, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SeriesName builds identifier of metric series from metric name and labels.
+// Format is the same as in Prometheus: name{label1="value1",label2="value2"}.
+// Labels are sorted by key, so equal label sets always give equal identifiers.
+// Without labels the identifier is just the metric name.
+func SeriesName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// ParseSeriesName splits identifier of metric series to metric name and labels.
+// It is the reverse function for SeriesName.
+func ParseSeriesName(id string) (string, map[string]string, error) {
+	start := strings.IndexByte(id, '{')
+	if start < 0 {
+		return id, map[string]string{}, nil
+	}
+	if !strings.HasSuffix(id, "}") {
+		return "", nil, errors.New("invalid series name: missing closing brace")
+	}
+
+	name := id[:start]
+	body := id[start+1 : len(id)-1]
+	labels := make(map[string]string)
+
+	for len(body) > 0 {
+		eq := strings.IndexByte(body, '=')
+		if eq <= 0 || len(body) < eq+2 || body[eq+1] != '"' {
+			return "", nil, errors.New("invalid series name: bad label")
+		}
+		key := body[:eq]
+		body = body[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		i := 0
+		for ; i < len(body); i++ {
+			c := body[i]
+			if c == '\\' && i+1 < len(body) {
+				i++
+				switch body[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(body[i])
+				}
+				continue
+			}
+			if c == '"' {
+				closed = true
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return "", nil, errors.New("invalid series name: unterminated label value")
+		}
+		labels[key] = value.String()
+
+		body = body[i+1:]
+		if len(body) > 0 {
+			if body[0] != ',' {
+				return "", nil, errors.New("invalid series name: expected comma")
+			}
+			body = body[1:]
+		}
+	}
+
+	return name, labels, nil
+}
+
+// SanitizeName replaces all characters that are not allowed in metric or label names with underscore.
+// Allowed characters are latin letters, digits and underscore, name can't start with digit.
+func SanitizeName(name string) string {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !isDigit {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// LabelPattern returns regular expression matching identifiers of series which have label with given value.
+// Expression uses only syntax common for RE2 and POSIX regular expressions, so it can be used in SQL queries too.
+func LabelPattern(label, value string) string {
+	return `[{,]` + regexp.QuoteMeta(label) + `="` + regexp.QuoteMeta(escapeLabelValue(value)) + `"[,}]`
+}
+
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSeriesName(t *testing.T) {
+	tests := []struct {
+		name   string
+		mname  string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name:  "without labels",
+			mname: "HeapAlloc",
+			want:  "HeapAlloc",
+		},
+		{
+			name:   "sorted labels",
+			mname:  "requests",
+			labels: map[string]string{"method": "GET", "code": "200"},
+			want:   `requests{code="200",method="GET"}`,
+		},
+		{
+			name:   "escaped value",
+			mname:  "errors",
+			labels: map[string]string{"msg": `say "hi"` + "\n"},
+			want:   `errors{msg="say \"hi\"\n"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SeriesName(tt.mname, tt.labels)
+			if got != tt.want {
+				t.Errorf("SeriesName() = %v, want %v", got, tt.want)
+			}
+
+			name, labels, err := ParseSeriesName(got)
+			if err != nil {
+				t.Fatalf("ParseSeriesName() error = %v", err)
+			}
+			if name != tt.mname {
+				t.Errorf("ParseSeriesName() name = %v, want %v", name, tt.mname)
+			}
+			if len(tt.labels) > 0 && !reflect.DeepEqual(labels, tt.labels) {
+				t.Errorf("ParseSeriesName() labels = %v, want %v", labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestParseSeriesName_Invalid(t *testing.T) {
+	for _, id := range []string{`a{b="c"`, `a{b=c}`, `a{b="c}`, `a{b="c"d="e"}`} {
+		if _, _, err := ParseSeriesName(id); err == nil {
+			t.Errorf("ParseSeriesName(%q) expected error", id)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "http.server.duration", want: "http_server_duration"},
+		{in: "9lives", want: "_9lives"},
+		{in: "ok_name1", want: "ok_name1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := SanitizeName(tt.in); got != tt.want {
+				t.Errorf("SanitizeName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelPattern(t *testing.T) {
+	tests := []struct {
+		label string
+		value string
+		id    string
+		want  bool
+	}{
+		{label: "host", value: "a", id: `cpu{host="a"}`, want: true},
+		{label: "host", value: "a", id: `cpu{dc="x",host="a",zone="1"}`, want: true},
+		{label: "host", value: "a", id: `cpu{host="ab"}`, want: false},
+		{label: "host", value: "a", id: `cpu{myhost="a"}`, want: false},
+		{label: "host", value: "a", id: `cpu{path="x,host=\"a\""}`, want: false},
+		{label: "path", value: `x"y.z`, id: SeriesName("cpu", map[string]string{"path": `x"y.z`}), want: true},
+		{label: "host", value: "a", id: "cpu", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			re := regexp.MustCompile(LabelPattern(tt.label, tt.value))
+			if got := re.MatchString(tt.id); got != tt.want {
+				t.Errorf("LabelPattern(%q, %q) match %q = %v, want %v", tt.label, tt.value, tt.id, got, tt.want)
+			}
+		})
+	}
+}
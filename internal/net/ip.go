@@ -1,21 +1,22 @@
 package ip
 
 import (
-	"log"
 	"net"
+	"strings"
 )
 
-// Get preferred outbound ip of this machine
-func GetOutboundIP(serverIP string) net.IP {
+// OutboundIP returns preferred outbound ip of this machine to reach server,
+// it returns error if address of server can't be resolved
+func OutboundIP(serverIP string) (net.IP, error) {
 	conn, err := net.Dial("udp", serverIP) // указывать адрес сервера на который будем стучаться
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer conn.Close()
 
 	localAddr := conn.LocalAddr().(*net.UDPAddr)
 
-	return localAddr.IP
+	return localAddr.IP, nil
 }
 
 func CheckIPInSubnet(ip string, subnet string) (bool, error) {
@@ -27,3 +28,34 @@ func CheckIPInSubnet(ip string, subnet string) (bool, error) {
 
 	return subnetCIDR.Contains(ipCIDR), nil
 }
+
+// ParseSubnets parses comma separated subnets in CIDR notation
+func ParseSubnets(list string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, el := range strings.Split(list, ",") {
+		el = strings.TrimSpace(el)
+		if el == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(el)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, subnet)
+	}
+	return res, nil
+}
+
+// InSubnets returns true if ip belongs to one of subnets
+func InSubnets(ip string, subnets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, el := range subnets {
+		if el.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+// Package notify delivers alerts and changes of metrics to HTTP webhooks
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/anomaly"
+	"github.com/kvvPro/metric-collector/internal/hash"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/retry"
+)
+
+// types of events
+const (
+	EventAlert   = "alert"
+	EventMetric  = "metric"
+	EventAnomaly = "anomaly"
+)
+
+// header with HMAC-SHA256 of body, the same as in requests of agent
+const signatureHeader = "HashSHA256"
+
+// Event is a payload of webhook request
+type Event struct {
+	// "alert", "metric" or "anomaly"
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// alert which state has changed, set for alert events
+	Alert *alert.Alert `json:"alert,omitempty"`
+	// metric with current value, set for metric events
+	Metric *metrics.Metric `json:"metric,omitempty"`
+	// anomalous value of gauge, set for anomaly events
+	Anomaly *anomaly.Anomaly `json:"anomaly,omitempty"`
+}
+
+// DeadLetter is a record of dead-letter file about undeliverable event
+type DeadLetter struct {
+	Webhook string    `json:"webhook"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+	Event   Event     `json:"event"`
+}
+
+// Config is a settings of notifier
+type Config struct {
+	// URLs of webhooks
+	URLs []string
+	// key to sign bodies of requests, requests aren't signed if empty
+	Key string
+	// true if changes of metrics are delivered, only alerts otherwise
+	MetricEvents bool
+	// JSON lines file for events which weren't delivered, they are dropped if empty
+	DeadLetterPath string
+	// count of delivery attempts to each webhook
+	Attempts uint
+	// delay before the second attempt, each next delay is longer by Step
+	InitDelay time.Duration
+	Step      time.Duration
+	// timeout of one request
+	Timeout time.Duration
+	// size of queue of events waiting for delivery
+	QueueSize int
+}
+
+// DefaultConfig returns config with default delivery settings
+func DefaultConfig() Config {
+	return Config{
+		Attempts:  3,
+		InitDelay: 1000 * time.Millisecond,
+		Step:      2000 * time.Millisecond,
+		Timeout:   5 * time.Second,
+		QueueSize: 1024,
+	}
+}
+
+// Notifier delivers events to webhooks in background.
+// Events are written to dead-letter file if they can't be delivered
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+	queue  chan Event
+
+	// guards dead-letter file
+	mx sync.Mutex
+}
+
+// New creates notifier, Run must be called to deliver events
+func New(cfg Config) *Notifier {
+	return &Notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		queue:  make(chan Event, cfg.QueueSize),
+	}
+}
+
+// MetricEvents returns true if notifier delivers changes of metrics
+func (n *Notifier) MetricEvents() bool {
+	return n.cfg.MetricEvents
+}
+
+// Notify enqueues event without blocking. Event goes to dead-letter file if queue is full
+func (n *Notifier) Notify(e Event) {
+	select {
+	case n.queue <- e:
+	default:
+		for _, url := range n.cfg.URLs {
+			n.deadLetter(url, e, errors.New("queue is full"))
+		}
+	}
+}
+
+// Run delivers enqueued events until ctx is done.
+// Events left in queue are written to dead-letter file
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case e := <-n.queue:
+			n.deliver(ctx, e)
+		case <-ctx.Done():
+			for {
+				select {
+				case e := <-n.queue:
+					for _, url := range n.cfg.URLs {
+						n.deadLetter(url, e, ctx.Err())
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver sends event to each webhook with retries
+func (n *Notifier) deliver(ctx context.Context, e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		for _, url := range n.cfg.URLs {
+			n.deadLetter(url, e, err)
+		}
+		return
+	}
+
+	for _, url := range n.cfg.URLs {
+		err := retry.Do(func() error {
+			return n.send(ctx, url, body)
+		},
+			retry.Attempts(n.cfg.Attempts),
+			retry.InitDelay(n.cfg.InitDelay),
+			retry.Step(n.cfg.Step),
+			retry.LastErrorOnly(true),
+			retry.Context(ctx),
+		)
+		if err != nil {
+			n.deadLetter(url, e, err)
+		}
+	}
+}
+
+// send makes one request to webhook. Client errors of webhook aren't retried
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return retry.Unrecoverable(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if n.cfg.Key != "" {
+		sign := hash.GetHashSHA256(string(body), n.cfg.Key)
+		request.Header.Set(signatureHeader, base64.URLEncoding.EncodeToString(sign))
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	switch {
+	case response.StatusCode < 300:
+		return nil
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook responded %v", response.Status)
+	default:
+		return retry.Unrecoverable(fmt.Errorf("webhook responded %v", response.Status))
+	}
+}
+
+// deadLetter appends undeliverable event to dead-letter file
+func (n *Notifier) deadLetter(url string, e Event, reason error) {
+	if n.cfg.DeadLetterPath == "" {
+		return
+	}
+	data, err := json.Marshal(DeadLetter{
+		Webhook: url,
+		Error:   reason.Error(),
+		Time:    time.Now(),
+		Event:   e,
+	})
+	if err != nil {
+		return
+	}
+
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	file, err := os.OpenFile(n.cfg.DeadLetterPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.Write(append(data, '\n'))
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/alert"
+	"github.com/kvvPro/metric-collector/internal/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a local webhook which fails first requests
+type receiver struct {
+	t      *testing.T
+	status int
+	// count of requests answered with status
+	failures int32
+
+	requests atomic.Int32
+	mx       sync.Mutex
+	bodies   [][]byte
+	signs    []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(rc.t, err)
+	if rc.requests.Add(1) <= rc.failures {
+		w.WriteHeader(rc.status)
+		return
+	}
+	rc.mx.Lock()
+	rc.bodies = append(rc.bodies, body)
+	rc.signs = append(rc.signs, r.Header.Get(signatureHeader))
+	rc.mx.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func testConfig(t *testing.T, urls ...string) Config {
+	cfg := DefaultConfig()
+	cfg.URLs = urls
+	cfg.Key = "secret"
+	cfg.DeadLetterPath = filepath.Join(t.TempDir(), "dead-letter.json")
+	cfg.InitDelay = time.Millisecond
+	cfg.Step = time.Millisecond
+	return cfg
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	defer file.Close()
+
+	var res []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var d DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &d))
+		res = append(res, d)
+	}
+	return res
+}
+
+func testEvent() Event {
+	return Event{
+		Type: EventAlert,
+		Time: time.Unix(10000, 0).UTC(),
+		Alert: &alert.Alert{
+			Rule:     "NoMemory",
+			Series:   "FreeMemory",
+			Severity: "critical",
+			State:    alert.StateFiring,
+		},
+	}
+}
+
+func TestNotifier_Deliver(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		failures   int32
+		delivered  int
+		requests   int32
+		deadLetter bool
+	}{
+		{name: "first attempt", delivered: 1, requests: 1},
+		{name: "retry after server error", status: http.StatusBadGateway, failures: 2, delivered: 1, requests: 3},
+		{name: "attempts exhausted", status: http.StatusServiceUnavailable, failures: 3, requests: 3, deadLetter: true},
+		{name: "client error isn't retried", status: http.StatusBadRequest, failures: 1, requests: 1, deadLetter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{t: t, status: tt.status, failures: tt.failures}
+			hook := httptest.NewServer(rc)
+			defer hook.Close()
+			cfg := testConfig(t, hook.URL)
+			n := New(cfg)
+
+			n.deliver(context.Background(), testEvent())
+
+			assert.Equal(t, tt.requests, rc.requests.Load())
+			require.Len(t, rc.bodies, tt.delivered)
+			for i, body := range rc.bodies {
+				sign := base64.URLEncoding.EncodeToString(hash.GetHashSHA256(string(body), cfg.Key))
+				assert.Equal(t, sign, rc.signs[i])
+				var got Event
+				require.NoError(t, json.Unmarshal(body, &got))
+				assert.Equal(t, testEvent(), got)
+			}
+
+			dead := readDeadLetters(t, cfg.DeadLetterPath)
+			if !tt.deadLetter {
+				assert.Empty(t, dead)
+				return
+			}
+			require.Len(t, dead, 1)
+			assert.Equal(t, hook.URL, dead[0].Webhook)
+			assert.Equal(t, testEvent(), dead[0].Event)
+			assert.NotEmpty(t, dead[0].Error)
+		})
+	}
+}
+
+func TestNotifier_Run(t *testing.T) {
+	rc := &receiver{t: t}
+	hook := httptest.NewServer(rc)
+	defer hook.Close()
+	cfg := testConfig(t, hook.URL)
+	cfg.Key = ""
+	n := New(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(done)
+	}()
+
+	n.Notify(testEvent())
+	require.Eventually(t, func() bool { return rc.requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{""}, rc.signs)
+	// events enqueued after stop go to dead-letter file
+	n.Notify(testEvent())
+	n.Run(ctx)
+	assert.Len(t, readDeadLetters(t, cfg.DeadLetterPath), 1)
+}
@@ -0,0 +1,325 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// Source provides metrics for evaluation of queries
+type Source interface {
+	// ListMetrics returns current values of metrics selected by filter
+	ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error)
+	// Samples returns samples of metric since from, ordered by time
+	Samples(ctx context.Context, mtype, id string, from time.Time) ([]history.Sample, error)
+}
+
+// ScoreSource is a Source which also provides anomaly scores of gauges
+type ScoreSource interface {
+	Source
+	// AnomalyScore returns deviation of the last value of metric from its baseline
+	// in std deviations, false if metric isn't watched by detector
+	AnomalyScore(ctx context.Context, mtype, id string) (float64, bool, error)
+}
+
+// Series is a value of one series in result of query
+type Series struct {
+	// name of metric, empty for computed series
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// ID returns identifier of series in the same format as names of metrics in storage
+func (s Series) ID() string {
+	return metrics.SeriesName(s.Name, s.Labels)
+}
+
+// Result is a result of query: number or set of series
+type Result struct {
+	// true if query result is a number, it is the only series without name
+	Scalar bool
+	Series []Series
+}
+
+// Eval parses and evaluates query at moment now
+func Eval(ctx context.Context, src Source, query string, now time.Time) (*Result, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return Evaluate(ctx, src, expr, now)
+}
+
+// Evaluate evaluates parsed query at moment now
+func Evaluate(ctx context.Context, src Source, expr Expr, now time.Time) (*Result, error) {
+	e := &evaluator{ctx: ctx, src: src, now: now}
+	v, err := e.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	if v.scalar {
+		return &Result{Scalar: true, Series: []Series{{Labels: map[string]string{}, Value: v.number}}}, nil
+	}
+	sort.Slice(v.series, func(i, j int) bool {
+		return v.series[i].ID() < v.series[j].ID()
+	})
+	return &Result{Series: v.series}, nil
+}
+
+// value is an intermediate result of evaluation
+type value struct {
+	scalar bool
+	number float64
+	series []Series
+}
+
+type evaluator struct {
+	ctx context.Context
+	src Source
+	now time.Time
+}
+
+func (e *evaluator) eval(expr Expr) (value, error) {
+	switch ex := expr.(type) {
+	case *NumberLiteral:
+		return value{scalar: true, number: ex.Value}, nil
+	case *Selector:
+		series, err := e.selectSeries(ex, func(m *metrics.Metric) (float64, bool, error) {
+			return metricValue(m), true, nil
+		})
+		return value{series: series}, err
+	case *NegExpr:
+		v, err := e.eval(ex.Expr)
+		if err != nil {
+			return value{}, err
+		}
+		return apply(v, func(x float64) float64 { return -x }), nil
+	case *BinaryExpr:
+		return e.evalBinary(ex)
+	case *Call:
+		if rangeFunctions[ex.Func] {
+			return e.evalRange(ex)
+		}
+		if seriesFunctions[ex.Func] {
+			return e.evalScore(ex)
+		}
+		return e.evalAggregation(ex)
+	}
+	return value{}, fmt.Errorf("unsupported expression %s", expr)
+}
+
+// selectSeries returns series selected by selector, their values are computed by fn.
+// Series are skipped if fn returns false
+func (e *evaluator) selectSeries(sel *Selector, fn func(m *metrics.Metric) (float64, bool, error)) ([]Series, error) {
+	list, err := e.src.ListMetrics(e.ctx, storage.Filter{
+		Regex:  globRegex(sel.Pattern),
+		Labels: sel.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]Series, 0, len(list))
+	for _, m := range list {
+		v, ok, err := fn(m)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		name, labels, err := metrics.ParseSeriesName(m.ID)
+		if err != nil {
+			name, labels = m.ID, map[string]string{}
+		}
+		series = append(series, Series{Name: name, Labels: labels, Value: v})
+	}
+	return series, nil
+}
+
+func (e *evaluator) evalRange(call *Call) (value, error) {
+	sel := call.Arg.(*Selector)
+	from := e.now.Add(-sel.Range)
+	series, err := e.selectSeries(sel, func(m *metrics.Metric) (float64, bool, error) {
+		samples, err := e.src.Samples(e.ctx, m.MType, m.ID, from)
+		if err != nil || len(samples) < 2 {
+			return 0, false, err
+		}
+		first, last := samples[0], samples[len(samples)-1]
+		growth := last.Value - first.Value
+		// decrease of counter means its reset, it doesn't matter for delta
+		if m.MType == metrics.MetricTypeCounter && call.Func != "delta" {
+			growth = history.Increase(samples)
+		}
+		if call.Func != "rate" {
+			return growth, true, nil
+		}
+		seconds := last.Time.Sub(first.Time).Seconds()
+		if seconds <= 0 {
+			return 0, false, nil
+		}
+		return growth / seconds, true, nil
+	})
+	if err != nil {
+		return value{}, err
+	}
+	// result of function isn't a value of metric anymore
+	for i := range series {
+		series[i].Name = ""
+	}
+	return value{series: series}, nil
+}
+
+func (e *evaluator) evalScore(call *Call) (value, error) {
+	src, ok := e.src.(ScoreSource)
+	if !ok {
+		return value{}, errors.New("anomaly detection is disabled")
+	}
+	// series keep names, so alerts on scores of several gauges are distinguishable
+	series, err := e.selectSeries(call.Arg.(*Selector), func(m *metrics.Metric) (float64, bool, error) {
+		return src.AnomalyScore(e.ctx, m.MType, m.ID)
+	})
+	return value{series: series}, err
+}
+
+func (e *evaluator) evalAggregation(call *Call) (value, error) {
+	v, err := e.eval(call.Arg)
+	if err != nil {
+		return value{}, err
+	}
+	if v.scalar {
+		return v, nil
+	}
+	if len(v.series) == 0 {
+		return value{}, nil
+	}
+
+	res := v.series[0].Value
+	for _, s := range v.series[1:] {
+		switch call.Func {
+		case "sum", "avg":
+			res += s.Value
+		case "min":
+			res = math.Min(res, s.Value)
+		case "max":
+			res = math.Max(res, s.Value)
+		}
+	}
+	if call.Func == "avg" {
+		res /= float64(len(v.series))
+	}
+	return value{series: []Series{{Labels: map[string]string{}, Value: res}}}, nil
+}
+
+func (e *evaluator) evalBinary(ex *BinaryExpr) (value, error) {
+	lhs, err := e.eval(ex.LHS)
+	if err != nil {
+		return value{}, err
+	}
+	rhs, err := e.eval(ex.RHS)
+	if err != nil {
+		return value{}, err
+	}
+	op := func(a, b float64) float64 {
+		switch ex.Op {
+		case '+':
+			return a + b
+		case '-':
+			return a - b
+		case '*':
+			return a * b
+		default:
+			return a / b
+		}
+	}
+
+	switch {
+	case lhs.scalar && rhs.scalar:
+		return value{scalar: true, number: op(lhs.number, rhs.number)}, nil
+	case lhs.scalar:
+		return apply(rhs, func(x float64) float64 { return op(lhs.number, x) }), nil
+	case rhs.scalar:
+		return apply(lhs, func(x float64) float64 { return op(x, rhs.number) }), nil
+	}
+
+	// single series are combined regardless of their names and labels: HeapAlloc / HeapSys
+	if len(lhs.series) == 1 && len(rhs.series) == 1 {
+		return value{series: []Series{{
+			Labels: lhs.series[0].Labels,
+			Value:  op(lhs.series[0].Value, rhs.series[0].Value),
+		}}}, nil
+	}
+
+	// otherwise series with equal labels are combined
+	right := make(map[string]Series, len(rhs.series))
+	for _, s := range rhs.series {
+		key := metrics.SeriesName("", s.Labels)
+		if _, ok := right[key]; ok {
+			return value{}, errors.New("right operand has several series with labels " + key)
+		}
+		right[key] = s
+	}
+	res := make([]Series, 0, len(lhs.series))
+	for _, s := range lhs.series {
+		r, ok := right[metrics.SeriesName("", s.Labels)]
+		if !ok {
+			continue
+		}
+		res = append(res, Series{Labels: s.Labels, Value: op(s.Value, r.Value)})
+	}
+	return value{series: res}, nil
+}
+
+// apply computes fn for each value, names of metrics are dropped
+func apply(v value, fn func(float64) float64) value {
+	if v.scalar {
+		return value{scalar: true, number: fn(v.number)}
+	}
+	res := make([]Series, 0, len(v.series))
+	for _, s := range v.series {
+		res = append(res, Series{Labels: s.Labels, Value: fn(s.Value)})
+	}
+	return value{series: res}
+}
+
+// metricValue returns value of metric as float, counter is converted to its total
+func metricValue(m *metrics.Metric) float64 {
+	if m.MType == metrics.MetricTypeCounter {
+		if m.Delta == nil {
+			return 0
+		}
+		return float64(*m.Delta)
+	}
+	if m.Value == nil {
+		return 0
+	}
+	return *m.Value
+}
+
+// globRegex converts glob pattern for metric name to regular expression matching whole series name
+func globRegex(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(`[^{]*`)
+		case '?':
+			b.WriteString(`[^{]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`(\{.*)?$`)
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package query
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSource struct {
+	*memstorage.MemStorage
+	*history.Store
+}
+
+func (s testSource) Samples(_ context.Context, mtype, id string, from time.Time) ([]history.Sample, error) {
+	return s.Store.Samples(mtype, id, from), nil
+}
+
+func newTestSource(now time.Time) testSource {
+	st := memstorage.NewMemStorage()
+	st.Gauges = map[string]float64{
+		"CPUutilization1":                     10,
+		"CPUutilization2":                     30,
+		"HeapAlloc":                           1024,
+		"HeapSys":                             4096,
+		`disk_used{host="a",mount="/"}`:       50,
+		`disk_used{host="b",mount="/"}`:       70,
+		`disk_total{host="a",mount="/"}`:      100,
+		`disk_total{host="b",mount="/"}`:      200,
+		`disk_total{host="c",mount="/"}`:      300,
+		`requests{code="200",host="a"}`:       0,
+		`requests{code="500",host="a"}`:       0,
+		`requests_other{code="200",host="a"}`: 0,
+	}
+	st.Counters = map[string]int64{"PollCount": 30, "Restarts": 15}
+
+	h := history.New(100)
+	for i := 0; i <= 10; i++ {
+		t := now.Add(time.Duration(i-10) * time.Minute)
+		h.Add("counter", "PollCount", t, float64(i*3))
+		h.Add("gauge", `requests{code="200",host="a"}`, t, float64(100-i*6))
+	}
+	// counter was reset after the third sample
+	for i, v := range []float64{0, 10, 20, 5, 15} {
+		h.Add("counter", "Restarts", now.Add(time.Duration(i-4)*time.Minute), v)
+	}
+	return testSource{MemStorage: st, Store: h}
+}
+
+func TestEval(t *testing.T) {
+	now := time.Unix(100000, 0)
+	src := newTestSource(now)
+
+	type series struct {
+		id    string
+		value float64
+	}
+	tests := []struct {
+		query  string
+		scalar bool
+		want   []series
+	}{
+		{query: "1 + 2 * 3", scalar: true, want: []series{{id: "", value: 7}}},
+		{query: "sum(CPUutilization*)", want: []series{{id: "", value: 40}}},
+		{query: "avg(CPUutilization?)", want: []series{{id: "", value: 20}}},
+		{query: "min(CPUutilization*)", want: []series{{id: "", value: 10}}},
+		{query: "max(CPUutilization*) - 5", want: []series{{id: "", value: 25}}},
+		{query: "HeapAlloc / HeapSys", want: []series{{id: "", value: 0.25}}},
+		{query: "HeapAlloc * 2", want: []series{{id: "", value: 2048}}},
+		{query: "PollCount", want: []series{{id: "PollCount", value: 30}}},
+		{query: `disk_used{host="b"}`, want: []series{{id: `disk_used{host="b",mount="/"}`, value: 70}}},
+		{
+			query: "disk_used / disk_total * 100",
+			want: []series{
+				{id: `{host="a",mount="/"}`, value: 50},
+				{id: `{host="b",mount="/"}`, value: 35},
+			},
+		},
+		{query: `sum({mount="/"})`, want: []series{{id: "", value: 720}}},
+		{query: "Unknown", want: []series{}},
+		{query: "sum(Unknown)", want: []series{}},
+		// counter grows by 3 every minute
+		{query: "delta(PollCount[5m])", want: []series{{id: "", value: 15}}},
+		{query: "rate(PollCount[10m])", want: []series{{id: "", value: 0.05}}},
+		{query: "increase(Restarts[10m])", want: []series{{id: "", value: 35}}},
+		{query: "rate(Restarts[10m]) * 60", want: []series{{id: "", value: 8.75}}},
+		{query: "delta(Restarts[10m])", want: []series{{id: "", value: 15}}},
+		// gauges have no resets
+		{query: "increase(requests{code=\"200\"}[2m])", want: []series{{id: `{code="200",host="a"}`, value: -12}}},
+		{query: "rate(requests*[2m])", want: []series{{id: `{code="200",host="a"}`, value: -0.1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			res, err := Eval(context.Background(), src, tt.query, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.scalar, res.Scalar)
+			got := make([]series, 0, len(res.Series))
+			for _, s := range res.Series {
+				got = append(got, series{id: s.ID(), value: math.Round(s.Value*1000) / 1000})
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEval_Errors(t *testing.T) {
+	src := newTestSource(time.Now())
+
+	// several series on the right side have the same labels
+	_, err := Eval(context.Background(), src, "disk_used / requests*", time.Now())
+	assert.Error(t, err)
+
+	_, err = Eval(context.Background(), src, "sum(", time.Now())
+	assert.Error(t, err)
+
+	// source doesn't provide anomaly scores
+	_, err = Eval(context.Background(), src, "anomaly_score(HeapAlloc)", time.Now())
+	assert.Error(t, err)
+}
+
+// scoreSource provides scores of some gauges
+type scoreSource struct {
+	testSource
+	scores map[string]float64
+}
+
+func (s scoreSource) AnomalyScore(_ context.Context, mtype, id string) (float64, bool, error) {
+	v, ok := s.scores[id]
+	return v, ok, nil
+}
+
+func TestEval_AnomalyScore(t *testing.T) {
+	now := time.Unix(100000, 0)
+	src := scoreSource{
+		testSource: newTestSource(now),
+		scores:     map[string]float64{"CPUutilization1": 4.5, "HeapAlloc": -1},
+	}
+
+	res, err := Eval(context.Background(), src, "max(anomaly_score(CPUutilization*))", now)
+	require.NoError(t, err)
+	require.Len(t, res.Series, 1)
+	assert.Equal(t, 4.5, res.Series[0].Value)
+
+	res, err = Eval(context.Background(), src, "anomaly_score(Heap*)", now)
+	require.NoError(t, err)
+	require.Len(t, res.Series, 1)
+	assert.Equal(t, "HeapAlloc", res.Series[0].ID())
+	assert.Equal(t, -1.0, res.Series[0].Value)
+}
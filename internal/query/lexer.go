@@ -0,0 +1,214 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokNumber
+	// name of metric or function, name of metric may contain glob characters * and ?
+	tokIdent
+	tokString
+	// duration in square brackets: [5m]
+	tokRange
+	tokPlus
+	tokMinus
+	tokMul
+	tokDiv
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+	tokEq
+	tokNeq
+)
+
+var tokenNames = map[tokenType]string{
+	tokEOF:    "end of query",
+	tokNumber: "number",
+	tokIdent:  "name",
+	tokString: "string",
+	tokRange:  "range",
+	tokPlus:   "+",
+	tokMinus:  "-",
+	tokMul:    "*",
+	tokDiv:    "/",
+	tokLParen: "(",
+	tokRParen: ")",
+	tokLBrace: "{",
+	tokRBrace: "}",
+	tokComma:  ",",
+	tokEq:     "=",
+	tokNeq:    "!=",
+}
+
+func (t tokenType) String() string {
+	return tokenNames[t]
+}
+
+type token struct {
+	typ tokenType
+	// text of token, for strings - unquoted value
+	text string
+	// position of token in query
+	pos int
+}
+
+// Error describes invalid query
+type Error struct {
+	// position in query, where error is found
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '.' || c == ':' || c == '*' || c == '?'
+}
+
+// lex splits query to tokens.
+// Asterisk after operand (number, name or closing bracket) is multiplication,
+// otherwise it starts name pattern. Inside name asterisk is always a glob character,
+// so multiplication of metric must be separated by space: HeapAlloc * 2
+func lex(input string) ([]token, error) {
+	var tokens []token
+	afterOperand := func() bool {
+		if len(tokens) == 0 {
+			return false
+		}
+		switch tokens[len(tokens)-1].typ {
+		case tokNumber, tokIdent, tokRParen, tokRBrace, tokRange:
+			return true
+		}
+		return false
+	}
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isDigit(c) || c == '.' && i+1 < len(input) && isDigit(input[i+1]):
+			i = scanNumber(input, i)
+			tokens = append(tokens, token{typ: tokNumber, text: input[start:i], pos: start})
+			continue
+		case isLetter(c) || c == '?' || c == '*' && !afterOperand():
+			for i < len(input) && isNameChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{typ: tokIdent, text: input[start:i], pos: start})
+			continue
+		case c == '"':
+			value, end, err := scanString(input, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			tokens = append(tokens, token{typ: tokString, text: value, pos: start})
+			continue
+		case c == '[':
+			end := strings.IndexByte(input[i:], ']')
+			if end < 0 {
+				return nil, errorf(start, "unterminated range")
+			}
+			i += end + 1
+			tokens = append(tokens, token{typ: tokRange, text: strings.TrimSpace(input[start+1 : i-1]), pos: start})
+			continue
+		case c == '!' && i+1 < len(input) && input[i+1] == '=':
+			tokens = append(tokens, token{typ: tokNeq, text: "!=", pos: start})
+			i += 2
+			continue
+		}
+
+		typ, ok := map[byte]tokenType{
+			'+': tokPlus, '-': tokMinus, '*': tokMul, '/': tokDiv,
+			'(': tokLParen, ')': tokRParen, '{': tokLBrace, '}': tokRBrace,
+			',': tokComma, '=': tokEq,
+		}[c]
+		if !ok {
+			r, _ := utf8.DecodeRuneInString(input[i:])
+			return nil, errorf(start, "unexpected character %q", r)
+		}
+		tokens = append(tokens, token{typ: typ, text: string(c), pos: start})
+		i++
+	}
+
+	return append(tokens, token{typ: tokEOF, pos: len(input)}), nil
+}
+
+func scanNumber(input string, i int) int {
+	for i < len(input) && (isDigit(input[i]) || input[i] == '.') {
+		i++
+	}
+	// exponent
+	if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+		j := i + 1
+		if j < len(input) && (input[j] == '+' || input[j] == '-') {
+			j++
+		}
+		if j < len(input) && isDigit(input[j]) {
+			i = j
+			for i < len(input) && isDigit(input[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+// scanString reads string in double quotes starting at i,
+// it returns unquoted value and position after closing quote
+func scanString(input string, i int) (string, int, error) {
+	var b strings.Builder
+	for j := i + 1; j < len(input); j++ {
+		switch input[j] {
+		case '\\':
+			if j+1 < len(input) {
+				j++
+				if input[j] == 'n' {
+					b.WriteByte('\n')
+				} else {
+					b.WriteByte(input[j])
+				}
+			}
+		case '"':
+			return b.String(), j + 1, nil
+		default:
+			b.WriteByte(input[j])
+		}
+	}
+	return "", 0, errorf(i, "unterminated string")
+}
+
+// parseRange parses duration of range selector
+func parseRange(t token) (time.Duration, error) {
+	d, err := time.ParseDuration(t.text)
+	if err != nil || d <= 0 {
+		return 0, errorf(t.pos, "invalid range %q", t.text)
+	}
+	return d, nil
+}
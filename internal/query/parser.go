@@ -0,0 +1,345 @@
+// Package query implements small query language over metrics.
+//
+// Query is an arithmetic expression of numbers, selectors and function calls:
+//
+//	sum(CPUutilization*)
+//	HeapAlloc / HeapSys * 100
+//	rate(http_requests{code="200"}[5m])
+//
+// Selector consists of metric name pattern with glob characters * and ?,
+// optional label matchers in braces and optional range in square brackets.
+// Range is allowed only in arguments of rate, increase and delta.
+// For counters rate and increase take resets into account: decrease of value
+// means that counter was restarted from zero.
+// Function anomaly_score returns deviation of gauges from their baselines
+// in std deviations, it is available if source provides scores.
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// functions aggregating all series of argument to one series
+var aggregations = map[string]bool{
+	"sum": true,
+	"avg": true,
+	"min": true,
+	"max": true,
+}
+
+// functions over range of samples of each series
+var rangeFunctions = map[string]bool{
+	"rate":     true,
+	"increase": true,
+	"delta":    true,
+}
+
+// functions over each series of selector without range
+var seriesFunctions = map[string]bool{
+	"anomaly_score": true,
+}
+
+// Expr is a node of parsed query
+type Expr interface {
+	String() string
+}
+
+// NumberLiteral is a constant number
+type NumberLiteral struct {
+	Value float64
+}
+
+func (e *NumberLiteral) String() string {
+	return strconv.FormatFloat(e.Value, 'g', -1, 64)
+}
+
+// Selector selects series by name pattern and labels
+type Selector struct {
+	// name pattern with glob characters * and ?
+	Pattern string
+	Labels  []storage.LabelMatcher
+	// range of samples, zero if selector selects current values
+	Range time.Duration
+}
+
+func (e *Selector) String() string {
+	var b strings.Builder
+	b.WriteString(e.Pattern)
+	if len(e.Labels) > 0 {
+		b.WriteByte('{')
+		for i, el := range e.Labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(el.Name)
+			if el.Negative {
+				b.WriteString("!=")
+			} else {
+				b.WriteByte('=')
+			}
+			b.WriteString(strconv.Quote(el.Value))
+		}
+		b.WriteByte('}')
+	}
+	if e.Range > 0 {
+		b.WriteString("[" + e.Range.String() + "]")
+	}
+	return b.String()
+}
+
+// Call is a call of function
+type Call struct {
+	Func string
+	Arg  Expr
+}
+
+func (e *Call) String() string {
+	return e.Func + "(" + e.Arg.String() + ")"
+}
+
+// BinaryExpr is an arithmetic operation
+type BinaryExpr struct {
+	// one of + - * /
+	Op  byte
+	LHS Expr
+	RHS Expr
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.LHS.String() + " " + string(e.Op) + " " + e.RHS.String() + ")"
+}
+
+// NegExpr is a unary minus
+type NegExpr struct {
+	Expr Expr
+}
+
+func (e *NegExpr) String() string {
+	return "-" + e.Expr.String()
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses query
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, errorf(t.pos, "unexpected %s", t.typ)
+	}
+	if err := checkNoRange(expr); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(typ tokenType) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, errorf(t.pos, "expected %s, found %s", typ, t.typ)
+	}
+	return t, nil
+}
+
+// parseExpr parses sum or difference of terms
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.typ != tokPlus && t.typ != tokMinus {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: t.text[0], LHS: lhs, RHS: rhs}
+	}
+}
+
+// parseTerm parses product or quotient of factors
+func (p *parser) parseTerm() (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.typ != tokMul && t.typ != tokDiv {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &BinaryExpr{Op: t.text[0], LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().typ == tokMinus {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &NegExpr{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorf(t.pos, "invalid number %q", t.text)
+		}
+		return &NumberLiteral{Value: v}, nil
+	case tokLParen:
+		p.next()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokIdent:
+		if p.tokens[p.pos+1].typ == tokLParen && (aggregations[t.text] || rangeFunctions[t.text] || seriesFunctions[t.text]) {
+			return p.parseCall()
+		}
+		return p.parseSelector()
+	case tokLBrace:
+		return p.parseSelector()
+	}
+	return nil, errorf(t.pos, "unexpected %s", t.typ)
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	name := p.next()
+	p.next()
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+
+	if rangeFunctions[name.text] {
+		sel, ok := arg.(*Selector)
+		if !ok || sel.Range == 0 {
+			return nil, errorf(name.pos, "%s expects selector with range, e.g. %s(name[5m])", name.text, name.text)
+		}
+	} else if seriesFunctions[name.text] {
+		sel, ok := arg.(*Selector)
+		if !ok || sel.Range != 0 {
+			return nil, errorf(name.pos, "%s expects selector without range, e.g. %s(name)", name.text, name.text)
+		}
+	} else if err := checkNoRange(arg); err != nil {
+		return nil, err
+	}
+	return &Call{Func: name.text, Arg: arg}, nil
+}
+
+// parseSelector parses name pattern with optional labels and range.
+// Pattern is * if selector starts with labels
+func (p *parser) parseSelector() (Expr, error) {
+	sel := &Selector{Pattern: "*"}
+	if p.peek().typ == tokIdent {
+		sel.Pattern = p.next().text
+	}
+
+	if p.peek().typ == tokLBrace {
+		p.next()
+		for p.peek().typ != tokRBrace {
+			name, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			op := p.next()
+			if op.typ != tokEq && op.typ != tokNeq {
+				return nil, errorf(op.pos, "expected = or !=, found %s", op.typ)
+			}
+			value, err := p.expect(tokString)
+			if err != nil {
+				return nil, err
+			}
+			sel.Labels = append(sel.Labels, storage.LabelMatcher{
+				Name:     name.text,
+				Value:    value.text,
+				Negative: op.typ == tokNeq,
+			})
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokRBrace); err != nil {
+			return nil, err
+		}
+	}
+
+	if t := p.peek(); t.typ == tokRange {
+		p.next()
+		d, err := parseRange(t)
+		if err != nil {
+			return nil, err
+		}
+		sel.Range = d
+	}
+	return sel, nil
+}
+
+// checkNoRange returns error if expression contains range selector outside of range functions
+func checkNoRange(expr Expr) error {
+	switch e := expr.(type) {
+	case *Selector:
+		if e.Range > 0 {
+			return errorf(0, "range %s is allowed only in rate, increase and delta", e)
+		}
+	case *BinaryExpr:
+		if err := checkNoRange(e.LHS); err != nil {
+			return err
+		}
+		return checkNoRange(e.RHS)
+	case *NegExpr:
+		return checkNoRange(e.Expr)
+	}
+	return nil
+}
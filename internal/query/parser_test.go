@@ -0,0 +1,63 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "42", want: "42"},
+		{query: "sum(CPUutilization*)", want: "sum(CPUutilization*)"},
+		{query: "HeapAlloc / HeapSys * 100", want: "((HeapAlloc / HeapSys) * 100)"},
+		{query: "HeapAlloc*2", want: "HeapAlloc*2"},
+		{query: "2*3+1", want: "((2 * 3) + 1)"},
+		{query: "1 - -Alloc", want: "(1 - -Alloc)"},
+		{query: "(1 + 2) * 3", want: "((1 + 2) * 3)"},
+		{query: `rate(http_requests{code="200", host!="a"}[5m])`, want: `rate(http_requests{code="200",host!="a"}[5m0s])`},
+		{query: `max({zone="x"})`, want: `max(*{zone="x"})`},
+		{query: "sum", want: "sum"},
+		{query: "1.5e3", want: "1500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{query: "", pos: 0},
+		{query: "1 +", pos: 3},
+		{query: "sum(Alloc", pos: 9},
+		{query: "Alloc)", pos: 5},
+		{query: `Alloc{host}`, pos: 10},
+		{query: `Alloc{host="a}`, pos: 11},
+		{query: "Alloc[5m", pos: 5},
+		{query: "Alloc[5x]", pos: 5},
+		{query: "rate(Alloc)", pos: 0},
+		{query: "sum(Alloc[5m])", pos: 0},
+		{query: "anomaly_score(Alloc[5m])", pos: 0},
+		{query: "Alloc # 2", pos: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var queryErr *Error
+			require.True(t, errors.As(err, &queryErr), "error %v", err)
+			assert.Equal(t, tt.pos, queryErr.Pos)
+		})
+	}
+}
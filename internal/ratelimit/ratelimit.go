@@ -0,0 +1,152 @@
+// Package ratelimit limits rate of requests of each client by token buckets.
+// Limits are set per route: prefix of path of HTTP request or of full name of gRPC method
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnyRoute is a route of rule which matches all requests
+const AnyRoute = "*"
+
+// interval of removal of idle buckets
+const sweepInterval = time.Minute
+
+// Rule limits rate of requests of each client to routes with prefix Route
+type Rule struct {
+	Route string
+	// requests per second
+	Rate float64
+	// max count of requests at once
+	Burst int
+}
+
+// ParseRules parses comma separated rules route=rate[:burst], e.g. "/updates/=10:20,*=100".
+// Burst is equal to rate rounded up if it isn't set
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, el := range strings.Split(s, ",") {
+		el = strings.TrimSpace(el)
+		if el == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(el, "=")
+		if !ok || route == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, route=rate[:burst] is expected", el)
+		}
+		rateStr, burstStr, hasBurst := strings.Cut(limit, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate of route %s", route)
+		}
+		burst := int(math.Ceil(rate))
+		if hasBurst {
+			burst, err = strconv.Atoi(burstStr)
+			if err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid burst of route %s", route)
+			}
+		}
+		rules = append(rules, Rule{Route: route, Rate: rate, Burst: burst})
+	}
+	return rules, nil
+}
+
+// Limiter keeps token buckets of clients
+type Limiter struct {
+	// sorted by length of route, the longest first
+	rules []Rule
+	now   func() time.Time
+
+	mx        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route  string
+	client string
+}
+
+type bucket struct {
+	rule   *Rule
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens accumulated since the last request
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.rule.Burst), b.tokens+now.Sub(b.last).Seconds()*b.rule.Rate)
+	b.last = now
+}
+
+// NewLimiter creates limiter with rules, requests which don't match any rule aren't limited
+func NewLimiter(rules []Rule) *Limiter {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Route == AnyRoute || sorted[j].Route == AnyRoute {
+			return sorted[j].Route == AnyRoute && sorted[i].Route != AnyRoute
+		}
+		return len(sorted[i].Route) > len(sorted[j].Route)
+	})
+	return &Limiter{rules: sorted, now: time.Now, buckets: make(map[bucketKey]*bucket)}
+}
+
+// Allow takes token of client for request to route. If bucket is empty,
+// it returns false and time after which request will be allowed
+func (l *Limiter) Allow(route, client string) (bool, time.Duration) {
+	rule := l.match(route)
+	if rule == nil {
+		return true, 0
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	k := bucketKey{rule.Route, client}
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{rule: rule, tokens: float64(rule.Burst), last: now}
+		l.buckets[k] = b
+	}
+	b.refill(now)
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// match returns rule of route, nil if route isn't limited
+func (l *Limiter) match(route string) *Rule {
+	for i := range l.rules {
+		if l.rules[i].Route == AnyRoute || strings.HasPrefix(route, l.rules[i].Route) {
+			return &l.rules[i]
+		}
+	}
+	return nil
+}
+
+// sweep removes full buckets, they are the same as new ones
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rule.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
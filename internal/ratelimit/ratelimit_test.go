@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("/updates/=10:20, *=0.5")
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Route: "/updates/", Rate: 10, Burst: 20}, {Route: AnyRoute, Rate: 0.5, Burst: 1}}, rules)
+
+	for _, s := range []string{"/updates/", "=1", "/a=0", "/a=x", "/a=1:0"} {
+		_, err := ParseRules(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	l := NewLimiter([]Rule{
+		{Route: AnyRoute, Rate: 100, Burst: 100},
+		{Route: "/update", Rate: 1, Burst: 5},
+		{Route: "/updates/", Rate: 2, Burst: 2},
+	})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// the longest route wins
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("/updates/", "10.0.0.1")
+		require.True(t, ok)
+	}
+	ok, wait := l.Allow("/updates/", "10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// buckets of other clients and routes are independent
+	ok, _ = l.Allow("/updates/", "10.0.0.2")
+	assert.True(t, ok)
+	ok, _ = l.Allow("/update/", "10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = l.Allow("/ping", "10.0.0.1")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("/updates/", "10.0.0.1")
+	assert.True(t, ok)
+
+	// idle buckets are removed
+	now = now.Add(sweepInterval)
+	l.Allow("/ping", "10.0.0.1")
+	assert.Len(t, l.buckets, 1)
+}
+
+func TestLimiter_NoRules(t *testing.T) {
+	l := NewLimiter([]Rule{{Route: "/updates/", Rate: 1, Burst: 1}})
+	for i := 0; i < 10; i++ {
+		ok, _ := l.Allow("/value/", "10.0.0.1")
+		assert.True(t, ok)
+	}
+}
@@ -0,0 +1,132 @@
+// Package recording evaluates recording rules: queries which results
+// are stored as new gauges, e.g. HeapUtilization = HeapAlloc / HeapSys * 100
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/query"
+)
+
+// Rule stores result of query as gauge Record
+type Rule struct {
+	// name of gauge for result, series of result keep their labels
+	Record string `json:"record"`
+	Query  string `json:"query"`
+
+	expr query.Expr
+}
+
+// RulesFile is a format of file with rules
+type RulesFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Validate checks rule and parses its query
+func (r *Rule) Validate() error {
+	if r.Record == "" {
+		return errors.New("rule without record name")
+	}
+	if metrics.SanitizeName(r.Record) != r.Record {
+		return fmt.Errorf("rule %s: invalid record name", r.Record)
+	}
+	expr, err := query.Parse(r.Query)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", r.Record, err)
+	}
+	r.expr = expr
+	return nil
+}
+
+// LoadRules reads rules from JSON file
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file RulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{}, len(file.Rules))
+	for i := range file.Rules {
+		if err := file.Rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[file.Rules[i].Record]; ok {
+			return nil, fmt.Errorf("duplicate rule %s", file.Rules[i].Record)
+		}
+		names[file.Rules[i].Record] = struct{}{}
+	}
+	return file.Rules, nil
+}
+
+// Recorder evaluates recording rules
+type Recorder struct {
+	rules []Rule
+	src   query.Source
+}
+
+// NewRecorder creates recorder for validated rules
+func NewRecorder(rules []Rule, src query.Source) *Recorder {
+	return &Recorder{rules: rules, src: src}
+}
+
+// Rules returns rules of recorder
+func (r *Recorder) Rules() []Rule {
+	return r.rules
+}
+
+// Evaluate evaluates all rules at moment now and returns gauges to store.
+// Rules which queries failed are skipped, their errors are joined
+func (r *Recorder) Evaluate(ctx context.Context, now time.Time) ([]metrics.Metric, error) {
+	var errs []error
+	var res []metrics.Metric
+	for i := range r.rules {
+		rule := &r.rules[i]
+		v, err := query.Evaluate(ctx, r.src, rule.expr, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Record, err))
+			continue
+		}
+		for _, s := range v.Series {
+			value := s.Value
+			// e.g. division by zero, such values can't be stored in JSON
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			id := metrics.SeriesName(rule.Record, s.Labels)
+			res = append(res, *metrics.NewCommonMetric(id, metrics.MetricTypeGauge, nil, &value))
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// Run evaluates rules every interval and passes results to store until ctx is done
+func (r *Recorder) Run(ctx context.Context, interval time.Duration,
+	store func(context.Context, []metrics.Metric) error, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m, err := r.Evaluate(ctx, time.Now())
+		if len(m) > 0 {
+			err = errors.Join(err, store(ctx, m))
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package recording
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/history"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSource struct {
+	*memstorage.MemStorage
+	*history.Store
+}
+
+func (s testSource) Samples(_ context.Context, mtype, id string, from time.Time) ([]history.Sample, error) {
+	return s.Store.Samples(mtype, id, from), nil
+}
+
+func newRule(t *testing.T, r Rule) Rule {
+	require.NoError(t, r.Validate())
+	return r
+}
+
+func TestRecorder_Evaluate(t *testing.T) {
+	st := memstorage.NewMemStorage()
+	st.Gauges["HeapAlloc"] = 1024
+	st.Gauges["HeapSys"] = 4096
+	st.Gauges["Zero"] = 0
+	st.Gauges[`disk_used{host="a"}`] = 50
+	st.Gauges[`disk_total{host="a"}`] = 200
+	r := NewRecorder([]Rule{
+		newRule(t, Rule{Record: "HeapUtilization", Query: "HeapAlloc / HeapSys * 100"}),
+		newRule(t, Rule{Record: "disk_usage", Query: "disk_used / disk_total"}),
+		newRule(t, Rule{Record: "Answer", Query: "40 + 2"}),
+		newRule(t, Rule{Record: "Infinite", Query: "HeapAlloc / Zero"}),
+	}, testSource{MemStorage: st, Store: history.New(10)})
+
+	m, err := r.Evaluate(context.Background(), time.Now())
+	require.NoError(t, err)
+
+	got := make(map[string]float64, len(m))
+	for _, el := range m {
+		assert.Equal(t, metrics.MetricTypeGauge, el.MType)
+		got[el.ID] = *el.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"HeapUtilization":      25,
+		`disk_usage{host="a"}`: 0.25,
+		"Answer":               42,
+	}, got)
+}
+
+func TestRecorder_EvaluateError(t *testing.T) {
+	st := memstorage.NewMemStorage()
+	st.Gauges[`a{host="x"}`] = 1
+	st.Gauges[`b{host="x"}`] = 1
+	r := NewRecorder([]Rule{
+		newRule(t, Rule{Record: "Ok", Query: "1"}),
+		// several series on the right side with equal labels
+		newRule(t, Rule{Record: "Broken", Query: `a / {host="x"}`}),
+	}, testSource{MemStorage: st, Store: history.New(10)})
+
+	m, err := r.Evaluate(context.Background(), time.Now())
+	assert.Error(t, err)
+	require.Len(t, m, 1)
+	assert.Equal(t, "Ok", m[0].ID)
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	rules, err := LoadRules(write(`{"rules": [
+		{"record": "HeapUtilization", "query": "HeapAlloc / HeapSys * 100"}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.NotNil(t, rules[0].expr)
+
+	invalid := []string{
+		`{"rules": [{"record": "", "query": "x"}]}`,
+		`{"rules": [{"record": "heap usage", "query": "x"}]}`,
+		`{"rules": [{"record": "A", "query": "sum("}]}`,
+		`{"rules": [{"record": "A", "query": "x"}, {"record": "A", "query": "y"}]}`,
+	}
+	for _, content := range invalid {
+		_, err := LoadRules(write(content))
+		assert.Error(t, err, content)
+	}
+}
@@ -0,0 +1,167 @@
+// Package replication keeps hot replica of in-memory storage of leader server.
+// Follower subscribes to Replication service of leader, receives full state
+// of leader in several parts and then current values of changed metrics
+package replication
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kvvPro/metric-collector/internal/auth"
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	ip "github.com/kvvPro/metric-collector/internal/net"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// ErrLeaseExpired is returned by Run if leader didn't send events during lease
+var ErrLeaseExpired = errors.New("lease of leader expired")
+
+// Store is a storage of replica
+type Store interface {
+	// Reset replaces all metrics by m
+	Reset(ctx context.Context, m []metrics.Metric) error
+	// SetBatch sets values of metrics m, counters are replaced instead of incrementing
+	SetBatch(ctx context.Context, m []metrics.Metric) error
+}
+
+// Config is a settings of follower
+type Config struct {
+	// address host:port of Replication service of leader
+	Leader string
+	// address of follower sent to leader
+	Self string
+	// follower stops replication if leader didn't send events during lease, 0 means no lease
+	Lease time.Duration
+	// delay before reconnection to leader
+	ReconnectDelay time.Duration
+	// token of follower if leader authenticates clients
+	Token string
+	// TLS settings of connection to leader, nil for insecure connection
+	TLS *tls.Config
+}
+
+// Follower replicates state of leader to store
+type Follower struct {
+	cfg    Config
+	store  Store
+	conn   *grpc.ClientConn
+	client pb.ReplicationClient
+	// unix nanoseconds of the last received event
+	lastEvent atomic.Int64
+}
+
+// NewFollower creates follower, Run must be called to replicate metrics
+func NewFollower(cfg Config, store Store) (*Follower, error) {
+	if cfg.Leader == "" {
+		return nil, errors.New("address of leader isn't set")
+	}
+	if cfg.Lease < 0 || cfg.ReconnectDelay < 0 {
+		return nil, errors.New("lease and reconnect delay can't be negative")
+	}
+	// connection is established on the first call
+	creds := insecure.NewCredentials()
+	if cfg.TLS != nil {
+		creds = credentials.NewTLS(cfg.TLS)
+	}
+	conn, err := grpc.Dial(cfg.Leader, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &Follower{cfg: cfg, store: store, conn: conn, client: pb.NewReplicationClient(conn)}, nil
+}
+
+// LastEvent returns time of the last event received from leader, zero time if there were no events
+func (f *Follower) LastEvent() time.Time {
+	ns := f.lastEvent.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Run replicates state of leader until ctx is done or lease expires, connection to leader
+// is restored after errors which are passed to onError. Returns ErrLeaseExpired or error of ctx.
+// Connection to leader is closed on return
+func (f *Follower) Run(ctx context.Context, onError func(error)) error {
+	defer f.conn.Close()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	alive := func() {}
+	if f.cfg.Lease > 0 {
+		// lease starts at once, so follower also stops if leader is never available
+		lease := time.AfterFunc(f.cfg.Lease, func() { cancel(ErrLeaseExpired) })
+		defer lease.Stop()
+		alive = func() { lease.Reset(f.cfg.Lease) }
+	}
+
+	for {
+		err := f.follow(ctx, alive)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-time.After(f.cfg.ReconnectDelay):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// follow applies events of one subscription
+func (f *Follower) follow(ctx context.Context, alive func()) error {
+	// leader which address can't be resolved is unavailable, follower retries or is promoted
+	localIP, err := ip.OutboundIP(f.cfg.Leader)
+	if err != nil {
+		return err
+	}
+	md := metadata.New(map[string]string{"X-Real-IP": localIP.String()})
+	if f.cfg.Token != "" {
+		md.Set(auth.Header, auth.Bearer(f.cfg.Token))
+	}
+	stream, err := f.client.Subscribe(metadata.NewOutgoingContext(ctx, md), &pb.SubscribeRequest{Follower: f.cfg.Self})
+	if err != nil {
+		return err
+	}
+
+	// parts of full state of leader received before its last part
+	var snapshot []metrics.Metric
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		alive()
+		f.lastEvent.Store(time.Now().UnixNano())
+
+		m := make([]metrics.Metric, 0, len(event.Metrics))
+		for _, el := range event.Metrics {
+			m = append(m, metrics.Metric{ID: el.ID, MType: el.MType, Delta: el.Delta, Value: el.Value})
+		}
+		if event.Snapshot {
+			snapshot = append(snapshot, m...)
+			if event.SnapshotEnd {
+				err = f.store.Reset(ctx, snapshot)
+				snapshot = nil
+			}
+		} else if len(m) > 0 {
+			err = f.store.SetBatch(ctx, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+	pb "github.com/kvvPro/metric-collector/proto"
+)
+
+// testLeader sends events of events channel to each subscriber
+type testLeader struct {
+	pb.UnimplementedReplicationServer
+	events chan *pb.ReplicationEvent
+}
+
+func (l *testLeader) Subscribe(in *pb.SubscribeRequest, stream pb.Replication_SubscribeServer) error {
+	for {
+		select {
+		case e := <-l.events:
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func startLeader(t *testing.T) (*testLeader, string) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	leader := &testLeader{events: make(chan *pb.ReplicationEvent)}
+	s := grpc.NewServer()
+	pb.RegisterReplicationServer(s, leader)
+	go s.Serve(listen)
+	t.Cleanup(s.Stop)
+	return leader, listen.Addr().String()
+}
+
+func protoCounter(id string, total int64) *pb.Metric {
+	return &pb.Metric{ID: id, MType: metrics.MetricTypeCounter, Delta: &total}
+}
+
+func protoGauge(id string, value float64) *pb.Metric {
+	return &pb.Metric{ID: id, MType: metrics.MetricTypeGauge, Value: &value}
+}
+
+func TestFollower_Run(t *testing.T) {
+	leader, addr := startLeader(t)
+	store := memstorage.NewMemStorage()
+	delta := int64(100)
+	require.NoError(t, store.UpdateBatch(context.Background(), []metrics.Metric{{ID: "Stale", MType: metrics.MetricTypeCounter, Delta: &delta}}))
+
+	f, err := NewFollower(Config{Leader: addr, Lease: time.Minute}, store)
+	require.NoError(t, err)
+	assert.True(t, f.LastEvent().IsZero())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var runErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runErr = f.Run(ctx, nil)
+	}()
+
+	// snapshot in two parts replaces state once
+	leader.events <- &pb.ReplicationEvent{Snapshot: true, Metrics: []*pb.Metric{protoCounter("PollCount", 5)}}
+	leader.events <- &pb.ReplicationEvent{Snapshot: true, SnapshotEnd: true, Metrics: []*pb.Metric{protoGauge("Alloc", 1)}}
+	// heartbeat
+	leader.events <- &pb.ReplicationEvent{}
+	leader.events <- &pb.ReplicationEvent{Metrics: []*pb.Metric{protoCounter("PollCount", 8), protoGauge("HeapAlloc", 2)}}
+
+	require.Eventually(t, func() bool {
+		got, err := store.GetValue(context.Background(), metrics.MetricTypeGauge, "HeapAlloc")
+		return err == nil && got == 2.0
+	}, time.Second, 10*time.Millisecond)
+	all, err := store.GetAllMetricsNew(context.Background())
+	require.NoError(t, err)
+	values := make(map[string]float64, len(all))
+	for _, el := range all {
+		if el.MType == metrics.MetricTypeCounter {
+			values[el.ID] = float64(*el.Delta)
+		} else {
+			values[el.ID] = *el.Value
+		}
+	}
+	// state before snapshot is dropped, counters are replaced by totals of leader
+	assert.Equal(t, map[string]float64{"PollCount": 8, "Alloc": 1, "HeapAlloc": 2}, values)
+	assert.False(t, f.LastEvent().IsZero())
+
+	cancel()
+	wg.Wait()
+	assert.ErrorIs(t, runErr, context.Canceled)
+}
+
+func TestFollower_LeaseExpired(t *testing.T) {
+	leader, addr := startLeader(t)
+	f, err := NewFollower(Config{Leader: addr, Lease: 200 * time.Millisecond, ReconnectDelay: 10 * time.Millisecond}, memstorage.NewMemStorage())
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Run(context.Background(), nil)
+	}()
+
+	// events of leader extend lease
+	for i := 0; i < 5; i++ {
+		leader.events <- &pb.ReplicationEvent{}
+		time.Sleep(100 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("follower stopped while leader is alive: %v", err)
+	default:
+	}
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, ErrLeaseExpired), err)
+	case <-time.After(time.Second):
+		t.Fatal("lease didn't expire")
+	}
+}
+
+func TestFollower_UnresolvedLeader(t *testing.T) {
+	f, err := NewFollower(Config{Leader: "leader.invalid:3200", Lease: 200 * time.Millisecond, ReconnectDelay: 10 * time.Millisecond}, memstorage.NewMemStorage())
+	require.NoError(t, err)
+
+	// leader which went away is retried until lease expires, so follower can be promoted
+	var failures atomic.Int64
+	err = f.Run(context.Background(), func(error) { failures.Add(1) })
+	assert.ErrorIs(t, err, ErrLeaseExpired)
+	assert.Positive(t, failures.Load())
+}
+
+func TestNewFollower_Invalid(t *testing.T) {
+	_, err := NewFollower(Config{}, memstorage.NewMemStorage())
+	assert.Error(t, err)
+	_, err = NewFollower(Config{Leader: "localhost:3200", Lease: -time.Second}, memstorage.NewMemStorage())
+	assert.Error(t, err)
+}
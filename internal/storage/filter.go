@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+// Sort orders of ListMetrics
+const (
+	// by name, and then by type
+	SortByName = "name"
+	// by type, and then by name
+	SortByType = "type"
+)
+
+// LabelMatcher selects metrics by value of label encoded in series name
+type LabelMatcher struct {
+	Name  string
+	Value string
+	// true if label must not be equal to Value
+	Negative bool
+}
+
+// Cursor is a key of metric, listing starts after it
+type Cursor struct {
+	MType string
+	ID    string
+}
+
+// Filter selects metrics in ListMetrics. Zero value selects all metrics sorted by name
+type Filter struct {
+	// type of metrics, all types if empty
+	MType string
+	// prefix of metric name
+	Prefix string
+	// regular expression which must match metric name, only syntax common for
+	// RE2 and POSIX regular expressions should be used
+	Regex string
+	// matchers of labels, all of them must match
+	Labels []LabelMatcher
+	// SortByName or SortByType, SortByName if empty
+	SortBy string
+	// true for descending order
+	Desc bool
+	// key of the last metric of previous page
+	After *Cursor
+	// max number of returned metrics, 0 means no limit
+	Limit int
+}
+
+// Validate checks that filter can be applied
+func (f *Filter) Validate() error {
+	if f.MType != "" && f.MType != metrics.MetricTypeCounter && f.MType != metrics.MetricTypeGauge {
+		return errors.New("uknown metric type")
+	}
+	if f.SortBy != "" && f.SortBy != SortByName && f.SortBy != SortByType {
+		return errors.New("unknown sort order")
+	}
+	if f.Limit < 0 {
+		return errors.New("invalid limit")
+	}
+	if f.Regex != "" {
+		if _, err := regexp.Compile(f.Regex); err != nil {
+			return err
+		}
+	}
+	for _, el := range f.Labels {
+		if el.Name == "" {
+			return errors.New("empty label name")
+		}
+	}
+	return nil
+}
+
+// Matcher checks metrics against filter in memory
+type Matcher struct {
+	filter Filter
+	regex  *regexp.Regexp
+	labels []*regexp.Regexp
+}
+
+// NewMatcher compiles filter f
+func NewMatcher(f Filter) (*Matcher, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	m := &Matcher{filter: f}
+	if f.Regex != "" {
+		m.regex = regexp.MustCompile(f.Regex)
+	}
+	for _, el := range f.Labels {
+		m.labels = append(m.labels, regexp.MustCompile(metrics.LabelPattern(el.Name, el.Value)))
+	}
+	return m, nil
+}
+
+// Match returns true if metric with type mtype and name id is selected by filter,
+// position of metric relative to cursor is not checked
+func (m *Matcher) Match(mtype, id string) bool {
+	if m.filter.MType != "" && m.filter.MType != mtype {
+		return false
+	}
+	if !strings.HasPrefix(id, m.filter.Prefix) {
+		return false
+	}
+	if m.regex != nil && !m.regex.MatchString(id) {
+		return false
+	}
+	for i, re := range m.labels {
+		if re.MatchString(id) == m.filter.Labels[i].Negative {
+			return false
+		}
+	}
+	return true
+}
+
+// Less orders metrics as filter requires
+func (m *Matcher) Less(a, b Cursor) bool {
+	first, second := [2]string{a.ID, a.MType}, [2]string{b.ID, b.MType}
+	if m.filter.SortBy == SortByType {
+		first, second = [2]string{a.MType, a.ID}, [2]string{b.MType, b.ID}
+	}
+	less := first[0] < second[0] || first[0] == second[0] && first[1] < second[1]
+	if m.filter.Desc {
+		return !less && first != second
+	}
+	return less
+}
+
+// AfterCursor returns true if metric is placed after cursor of filter
+func (m *Matcher) AfterCursor(c Cursor) bool {
+	return m.filter.After == nil || m.Less(*m.filter.After, c)
+}
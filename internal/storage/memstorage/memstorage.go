@@ -4,18 +4,23 @@ import (
 	"context"
 	"errors"
 	_ "net/http/pprof"
+	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
 )
 
 type MemStorage struct {
+	// guards maps, storage is used by concurrent requests
+	mx       sync.RWMutex
 	Gauges   map[string]float64
 	Counters map[string]int64
 }
 
-func NewMemStorage() MemStorage {
-	return MemStorage{
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
 		Gauges:   make(map[string]float64),
 		Counters: make(map[string]int64),
 	}
@@ -26,6 +31,9 @@ func (s *MemStorage) Ping(ctx context.Context) error {
 }
 
 func (s *MemStorage) Update(ctx context.Context, t string, n string, v string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
 	if t == metrics.MetricTypeGauge {
 		if fval, err := strconv.ParseFloat(v, 64); err == nil {
 			s.Gauges[n] = fval
@@ -41,6 +49,9 @@ func (s *MemStorage) Update(ctx context.Context, t string, n string, v string) e
 }
 
 func (s *MemStorage) UpdateNew(ctx context.Context, t string, n string, delta *int64, value *float64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
 	if t == metrics.MetricTypeGauge {
 		if value == nil {
 			val := new(float64)
@@ -62,6 +73,9 @@ func (s *MemStorage) UpdateNew(ctx context.Context, t string, n string, delta *i
 }
 
 func (s *MemStorage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
 	for _, el := range m {
 		if el.MType == metrics.MetricTypeGauge {
 			if el.Value == nil {
@@ -85,7 +99,51 @@ func (s *MemStorage) UpdateBatch(ctx context.Context, m []metrics.Metric) error
 	return nil
 }
 
+// SetBatch sets values of metrics m, counters are replaced instead of incrementing
+func (s *MemStorage) SetBatch(ctx context.Context, m []metrics.Metric) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.set(m)
+}
+
+// Reset replaces all metrics of storage by metrics m
+func (s *MemStorage) Reset(ctx context.Context, m []metrics.Metric) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.Gauges = make(map[string]float64, len(m))
+	s.Counters = make(map[string]int64, len(m))
+	return s.set(m)
+}
+
+// set must be called under write lock
+func (s *MemStorage) set(m []metrics.Metric) error {
+	for _, el := range m {
+		switch el.MType {
+		case metrics.MetricTypeGauge:
+			var val float64
+			if el.Value != nil {
+				val = *el.Value
+			}
+			s.Gauges[el.ID] = val
+		case metrics.MetricTypeCounter:
+			var val int64
+			if el.Delta != nil {
+				val = *el.Delta
+			}
+			s.Counters[el.ID] = val
+		default:
+			return errors.New("uknown metric type")
+		}
+	}
+	return nil
+}
+
 func (s *MemStorage) GetValue(ctx context.Context, t string, n string) (any, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
 	var val any
 	var exists bool
 
@@ -97,13 +155,16 @@ func (s *MemStorage) GetValue(ctx context.Context, t string, n string) (any, err
 		return nil, errors.New("uknown metric type")
 	}
 	if !exists {
-		return nil, errors.New("metric not found")
+		return nil, storage.ErrNotFound
 	}
 
 	return val, nil
 }
 
 func (s *MemStorage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
 	m := []*metrics.Metric{}
 
 	for name, val := range s.Counters {
@@ -120,3 +181,39 @@ func (s *MemStorage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, e
 
 	return m, nil
 }
+
+func (s *MemStorage) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	matcher, err := storage.NewMatcher(f)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mx.RLock()
+	m := []*metrics.Metric{}
+	for name, val := range s.Counters {
+		if !matcher.Match(metrics.MetricTypeCounter, name) ||
+			!matcher.AfterCursor(storage.Cursor{MType: metrics.MetricTypeCounter, ID: name}) {
+			continue
+		}
+		newVal := val
+		m = append(m, metrics.NewCommonMetric(name, metrics.MetricTypeCounter, &newVal, nil))
+	}
+	for name, val := range s.Gauges {
+		if !matcher.Match(metrics.MetricTypeGauge, name) ||
+			!matcher.AfterCursor(storage.Cursor{MType: metrics.MetricTypeGauge, ID: name}) {
+			continue
+		}
+		newVal := val
+		m = append(m, metrics.NewCommonMetric(name, metrics.MetricTypeGauge, nil, &newVal))
+	}
+	s.mx.RUnlock()
+
+	sort.Slice(m, func(i, j int) bool {
+		return matcher.Less(storage.Cursor{MType: m[i].MType, ID: m[i].ID}, storage.Cursor{MType: m[j].MType, ID: m[j].ID})
+	})
+	if f.Limit > 0 && len(m) > f.Limit {
+		m = m[:f.Limit]
+	}
+
+	return m, nil
+}
@@ -5,13 +5,14 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/kvvPro/metric-collector/internal/metrics"
 	"github.com/kvvPro/metric-collector/internal/storage"
 )
 
 func TestNewMemStorage(t *testing.T) {
 	tests := []struct {
 		name string
-		want MemStorage
+		want *MemStorage
 	}{
 		// TODO: Add test cases.
 	}
@@ -115,3 +116,123 @@ func TestMemStorage_GetValue(t *testing.T) {
 		})
 	}
 }
+
+func TestMemStorage_ListMetrics(t *testing.T) {
+	s := &MemStorage{
+		Gauges: map[string]float64{
+			"Alloc":                    1,
+			"HeapAlloc":                2,
+			`cpu{host="a",zone="x"}`:   3,
+			`cpu{host="b",zone="x"}`:   4,
+			`cpu{host="a.b",zone="y"}`: 5,
+		},
+		Counters: map[string]int64{
+			"PollCount": 1,
+			"Alloc":     2,
+		},
+	}
+	key := func(m []*metrics.Metric) []string {
+		res := make([]string, 0, len(m))
+		for _, el := range m {
+			res = append(res, el.MType+":"+el.ID)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name    string
+		filter  storage.Filter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "all by name",
+			filter: storage.Filter{},
+			want: []string{"counter:Alloc", "gauge:Alloc", "gauge:HeapAlloc", "counter:PollCount",
+				`gauge:cpu{host="a",zone="x"}`, `gauge:cpu{host="a.b",zone="y"}`, `gauge:cpu{host="b",zone="x"}`},
+		},
+		{
+			name:   "by type descending with limit",
+			filter: storage.Filter{SortBy: storage.SortByType, Desc: true, Limit: 3},
+			want:   []string{`gauge:cpu{host="b",zone="x"}`, `gauge:cpu{host="a.b",zone="y"}`, `gauge:cpu{host="a",zone="x"}`},
+		},
+		{
+			name:   "type and prefix",
+			filter: storage.Filter{MType: metrics.MetricTypeCounter, Prefix: "Poll"},
+			want:   []string{"counter:PollCount"},
+		},
+		{
+			name:   "regex",
+			filter: storage.Filter{Regex: "^(Heap)?Alloc$", MType: metrics.MetricTypeGauge},
+			want:   []string{"gauge:Alloc", "gauge:HeapAlloc"},
+		},
+		{
+			name: "labels",
+			filter: storage.Filter{Labels: []storage.LabelMatcher{
+				{Name: "zone", Value: "x"},
+				{Name: "host", Value: "b", Negative: true},
+			}},
+			want: []string{`gauge:cpu{host="a",zone="x"}`},
+		},
+		{
+			name:   "after cursor",
+			filter: storage.Filter{After: &storage.Cursor{MType: metrics.MetricTypeGauge, ID: "Alloc"}, Limit: 2},
+			want:   []string{"gauge:HeapAlloc", "counter:PollCount"},
+		},
+		{
+			name:    "invalid regex",
+			filter:  storage.Filter{Regex: "("},
+			wantErr: true,
+		},
+		{
+			name:    "invalid sort",
+			filter:  storage.Filter{SortBy: "value"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ListMetrics(context.Background(), tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MemStorage.ListMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(key(got), tt.want) {
+				t.Errorf("MemStorage.ListMetrics() = %v, want %v", key(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestMemStorage_Reset(t *testing.T) {
+	s := NewMemStorage()
+	delta := int64(5)
+	value := 1.5
+	if err := s.UpdateBatch(context.Background(), []metrics.Metric{
+		{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &delta},
+		{ID: "Alloc", MType: metrics.MetricTypeGauge, Value: &value},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	total := int64(7)
+	if err := s.SetBatch(context.Background(), []metrics.Metric{{ID: "PollCount", MType: metrics.MetricTypeCounter, Delta: &total}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Counters["PollCount"] != 7 {
+		t.Errorf("MemStorage.SetBatch() counter = %v, want 7", s.Counters["PollCount"])
+	}
+
+	if err := s.Reset(context.Background(), []metrics.Metric{{ID: "HeapAlloc", MType: metrics.MetricTypeGauge, Value: &value}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := (map[string]float64{"HeapAlloc": 1.5}); !reflect.DeepEqual(s.Gauges, want) || len(s.Counters) != 0 {
+		t.Errorf("MemStorage.Reset() = %v %v, want %v", s.Gauges, s.Counters, want)
+	}
+
+	if err := s.SetBatch(context.Background(), []metrics.Metric{{ID: "x", MType: "unknown"}}); err == nil {
+		t.Error("MemStorage.SetBatch() expected error for unknown type")
+	}
+}
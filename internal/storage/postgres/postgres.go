@@ -4,8 +4,11 @@ import (
 	"context"
 	"errors"
 	_ "net/http/pprof"
+	"strconv"
+	"strings"
 
 	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -321,7 +324,52 @@ func getInitQuery() string {
 
 // Deprecated: use GetAllMetricsNew
 func (s *PostgresStorage) GetValue(ctx context.Context, t string, n string) (any, error) {
-	return nil, errors.New("func is deprecated")
+	dbpool, err := pgxpool.New(ctx, s.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbpool.Close()
+
+	var val any
+	switch t {
+	case metrics.MetricTypeCounter:
+		var delta int64
+		err = dbpool.QueryRow(ctx, getCounterValueQuery(), n).Scan(&delta)
+		val = delta
+	case metrics.MetricTypeGauge:
+		var value float64
+		err = dbpool.QueryRow(ctx, getGaugeValueQuery(), n).Scan(&value)
+		val = value
+	default:
+		return nil, errors.New("uknown metric type")
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+func getCounterValueQuery() string {
+	return `
+	SELECT counters.delta
+	FROM public.counters INNER JOIN public.metrics
+		ON counters.metric_id = metrics.id
+	WHERE metrics.metric_name = $1
+	`
+}
+
+func getGaugeValueQuery() string {
+	return `
+	SELECT gauges.value
+	FROM public.gauges INNER JOIN public.metrics
+		ON gauges.metric_id = metrics.id
+	WHERE metrics.metric_name = $1
+	`
 }
 
 func (s *PostgresStorage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
@@ -376,3 +424,126 @@ func (s *PostgresStorage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metr
 
 	return m, nil
 }
+
+func (s *PostgresStorage) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	dbpool, err := pgxpool.New(ctx, s.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbpool.Close()
+
+	query, args := getListMetricsQuery(f)
+	result, err := dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer result.Close()
+
+	m := []*metrics.Metric{}
+	for result.Next() {
+		var metric metrics.Metric
+		err = result.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, &metric)
+	}
+
+	err = result.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// getListMetricsQuery builds query selecting metrics by filter and its arguments
+func getListMetricsQuery(f storage.Filter) (string, []any) {
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.MType != "" {
+		conditions = append(conditions, "MetricType = "+arg(f.MType))
+	}
+	if f.Prefix != "" {
+		conditions = append(conditions, "MetricName LIKE "+arg(likePrefix(f.Prefix))+` ESCAPE '\'`)
+	}
+	if f.Regex != "" {
+		conditions = append(conditions, "MetricName ~ "+arg(f.Regex))
+	}
+	for _, el := range f.Labels {
+		op := " ~ "
+		if el.Negative {
+			op = " !~ "
+		}
+		conditions = append(conditions, "MetricName"+op+arg(metrics.LabelPattern(el.Name, el.Value)))
+	}
+
+	key := "(MetricName, MetricType)"
+	if f.SortBy == storage.SortByType {
+		key = "(MetricType, MetricName)"
+	}
+	cmp, order := " > ", " ASC"
+	if f.Desc {
+		cmp, order = " < ", " DESC"
+	}
+	if f.After != nil {
+		first, second := arg(f.After.ID), arg(f.After.MType)
+		if f.SortBy == storage.SortByType {
+			first, second = second, first
+		}
+		conditions = append(conditions, key+cmp+"("+first+", "+second+")")
+	}
+
+	query := `
+	SELECT MetricName, MetricType, Delta, Value
+	FROM (
+		SELECT metrics.metric_name as MetricName,
+				'counter' as MetricType,
+				counters.delta as Delta,
+				NULL::double precision as Value
+		FROM
+			public.counters INNER JOIN public.metrics
+			ON counters.metric_id = metrics.id
+
+		UNION ALL
+
+		SELECT metrics.metric_name as MetricName,
+				'gauge' as MetricType,
+				NULL::bigint as Delta,
+				gauges.value as Value
+		FROM
+			public.gauges INNER JOIN public.metrics
+			ON gauges.metric_id = metrics.id
+	) AS all_metrics
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	if f.SortBy == storage.SortByType {
+		query += "ORDER BY MetricType" + order + ", MetricName" + order + "\n"
+	} else {
+		query += "ORDER BY MetricName" + order + ", MetricType" + order + "\n"
+	}
+	if f.Limit > 0 {
+		query += "LIMIT " + arg(f.Limit) + "\n"
+	}
+
+	return query, args
+}
+
+// likePrefix makes LIKE pattern matching strings with prefix
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
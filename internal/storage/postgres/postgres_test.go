@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+func Test_getListMetricsQuery(t *testing.T) {
+	query, args := getListMetricsQuery(storage.Filter{
+		MType:  "gauge",
+		Prefix: "heap_",
+		Labels: []storage.LabelMatcher{{Name: "host", Value: "a", Negative: true}},
+		SortBy: storage.SortByType,
+		Desc:   true,
+		After:  &storage.Cursor{MType: "gauge", ID: "heap_alloc"},
+		Limit:  10,
+	})
+
+	for _, part := range []string{
+		"MetricType = $1",
+		`MetricName LIKE $2 ESCAPE '\'`,
+		"MetricName !~ $3",
+		"(MetricType, MetricName) < ($5, $4)",
+		"ORDER BY MetricType DESC, MetricName DESC",
+		"LIMIT $6",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("getListMetricsQuery() query doesn't contain %q:\n%s", part, query)
+		}
+	}
+	want := []any{"gauge", `heap\_%`, `[{,]host="a"[,}]`, "heap_alloc", "gauge", 10}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("getListMetricsQuery() args = %v, want %v", args, want)
+	}
+}
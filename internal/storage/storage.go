@@ -2,10 +2,14 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/kvvPro/metric-collector/internal/metrics"
 )
 
+// ErrNotFound is returned by GetValue if metric doesn't exist
+var ErrNotFound = errors.New("metric not found")
+
 type Metric interface {
 	GetName() string
 	GetType() string
@@ -20,4 +24,6 @@ type Storage interface {
 	UpdateBatch(ctx context.Context, m []metrics.Metric) error
 	GetValue(ctx context.Context, t string, n string) (any, error)
 	GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error)
+	// ListMetrics returns metrics selected by filter in order required by filter
+	ListMetrics(ctx context.Context, f Filter) ([]*metrics.Metric, error)
 }
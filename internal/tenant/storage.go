@@ -0,0 +1,200 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+)
+
+// Storage scopes requests to inner storage by tenant of context: names of metrics
+// are prefixed by tenant id, and tenant sees only its metrics. Requests without
+// tenant in context access metrics of all tenants with names as they are stored.
+// It implements storage.Storage
+type Storage struct {
+	inner    storage.Storage
+	registry *Registry
+
+	mx     sync.Mutex
+	series map[string]*seriesSet
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// seriesSet keeps known series of tenant with limit
+type seriesSet struct {
+	mx     sync.Mutex
+	loaded bool
+	keys   map[storage.Cursor]struct{}
+}
+
+// NewStorage creates storage of tenants of registry
+func NewStorage(inner storage.Storage, registry *Registry) *Storage {
+	return &Storage{inner: inner, registry: registry, series: make(map[string]*seriesSet)}
+}
+
+// Unscoped returns inner storage
+func (s *Storage) Unscoped() storage.Storage {
+	return s.inner
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.inner.Ping(ctx)
+}
+
+func (s *Storage) Update(ctx context.Context, t string, n string, v string) error {
+	id := FromContext(ctx)
+	m := []metrics.Metric{{ID: Scope(id, n), MType: t}}
+	return s.write(ctx, id, m, func() error {
+		return s.inner.Update(ctx, t, m[0].ID, v)
+	})
+}
+
+func (s *Storage) UpdateNew(ctx context.Context, t string, n string, delta *int64, value *float64) error {
+	id := FromContext(ctx)
+	m := []metrics.Metric{{ID: Scope(id, n), MType: t}}
+	return s.write(ctx, id, m, func() error {
+		return s.inner.UpdateNew(ctx, t, m[0].ID, delta, value)
+	})
+}
+
+func (s *Storage) UpdateBatch(ctx context.Context, m []metrics.Metric) error {
+	id := FromContext(ctx)
+	scoped := make([]metrics.Metric, len(m))
+	for i, el := range m {
+		el.ID = Scope(id, el.ID)
+		scoped[i] = el
+	}
+	return s.write(ctx, id, scoped, func() error {
+		return s.inner.UpdateBatch(ctx, scoped)
+	})
+}
+
+func (s *Storage) GetValue(ctx context.Context, t string, n string) (any, error) {
+	return s.inner.GetValue(ctx, t, Scope(FromContext(ctx), n))
+}
+
+func (s *Storage) GetAllMetricsNew(ctx context.Context) ([]*metrics.Metric, error) {
+	id := FromContext(ctx)
+	if id == "" {
+		return s.inner.GetAllMetricsNew(ctx)
+	}
+	return s.list(ctx, id, storage.Filter{})
+}
+
+func (s *Storage) ListMetrics(ctx context.Context, f storage.Filter) ([]*metrics.Metric, error) {
+	id := FromContext(ctx)
+	if id == "" {
+		return s.inner.ListMetrics(ctx, f)
+	}
+	return s.list(ctx, id, f)
+}
+
+// list selects metrics of tenant id. Names of metrics of tenant have common prefix,
+// so their order in inner storage is the same as order of names without prefix.
+// Regular expressions and labels are matched against names without prefix
+func (s *Storage) list(ctx context.Context, id string, f storage.Filter) ([]*metrics.Metric, error) {
+	matcher, err := storage.NewMatcher(f)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := storage.Filter{
+		MType:  f.MType,
+		Prefix: Scope(id, f.Prefix),
+		SortBy: f.SortBy,
+		Desc:   f.Desc,
+	}
+	if f.After != nil {
+		inner.After = &storage.Cursor{MType: f.After.MType, ID: Scope(id, f.After.ID)}
+	}
+	// limit can be passed to inner storage only if all its metrics are selected
+	if f.Regex == "" && len(f.Labels) == 0 {
+		inner.Limit = f.Limit
+	}
+	list, err := s.inner.ListMetrics(ctx, inner)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*metrics.Metric, 0, len(list))
+	for _, el := range list {
+		name, ok := Unscope(id, el.ID)
+		if !ok || !matcher.Match(el.MType, name) {
+			continue
+		}
+		m := *el
+		m.ID = name
+		res = append(res, &m)
+		if f.Limit > 0 && len(res) == f.Limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// Series returns count of series of tenant id
+func (s *Storage) Series(ctx context.Context, id string) (int, error) {
+	list, err := s.inner.ListMetrics(ctx, storage.Filter{Prefix: Scope(id, "")})
+	if err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
+
+// write calls fn which writes scoped metrics m of tenant id, series limit of tenant is checked before
+func (s *Storage) write(ctx context.Context, id string, m []metrics.Metric, fn func() error) error {
+	t, ok := s.registry.Get(id)
+	if id == "" || !ok || t.MaxSeries == 0 {
+		return fn()
+	}
+
+	set := s.seriesSet(id)
+	// writes of tenant with limit are serialized, so concurrent writes can't exceed limit
+	set.mx.Lock()
+	defer set.mx.Unlock()
+
+	if !set.loaded {
+		list, err := s.inner.ListMetrics(ctx, storage.Filter{Prefix: Scope(id, "")})
+		if err != nil {
+			return err
+		}
+		for _, el := range list {
+			set.keys[storage.Cursor{MType: el.MType, ID: el.ID}] = struct{}{}
+		}
+		set.loaded = true
+	}
+
+	added := make(map[storage.Cursor]struct{})
+	for _, el := range m {
+		k := storage.Cursor{MType: el.MType, ID: el.ID}
+		if _, ok := set.keys[k]; !ok {
+			added[k] = struct{}{}
+		}
+	}
+	if len(set.keys)+len(added) > t.MaxSeries {
+		return fmt.Errorf("%w: tenant %s has %d of %d series, %d new series are rejected",
+			ErrSeriesLimit, id, len(set.keys), t.MaxSeries, len(added))
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+	for k := range added {
+		set.keys[k] = struct{}{}
+	}
+	return nil
+}
+
+func (s *Storage) seriesSet(id string) *seriesSet {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	set, ok := s.series[id]
+	if !ok {
+		set = &seriesSet{keys: make(map[storage.Cursor]struct{})}
+		s.series[id] = set
+	}
+	return set
+}
@@ -0,0 +1,122 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+	"github.com/kvvPro/metric-collector/internal/storage"
+	"github.com/kvvPro/metric-collector/internal/storage/memstorage"
+)
+
+func newTestStorage(t *testing.T) (*Storage, *memstorage.MemStorage) {
+	r, err := NewRegistry([]Tenant{{ID: "team-a", MaxSeries: 3}, {ID: "team-b"}})
+	require.NoError(t, err)
+	inner := memstorage.NewMemStorage()
+	return NewStorage(inner, r), inner
+}
+
+func TestStorage_Isolation(t *testing.T) {
+	s, inner := newTestStorage(t)
+	ctxA := WithTenant(context.Background(), "team-a")
+	ctxB := WithTenant(context.Background(), "team-b")
+
+	a, b := 1.5, 2.5
+	require.NoError(t, s.UpdateNew(ctxA, metrics.MetricTypeGauge, "Alloc", nil, &a))
+	require.NoError(t, s.UpdateNew(ctxB, metrics.MetricTypeGauge, "Alloc", nil, &b))
+	require.NoError(t, s.Update(ctxB, metrics.MetricTypeCounter, "PollCount", "3"))
+
+	got, err := s.GetValue(ctxA, metrics.MetricTypeGauge, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got)
+	got, err = s.GetValue(ctxB, metrics.MetricTypeGauge, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, got)
+	_, err = s.GetValue(ctxA, metrics.MetricTypeCounter, "PollCount")
+	assert.Error(t, err)
+
+	all, err := s.GetAllMetricsNew(ctxB)
+	require.NoError(t, err)
+	ids := make([]string, 0)
+	for _, el := range all {
+		ids = append(ids, el.ID)
+	}
+	assert.ElementsMatch(t, []string{"Alloc", "PollCount"}, ids)
+
+	// unscoped requests see names as they are stored
+	raw, err := s.GetAllMetricsNew(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, raw, 3)
+	got, err = inner.GetValue(context.Background(), metrics.MetricTypeGauge, "team-a/Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, got)
+}
+
+func TestStorage_ListMetrics(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctxA := WithTenant(context.Background(), "team-a")
+	ctxB := WithTenant(context.Background(), "team-b")
+
+	var batch []metrics.Metric
+	for i := 0; i < 5; i++ {
+		v := float64(i)
+		batch = append(batch, metrics.Metric{ID: fmt.Sprintf("gauge_%d", i), MType: metrics.MetricTypeGauge, Value: &v})
+	}
+	require.NoError(t, s.UpdateBatch(ctxB, batch))
+	v := 1.0
+	require.NoError(t, s.UpdateNew(ctxA, metrics.MetricTypeGauge, "gauge_9", nil, &v))
+
+	page, err := s.ListMetrics(ctxB, storage.Filter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "gauge_0", page[0].ID)
+	assert.Equal(t, "gauge_1", page[1].ID)
+
+	page, err = s.ListMetrics(ctxB, storage.Filter{Limit: 2, After: &storage.Cursor{MType: page[1].MType, ID: page[1].ID}})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "gauge_2", page[0].ID)
+
+	// regex is matched against names without prefix
+	page, err = s.ListMetrics(ctxB, storage.Filter{Regex: "^gauge_[34]$"})
+	require.NoError(t, err)
+	assert.Len(t, page, 2)
+
+	page, err = s.ListMetrics(ctxA, storage.Filter{Prefix: "gauge"})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "gauge_9", page[0].ID)
+}
+
+func TestStorage_SeriesLimit(t *testing.T) {
+	s, _ := newTestStorage(t)
+	ctx := WithTenant(context.Background(), "team-a")
+
+	v := 1.0
+	batch := []metrics.Metric{
+		{ID: "a", MType: metrics.MetricTypeGauge, Value: &v},
+		{ID: "b", MType: metrics.MetricTypeGauge, Value: &v},
+	}
+	require.NoError(t, s.UpdateBatch(ctx, batch))
+	// updates of known series aren't limited
+	require.NoError(t, s.UpdateBatch(ctx, batch))
+	require.NoError(t, s.UpdateNew(ctx, metrics.MetricTypeGauge, "c", nil, &v))
+
+	err := s.UpdateNew(ctx, metrics.MetricTypeGauge, "d", nil, &v)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+	err = s.Update(ctx, metrics.MetricTypeCounter, "a", "1")
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	n, err := s.Series(context.Background(), "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// other tenants aren't affected by limit
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.UpdateNew(WithTenant(context.Background(), "team-b"), metrics.MetricTypeGauge, fmt.Sprint(i), nil, &v))
+	}
+}
@@ -0,0 +1,178 @@
+// Package tenant isolates metrics of several teams sharing one server.
+// Metrics of tenant are kept in storage with names prefixed by tenant id,
+// so the same name of different tenants doesn't collide
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kvvPro/metric-collector/internal/hash"
+)
+
+const (
+	// Header carries id of tenant in HTTP requests, the same key is used in gRPC metadata
+	Header = "X-Tenant-ID"
+	// SignHeader carries Sign of tenant id, it's required for tenants with key
+	SignHeader = "X-Tenant-Sign"
+	// DefaultID is a tenant of clients which don't send tenant id
+	DefaultID = "default"
+	// separator of tenant id and metric name in storage
+	separator = "/"
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrInvalidSign   = errors.New("invalid sign of tenant")
+	ErrSeriesLimit   = errors.New("series limit of tenant is exceeded")
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Tenant is a settings of tenant
+type Tenant struct {
+	ID string `json:"id"`
+	// hash key of agents of tenant, it's used instead of key of server. Requests of tenant
+	// with key must be signed, see Sign
+	Key string `json:"key,omitempty"`
+	// max count of series of tenant, 0 means no limit
+	MaxSeries int `json:"max_series,omitempty"`
+}
+
+// TenantsFile is a format of file with tenants
+type TenantsFile struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+// Validate checks settings of tenant
+func (t *Tenant) Validate() error {
+	if !validID.MatchString(t.ID) {
+		return fmt.Errorf("invalid tenant id %q", t.ID)
+	}
+	if t.MaxSeries < 0 {
+		return fmt.Errorf("tenant %s: negative series limit", t.ID)
+	}
+	return nil
+}
+
+// LoadTenants reads tenants from JSON file
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file TenantsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return file.Tenants, nil
+}
+
+// Sign returns sign of tenant id made with key of tenant
+func Sign(id, key string) string {
+	return base64.URLEncoding.EncodeToString(hash.GetHashSHA256(id, key))
+}
+
+// Registry keeps settings of all tenants
+type Registry struct {
+	tenants map[string]Tenant
+}
+
+// NewRegistry validates tenants, default tenant without key and limits is added if it isn't set
+func NewRegistry(list []Tenant) (*Registry, error) {
+	r := &Registry{tenants: make(map[string]Tenant, len(list)+1)}
+	for i := range list {
+		if err := list[i].Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := r.tenants[list[i].ID]; ok {
+			return nil, fmt.Errorf("duplicate tenant %s", list[i].ID)
+		}
+		r.tenants[list[i].ID] = list[i]
+	}
+	if _, ok := r.tenants[DefaultID]; !ok {
+		r.tenants[DefaultID] = Tenant{ID: DefaultID}
+	}
+	return r, nil
+}
+
+// Get returns settings of tenant
+func (r *Registry) Get(id string) (Tenant, bool) {
+	t, ok := r.tenants[id]
+	return t, ok
+}
+
+// List returns all tenants sorted by id
+func (r *Registry) List() []Tenant {
+	res := make([]Tenant, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// Authenticate returns tenant with id, empty id means default tenant.
+// Sign is checked if tenant has key
+func (r *Registry) Authenticate(id, sign string) (Tenant, error) {
+	if id == "" {
+		id = DefaultID
+	}
+	t, ok := r.tenants[id]
+	if !ok {
+		return Tenant{}, fmt.Errorf("%w %s", ErrUnknownTenant, id)
+	}
+	if t.Key != "" && !hmac.Equal([]byte(sign), []byte(Sign(t.ID, t.Key))) {
+		return Tenant{}, ErrInvalidSign
+	}
+	return t, nil
+}
+
+type ctxKey struct{}
+
+// WithTenant returns context of requests of tenant id,
+// empty id means access to metrics of all tenants
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns id of tenant set by WithTenant, empty string if requests aren't scoped
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Scope returns name of metric of tenant id in storage
+func Scope(id, name string) string {
+	if id == "" {
+		return name
+	}
+	return id + separator + name
+}
+
+// Split returns tenant id and name of metric by its name in storage,
+// empty id if name isn't scoped to tenant
+func Split(name string) (string, string) {
+	id, rest, ok := strings.Cut(name, separator)
+	if !ok || !validID.MatchString(id) {
+		return "", name
+	}
+	return id, rest
+}
+
+// Unscope returns name of metric of tenant id by its name in storage,
+// false if metric doesn't belong to tenant
+func Unscope(id, name string) (string, bool) {
+	if id == "" {
+		return name, true
+	}
+	return strings.CutPrefix(name, id+separator)
+}
@@ -0,0 +1,88 @@
+package tenant
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry(t *testing.T) {
+	r, err := NewRegistry([]Tenant{{ID: "team-a", Key: "secret", MaxSeries: 10}, {ID: "team_b"}})
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	for _, el := range r.List() {
+		ids = append(ids, el.ID)
+	}
+	// default tenant is added implicitly
+	assert.Equal(t, []string{DefaultID, "team-a", "team_b"}, ids)
+
+	_, err = NewRegistry([]Tenant{{ID: "a/b"}})
+	assert.Error(t, err)
+	_, err = NewRegistry([]Tenant{{ID: "a"}, {ID: "a"}})
+	assert.Error(t, err)
+	_, err = NewRegistry([]Tenant{{ID: "a", MaxSeries: -1}})
+	assert.Error(t, err)
+}
+
+func TestRegistry_Authenticate(t *testing.T) {
+	r, err := NewRegistry([]Tenant{{ID: "team-a", Key: "secret"}, {ID: "team-b"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		id      string
+		sign    string
+		want    string
+		wantErr error
+	}{
+		{name: "default", want: DefaultID},
+		{name: "without key", id: "team-b", want: "team-b"},
+		{name: "signed", id: "team-a", sign: Sign("team-a", "secret"), want: "team-a"},
+		{name: "not signed", id: "team-a", wantErr: ErrInvalidSign},
+		{name: "wrong key", id: "team-a", sign: Sign("team-a", "other"), wantErr: ErrInvalidSign},
+		{name: "unknown", id: "team-c", wantErr: ErrUnknownTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Authenticate(tt.id, tt.sign)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.ID)
+		})
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	data := `{"tenants": [{"id": "team-a", "key": "secret", "max_series": 100}]}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0666))
+
+	list, err := LoadTenants(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Tenant{{ID: "team-a", Key: "secret", MaxSeries: 100}}, list)
+}
+
+func TestScope(t *testing.T) {
+	assert.Equal(t, "team-a/Alloc", Scope("team-a", "Alloc"))
+	assert.Equal(t, "Alloc", Scope("", "Alloc"))
+
+	name, ok := Unscope("team-a", "team-a/Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, "Alloc", name)
+	_, ok = Unscope("team-a", "team-b/Alloc")
+	assert.False(t, ok)
+
+	id, name := Split("team-a/Alloc")
+	assert.Equal(t, "team-a", id)
+	assert.Equal(t, "Alloc", name)
+	id, name = Split("Alloc")
+	assert.Empty(t, id)
+	assert.Equal(t, "Alloc", name)
+}
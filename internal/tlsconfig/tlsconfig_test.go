@@ -0,0 +1,104 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/tlsconfig/tlstest"
+)
+
+func TestServer(t *testing.T) {
+	files := tlstest.Generate(t, "agent-1")
+
+	cfg, err := Server("", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	_, err = Server("", "", files.CA)
+	assert.Error(t, err)
+	_, err = Server(files.ServerCert, "", "")
+	assert.Error(t, err)
+	_, err = Server(files.ServerCert, files.ServerKey, files.ServerKey)
+	assert.Error(t, err)
+
+	cfg, err = Server(files.ServerCert, files.ServerKey, "")
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	cfg, err = Server(files.ServerCert, files.ServerKey, files.CA)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+}
+
+func TestClient(t *testing.T) {
+	files := tlstest.Generate(t, "agent-1")
+
+	cfg, err := Client("", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	_, err = Client("", files.ClientCert, "")
+	assert.Error(t, err)
+
+	cfg, err = Client(files.CA, "", "")
+	require.NoError(t, err)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Empty(t, cfg.Certificates)
+
+	cfg, err = Client(files.CA, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Certificates, 1)
+}
+
+func TestMutualTLS(t *testing.T) {
+	files := tlstest.Generate(t, "agent-1")
+
+	srvCfg, err := Server(files.ServerCert, files.ServerKey, files.CA)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, PeerName(r.TLS))
+	}))
+	ts.TLS = srvCfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(cfg *tls.Config) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	cfg, err := Client(files.CA, files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	name, err := get(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", name)
+
+	// client without certificate is rejected
+	cfg, err = Client(files.CA, "", "")
+	require.NoError(t, err)
+	_, err = get(cfg)
+	assert.Error(t, err)
+
+	// server isn't trusted without CA
+	cfg, err = Client("", files.ClientCert, files.ClientKey)
+	require.NoError(t, err)
+	_, err = get(cfg)
+	assert.Error(t, err)
+}
+
+func TestPeerName(t *testing.T) {
+	assert.Equal(t, "", PeerName(nil))
+	assert.Equal(t, "", PeerName(&tls.ConnectionState{}))
+}
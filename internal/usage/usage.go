@@ -0,0 +1,242 @@
+// Package usage tracks how clients write metrics: rates of updates of series,
+// writes and new series of each client. It's used to find sources of growth of storage
+package usage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+// GrowthWindow is a period of growth of new series in report
+const GrowthWindow = time.Hour
+
+// IdleTTL is a period after which series without updates and clients without writes
+// are forgotten, so deleted and abandoned series and gone clients don't keep memory of tracker
+const IdleTTL = 24 * time.Hour
+
+// Series is a rate of updates of series
+type Series struct {
+	ID    string `json:"id"`
+	MType string `json:"type"`
+	// updates during the last minute
+	UpdatesPerMinute float64 `json:"updates_per_minute"`
+	// client which created series, empty if series was written by server itself
+	Creator string `json:"creator,omitempty"`
+}
+
+// Client is a usage of client
+type Client struct {
+	Client string `json:"client"`
+	// count of written metrics since start of server
+	Writes int64 `json:"writes"`
+	// written metrics during the last minute
+	WritesPerMinute float64 `json:"writes_per_minute"`
+	// count of series created by client since start of server
+	NewSeries int64     `json:"new_series"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Growth is a count of new series created during one minute
+type Growth struct {
+	Time      time.Time `json:"time"`
+	NewSeries int       `json:"new_series"`
+}
+
+// Report is a usage of server
+type Report struct {
+	// series sorted by rate of updates, the fastest first
+	TopSeries []Series `json:"top_series"`
+	// clients sorted by count of writes, the most active first
+	TopClients []Client `json:"top_clients"`
+	// new series per minute during GrowthWindow, oldest first
+	Growth []Growth `json:"growth"`
+}
+
+// Tracker collects usage of server. Zero value is ready to use
+type Tracker struct {
+	mx      sync.Mutex
+	series  map[key]*seriesStats
+	clients map[string]*clientStats
+	// time of the last expiration of idle series and clients
+	expired time.Time
+}
+
+type key struct {
+	mtype string
+	id    string
+}
+
+type seriesStats struct {
+	created    time.Time
+	lastUpdate time.Time
+	creator    string
+	updates    rate
+}
+
+type clientStats struct {
+	writes    int64
+	newSeries int64
+	lastSeen  time.Time
+	rate      rate
+}
+
+// rate counts events in the current and the previous minute
+type rate struct {
+	start time.Time
+	cur   int64
+	prev  int64
+}
+
+func (r *rate) add(now time.Time, n int64) {
+	r.advance(now)
+	r.cur += n
+}
+
+func (r *rate) advance(now time.Time) {
+	minute := now.Truncate(time.Minute)
+	switch {
+	case minute.Equal(r.start):
+	case minute.Sub(r.start) == time.Minute:
+		r.start, r.prev, r.cur = minute, r.cur, 0
+	default:
+		r.start, r.prev, r.cur = minute, 0, 0
+	}
+}
+
+// perMinute estimates count of events during the last minute by sliding window
+func (r rate) perMinute(now time.Time) float64 {
+	r.advance(now)
+	elapsed := float64(now.Sub(r.start)) / float64(time.Minute)
+	return float64(r.prev)*(1-elapsed) + float64(r.cur)
+}
+
+// Observe counts metrics m written by client. Writes of server itself have empty client,
+// their series are known but aren't counted as new series of clients
+func (t *Tracker) Observe(client string, m []metrics.Metric, now time.Time) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.series == nil {
+		t.series = make(map[key]*seriesStats)
+		t.clients = make(map[string]*clientStats)
+	}
+
+	var c *clientStats
+	if client != "" {
+		c = t.clients[client]
+		if c == nil {
+			c = &clientStats{}
+			t.clients[client] = c
+		}
+		c.writes += int64(len(m))
+		c.lastSeen = now
+		c.rate.add(now, int64(len(m)))
+	}
+
+	for _, el := range m {
+		k := key{el.MType, el.ID}
+		s := t.series[k]
+		if s == nil {
+			s = &seriesStats{created: now, creator: client}
+			t.series[k] = s
+			if c != nil {
+				c.newSeries++
+			}
+		}
+		s.lastUpdate = now
+		s.updates.add(now, 1)
+	}
+	t.expire(now)
+}
+
+// expire forgets series which weren't updated and clients which didn't write during IdleTTL,
+// they are scanned at most once a minute
+func (t *Tracker) expire(now time.Time) {
+	if now.Sub(t.expired) < time.Minute {
+		return
+	}
+	t.expired = now
+	for k, s := range t.series {
+		if now.Sub(s.lastUpdate) > IdleTTL {
+			delete(t.series, k)
+		}
+	}
+	for name, c := range t.clients {
+		if now.Sub(c.lastSeen) > IdleTTL {
+			delete(t.clients, name)
+		}
+	}
+}
+
+// Report returns top series and clients. Rename maps names of series and clients
+// to names in report, false excludes them from report; nil keeps all names
+func (t *Tracker) Report(now time.Time, top int, rename func(string) (string, bool)) Report {
+	if rename == nil {
+		rename = func(s string) (string, bool) { return s, true }
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	from := now.Truncate(time.Minute).Add(-GrowthWindow + time.Minute)
+	growth := make([]Growth, 0, int(GrowthWindow/time.Minute))
+	for m := from; !m.After(now); m = m.Add(time.Minute) {
+		growth = append(growth, Growth{Time: m})
+	}
+
+	series := make([]Series, 0, len(t.series))
+	for k, s := range t.series {
+		id, ok := rename(k.id)
+		if !ok {
+			continue
+		}
+		var creator string
+		if s.creator != "" {
+			creator, _ = rename(s.creator)
+		}
+		series = append(series, Series{ID: id, MType: k.mtype, UpdatesPerMinute: s.updates.perMinute(now), Creator: creator})
+		// series can be created by concurrent write after now was taken
+		if i := int(s.created.Sub(from) / time.Minute); s.creator != "" && !s.created.Before(from) && i < len(growth) {
+			growth[i].NewSeries++
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].UpdatesPerMinute != series[j].UpdatesPerMinute {
+			return series[i].UpdatesPerMinute > series[j].UpdatesPerMinute
+		}
+		if series[i].ID != series[j].ID {
+			return series[i].ID < series[j].ID
+		}
+		return series[i].MType < series[j].MType
+	})
+
+	clients := make([]Client, 0, len(t.clients))
+	for name, c := range t.clients {
+		client, ok := rename(name)
+		if !ok {
+			continue
+		}
+		clients = append(clients, Client{
+			Client:          client,
+			Writes:          c.writes,
+			WritesPerMinute: c.rate.perMinute(now),
+			NewSeries:       c.newSeries,
+			LastSeen:        c.lastSeen,
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Writes != clients[j].Writes {
+			return clients[i].Writes > clients[j].Writes
+		}
+		return clients[i].Client < clients[j].Client
+	})
+
+	if top > 0 && len(series) > top {
+		series = series[:top]
+	}
+	if top > 0 && len(clients) > top {
+		clients = clients[:top]
+	}
+	return Report{TopSeries: series, TopClients: clients, Growth: growth}
+}
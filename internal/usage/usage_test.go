@@ -0,0 +1,104 @@
+package usage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kvvPro/metric-collector/internal/metrics"
+)
+
+func gauge(id string) metrics.Metric {
+	return metrics.Metric{ID: id, MType: metrics.MetricTypeGauge}
+}
+
+func TestTracker_Report(t *testing.T) {
+	var tr Tracker
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// series restored by server aren't new series of clients
+	tr.Observe("", []metrics.Metric{gauge("Alloc")}, start)
+	for i := 0; i < 6; i++ {
+		tr.Observe("10.0.0.1", []metrics.Metric{gauge("Alloc"), gauge("HeapSys")}, start.Add(time.Duration(i)*time.Second))
+	}
+	tr.Observe("10.0.0.2", []metrics.Metric{gauge("a"), gauge("b"), gauge("c")}, start.Add(10*time.Minute))
+
+	now := start.Add(10*time.Minute + 30*time.Second)
+	r := tr.Report(now, 2, nil)
+
+	require.Len(t, r.TopSeries, 2)
+	// updates of previous minutes aren't counted in rate
+	for _, s := range r.TopSeries {
+		assert.Equal(t, 1.0, s.UpdatesPerMinute, s.ID)
+		assert.Equal(t, "10.0.0.2", s.Creator)
+	}
+
+	require.Len(t, r.TopClients, 2)
+	assert.Equal(t, Client{Client: "10.0.0.1", Writes: 12, NewSeries: 1, LastSeen: start.Add(5 * time.Second)}, r.TopClients[0])
+	assert.Equal(t, "10.0.0.2", r.TopClients[1].Client)
+	assert.Equal(t, int64(3), r.TopClients[1].NewSeries)
+	assert.Equal(t, 3.0, r.TopClients[1].WritesPerMinute)
+
+	require.Len(t, r.Growth, int(GrowthWindow/time.Minute))
+	assert.Equal(t, now.Truncate(time.Minute), r.Growth[len(r.Growth)-1].Time)
+	assert.Equal(t, 3, r.Growth[len(r.Growth)-1].NewSeries)
+	assert.Equal(t, 1, r.Growth[len(r.Growth)-11].NewSeries)
+}
+
+func TestTracker_Report_CreatedAfterNow(t *testing.T) {
+	var tr Tracker
+	now := time.Date(2024, 1, 1, 12, 0, 59, 0, time.UTC)
+	tr.Observe("10.0.0.1", []metrics.Metric{gauge("Alloc")}, now.Add(time.Second))
+
+	r := tr.Report(now, 0, nil)
+	require.Len(t, r.TopSeries, 1)
+	for _, g := range r.Growth {
+		assert.Zero(t, g.NewSeries)
+	}
+}
+
+func TestTracker_ExpireIdle(t *testing.T) {
+	var tr Tracker
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr.Observe("10.0.0.1", []metrics.Metric{gauge("Alloc"), gauge("Abandoned")}, start)
+	tr.Observe("10.0.0.2", []metrics.Metric{gauge("Alloc")}, start)
+	for i := time.Duration(1); i <= 25; i++ {
+		tr.Observe("10.0.0.1", []metrics.Metric{gauge("Alloc")}, start.Add(i*time.Hour))
+	}
+
+	r := tr.Report(start.Add(25*time.Hour), 0, nil)
+	require.Len(t, r.TopSeries, 1)
+	assert.Equal(t, "Alloc", r.TopSeries[0].ID)
+	assert.Len(t, tr.series, 1)
+	// client which stopped writing is forgotten too
+	require.Len(t, r.TopClients, 1)
+	assert.Equal(t, "10.0.0.1", r.TopClients[0].Client)
+	assert.Len(t, tr.clients, 1)
+}
+
+func TestTracker_Rename(t *testing.T) {
+	var tr Tracker
+	now := time.Now()
+	tr.Observe("a/10.0.0.1", []metrics.Metric{gauge("a/Alloc")}, now)
+	tr.Observe("b/10.0.0.1", []metrics.Metric{gauge("b/Alloc")}, now)
+
+	r := tr.Report(now, 0, func(s string) (string, bool) { return strings.CutPrefix(s, "a/") })
+	require.Len(t, r.TopSeries, 1)
+	assert.Equal(t, "Alloc", r.TopSeries[0].ID)
+	assert.Equal(t, "10.0.0.1", r.TopSeries[0].Creator)
+	require.Len(t, r.TopClients, 1)
+	assert.Equal(t, "10.0.0.1", r.TopClients[0].Client)
+}
+
+func TestRate(t *testing.T) {
+	var r rate
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.add(start, 10)
+	assert.Equal(t, 10.0, r.perMinute(start.Add(30*time.Second)))
+	// half of previous minute is in sliding window
+	assert.Equal(t, 5.0, r.perMinute(start.Add(90*time.Second)))
+	assert.Equal(t, 0.0, r.perMinute(start.Add(3*time.Minute)))
+}
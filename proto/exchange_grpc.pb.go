@@ -19,7 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	MetricServer_PushMetrics_FullMethodName = "/exchange.MetricServer/PushMetrics"
+	MetricServer_PushMetrics_FullMethodName       = "/exchange.MetricServer/PushMetrics"
+	MetricServer_PushMetricsStream_FullMethodName = "/exchange.MetricServer/PushMetricsStream"
+	MetricServer_GetMetric_FullMethodName         = "/exchange.MetricServer/GetMetric"
+	MetricServer_GetMetrics_FullMethodName        = "/exchange.MetricServer/GetMetrics"
+	MetricServer_ListMetrics_FullMethodName       = "/exchange.MetricServer/ListMetrics"
+	MetricServer_WatchMetrics_FullMethodName      = "/exchange.MetricServer/WatchMetrics"
+	MetricServer_GetCounterRate_FullMethodName    = "/exchange.MetricServer/GetCounterRate"
 )
 
 // MetricServerClient is the client API for MetricServer service.
@@ -27,6 +33,16 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricServerClient interface {
 	PushMetrics(ctx context.Context, in *PushMetricsRequest, opts ...grpc.CallOption) (*PushMetricsResponse, error)
+	// agent keeps stream open and sends batches continuously,
+	// server periodically acknowledges count of applied metrics
+	PushMetricsStream(ctx context.Context, opts ...grpc.CallOption) (MetricServer_PushMetricsStreamClient, error)
+	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error)
+	GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error)
+	// stream of changes of metrics selected by names or prefix
+	WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (MetricServer_WatchMetricsClient, error)
+	// increase and per-second rate of counter over time window
+	GetCounterRate(ctx context.Context, in *GetCounterRateRequest, opts ...grpc.CallOption) (*GetCounterRateResponse, error)
 }
 
 type metricServerClient struct {
@@ -46,11 +62,120 @@ func (c *metricServerClient) PushMetrics(ctx context.Context, in *PushMetricsReq
 	return out, nil
 }
 
+func (c *metricServerClient) PushMetricsStream(ctx context.Context, opts ...grpc.CallOption) (MetricServer_PushMetricsStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricServer_ServiceDesc.Streams[0], MetricServer_PushMetricsStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricServerPushMetricsStreamClient{stream}
+	return x, nil
+}
+
+type MetricServer_PushMetricsStreamClient interface {
+	Send(*PushMetricsRequest) error
+	Recv() (*PushMetricsAck, error)
+	grpc.ClientStream
+}
+
+type metricServerPushMetricsStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricServerPushMetricsStreamClient) Send(m *PushMetricsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricServerPushMetricsStreamClient) Recv() (*PushMetricsAck, error) {
+	m := new(PushMetricsAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricServerClient) GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error) {
+	out := new(GetMetricResponse)
+	err := c.cc.Invoke(ctx, MetricServer_GetMetric_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error) {
+	out := new(GetMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricServer_GetMetrics_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error) {
+	out := new(ListMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricServer_ListMetrics_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (MetricServer_WatchMetricsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricServer_ServiceDesc.Streams[1], MetricServer_WatchMetrics_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricServerWatchMetricsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetricServer_WatchMetricsClient interface {
+	Recv() (*MetricUpdate, error)
+	grpc.ClientStream
+}
+
+type metricServerWatchMetricsClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricServerWatchMetricsClient) Recv() (*MetricUpdate, error) {
+	m := new(MetricUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricServerClient) GetCounterRate(ctx context.Context, in *GetCounterRateRequest, opts ...grpc.CallOption) (*GetCounterRateResponse, error) {
+	out := new(GetCounterRateResponse)
+	err := c.cc.Invoke(ctx, MetricServer_GetCounterRate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricServerServer is the server API for MetricServer service.
 // All implementations must embed UnimplementedMetricServerServer
 // for forward compatibility
 type MetricServerServer interface {
 	PushMetrics(context.Context, *PushMetricsRequest) (*PushMetricsResponse, error)
+	// agent keeps stream open and sends batches continuously,
+	// server periodically acknowledges count of applied metrics
+	PushMetricsStream(MetricServer_PushMetricsStreamServer) error
+	GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error)
+	GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error)
+	// stream of changes of metrics selected by names or prefix
+	WatchMetrics(*WatchMetricsRequest, MetricServer_WatchMetricsServer) error
+	// increase and per-second rate of counter over time window
+	GetCounterRate(context.Context, *GetCounterRateRequest) (*GetCounterRateResponse, error)
 	mustEmbedUnimplementedMetricServerServer()
 }
 
@@ -61,6 +186,24 @@ type UnimplementedMetricServerServer struct {
 func (UnimplementedMetricServerServer) PushMetrics(context.Context, *PushMetricsRequest) (*PushMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushMetrics not implemented")
 }
+func (UnimplementedMetricServerServer) PushMetricsStream(MetricServer_PushMetricsStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PushMetricsStream not implemented")
+}
+func (UnimplementedMetricServerServer) GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
+}
+func (UnimplementedMetricServerServer) GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetrics not implemented")
+}
+func (UnimplementedMetricServerServer) ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
+func (UnimplementedMetricServerServer) WatchMetrics(*WatchMetricsRequest, MetricServer_WatchMetricsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMetrics not implemented")
+}
+func (UnimplementedMetricServerServer) GetCounterRate(context.Context, *GetCounterRateRequest) (*GetCounterRateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCounterRate not implemented")
+}
 func (UnimplementedMetricServerServer) mustEmbedUnimplementedMetricServerServer() {}
 
 // UnsafeMetricServerServer may be embedded to opt out of forward compatibility for this service.
@@ -92,6 +235,125 @@ func _MetricServer_PushMetrics_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricServer_PushMetricsStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricServerServer).PushMetricsStream(&metricServerPushMetricsStreamServer{stream})
+}
+
+type MetricServer_PushMetricsStreamServer interface {
+	Send(*PushMetricsAck) error
+	Recv() (*PushMetricsRequest, error)
+	grpc.ServerStream
+}
+
+type metricServerPushMetricsStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricServerPushMetricsStreamServer) Send(m *PushMetricsAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricServerPushMetricsStreamServer) Recv() (*PushMetricsRequest, error) {
+	m := new(PushMetricsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MetricServer_GetMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).GetMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_GetMetric_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).GetMetric(ctx, req.(*GetMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_GetMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).GetMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_GetMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).GetMetrics(ctx, req.(*GetMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_ListMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_WatchMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMetricsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricServerServer).WatchMetrics(m, &metricServerWatchMetricsServer{stream})
+}
+
+type MetricServer_WatchMetricsServer interface {
+	Send(*MetricUpdate) error
+	grpc.ServerStream
+}
+
+type metricServerWatchMetricsServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricServerWatchMetricsServer) Send(m *MetricUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetricServer_GetCounterRate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCounterRateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).GetCounterRate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_GetCounterRate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).GetCounterRate(ctx, req.(*GetCounterRateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricServer_ServiceDesc is the grpc.ServiceDesc for MetricServer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -103,7 +365,194 @@ var MetricServer_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PushMetrics",
 			Handler:    _MetricServer_PushMetrics_Handler,
 		},
+		{
+			MethodName: "GetMetric",
+			Handler:    _MetricServer_GetMetric_Handler,
+		},
+		{
+			MethodName: "GetMetrics",
+			Handler:    _MetricServer_GetMetrics_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _MetricServer_ListMetrics_Handler,
+		},
+		{
+			MethodName: "GetCounterRate",
+			Handler:    _MetricServer_GetCounterRate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PushMetricsStream",
+			Handler:       _MetricServer_PushMetricsStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchMetrics",
+			Handler:       _MetricServer_WatchMetrics_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "exchange.proto",
+}
+
+const (
+	Replication_Subscribe_FullMethodName = "/exchange.Replication/Subscribe"
+	Replication_Promote_FullMethodName   = "/exchange.Replication/Promote"
+)
+
+// ReplicationClient is the client API for Replication service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ReplicationClient interface {
+	// follower receives full state of leader and then all applied changes
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Replication_SubscribeClient, error)
+	// follower stops replication and starts to accept writes
+	Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error)
+}
+
+type replicationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewReplicationClient(cc grpc.ClientConnInterface) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Replication_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[0], Replication_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_SubscribeClient interface {
+	Recv() (*ReplicationEvent, error)
+	grpc.ClientStream
+}
+
+type replicationSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationSubscribeClient) Recv() (*ReplicationEvent, error) {
+	m := new(ReplicationEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *replicationClient) Promote(ctx context.Context, in *PromoteRequest, opts ...grpc.CallOption) (*PromoteResponse, error) {
+	out := new(PromoteResponse)
+	err := c.cc.Invoke(ctx, Replication_Promote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReplicationServer is the server API for Replication service.
+// All implementations must embed UnimplementedReplicationServer
+// for forward compatibility
+type ReplicationServer interface {
+	// follower receives full state of leader and then all applied changes
+	Subscribe(*SubscribeRequest, Replication_SubscribeServer) error
+	// follower stops replication and starts to accept writes
+	Promote(context.Context, *PromoteRequest) (*PromoteResponse, error)
+	mustEmbedUnimplementedReplicationServer()
+}
+
+// UnimplementedReplicationServer must be embedded to have forward compatible implementations.
+type UnimplementedReplicationServer struct {
+}
+
+func (UnimplementedReplicationServer) Subscribe(*SubscribeRequest, Replication_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedReplicationServer) Promote(context.Context, *PromoteRequest) (*PromoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Promote not implemented")
+}
+func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
+
+// UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ReplicationServer will
+// result in compilation errors.
+type UnsafeReplicationServer interface {
+	mustEmbedUnimplementedReplicationServer()
+}
+
+func RegisterReplicationServer(s grpc.ServiceRegistrar, srv ReplicationServer) {
+	s.RegisterService(&Replication_ServiceDesc, srv)
+}
+
+func _Replication_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).Subscribe(m, &replicationSubscribeServer{stream})
+}
+
+type Replication_SubscribeServer interface {
+	Send(*ReplicationEvent) error
+	grpc.ServerStream
+}
+
+type replicationSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationSubscribeServer) Send(m *ReplicationEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Replication_Promote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).Promote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Replication_Promote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).Promote(ctx, req.(*PromoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Replication_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "exchange.Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Promote",
+			Handler:    _Replication_Promote_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Replication_Subscribe_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "exchange.proto",
 }